@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+)
+
+// adminUsage describes the admin subcommands.
+const adminUsage = `Usage: token-issuer admin <subcommand> [flags]
+
+Subcommands:
+  rotate-key  Verify a new GitHub App private key against GitHub and upload it to Secret Manager
+`
+
+// PrivateKeyStore stores the versions of the GitHub App private key.
+type PrivateKeyStore interface {
+	// EnabledVersions returns the names of the enabled versions, newest first.
+	EnabledVersions(ctx context.Context) ([]string, error)
+
+	// AddVersion adds a new version with data and returns its name.
+	AddVersion(ctx context.Context, data []byte) (string, error)
+
+	// DisableVersion disables the version.
+	DisableVersion(ctx context.Context, version string) error
+}
+
+// RotateKeyOptions configures RotateKey.
+type RotateKeyOptions struct {
+	// KeyPEM is the new PEM-encoded GitHub App private key.
+	KeyPEM []byte
+
+	// DisablePrevious disables the previously enabled versions after GracePeriod.
+	DisablePrevious bool
+	GracePeriod     time.Duration
+}
+
+// RunAdmin runs an admin subcommand.
+func RunAdmin(ctx context.Context, config *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing subcommand\n\n%s", adminUsage)
+	}
+
+	switch args[0] {
+	case "rotate-key":
+		return runRotateKey(ctx, config, args[1:])
+	default:
+		return fmt.Errorf("unknown subcommand '%s'\n\n%s", args[0], adminUsage)
+	}
+}
+
+// runRotateKey parses the rotate-key flags and rotates the key stored in Secret Manager.
+func runRotateKey(ctx context.Context, config *Config, args []string) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	keyFile := flags.String("key-file", "", "new PEM-encoded GitHub App private key file (- for stdin)")
+	disablePrevious := flags.Bool("disable-previous", false, "disable the previously enabled key versions after the grace period")
+	gracePeriod := flags.Duration("grace-period", 10*time.Minute, "time to wait before disabling the previous key versions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		return fmt.Errorf("-key-file is required")
+	}
+	if config.ProjectID == "" {
+		return fmt.Errorf("GCP project ID not configured")
+	}
+
+	var keyPEM []byte
+	var err error
+	if *keyFile == "-" {
+		keyPEM, err = io.ReadAll(os.Stdin)
+	} else {
+		keyPEM, err = os.ReadFile(*keyFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	client, err := secretmanager.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create Secret Manager client: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	_, err = RotateKey(ctx, config, &secretManagerKeyStore{client: client, projectID: config.ProjectID}, RotateKeyOptions{
+		KeyPEM:          keyPEM,
+		DisablePrevious: *disablePrevious,
+		GracePeriod:     *gracePeriod,
+	})
+	return err
+}
+
+// RotateKey rotates the GitHub App private key stored in store:
+//  1. The new key is parsed like the keys read by the service
+//  2. GitHub must accept a JWT signed with the new key for the App's /app endpoint
+//  3. The key is uploaded as a new version, which the service prefers from the next request on
+//  4. Optionally, the previously enabled versions are disabled after the grace period
+//
+// It returns the name of the new version.
+func RotateKey(ctx context.Context, config *Config, store PrivateKeyStore, opts RotateKeyOptions) (string, error) {
+	if config.GitHubAppID == "" {
+		return "", fmt.Errorf("GITHUB_APP_ID not configured")
+	}
+
+	// Validate the new key
+	privateKey, err := ParsePrivateKey(opts.KeyPEM)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	key, err := NewAppKey("new", privateKey)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("New key fingerprint: %s", key.Fingerprint)
+
+	// Verify GitHub accepts the new key
+	jwtToken, err := CreateJWT(privateKey, config.GitHubAppID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create JWT: %w", err)
+	}
+	githubClient, err := NewGitHubClientWithJWTForURL(jwtToken, config.GitHubAPIURL)
+	if err != nil {
+		return "", err
+	}
+	app, _, err := githubClient.Apps.Get(ctx, "")
+	if err != nil {
+		if isBadCredentialsError(err) {
+			return "", fmt.Errorf("GitHub rejected the new key, add it in the GitHub App settings first: %w", err)
+		}
+		return "", fmt.Errorf("GitHub API error: %w", err)
+	}
+	if strconv.FormatInt(app.GetID(), 10) != config.GitHubAppID {
+		return "", fmt.Errorf("new key belongs to GitHub App %d, not %s", app.GetID(), config.GitHubAppID)
+	}
+	log.Printf("GitHub accepted the new key for App %s (%s)", app.GetSlug(), config.GitHubAppID)
+
+	// Upload the new key
+	previous, err := store.EnabledVersions(ctx)
+	if err != nil {
+		return "", err
+	}
+	version, err := store.AddVersion(ctx, opts.KeyPEM)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Uploaded new key version %s", version)
+
+	if !opts.DisablePrevious || len(previous) == 0 {
+		return version, nil
+	}
+
+	// Disable the previous keys once in-flight requests signed with them are done
+	log.Printf("Waiting %s before disabling %d previous key versions", opts.GracePeriod, len(previous))
+	select {
+	case <-ctx.Done():
+		return version, fmt.Errorf("interrupted before disabling previous key versions: %w", ctx.Err())
+	case <-time.After(opts.GracePeriod):
+	}
+	for _, previousVersion := range previous {
+		if err := store.DisableVersion(ctx, previousVersion); err != nil {
+			return version, err
+		}
+		log.Printf("Disabled previous key version %s", previousVersion)
+	}
+	log.Printf("Remember to delete the previous keys in the GitHub App settings")
+
+	return version, nil
+}
+
+// secretManagerKeyStore stores the GitHub App private key versions in GCP Secret Manager.
+type secretManagerKeyStore struct {
+	client    *secretmanager.Client
+	projectID string
+}
+
+// EnabledVersions returns the names of the enabled versions, newest first.
+func (s *secretManagerKeyStore) EnabledVersions(ctx context.Context) ([]string, error) {
+	versions, err := listEnabledSecretVersions(ctx, s.client, s.projectID)
+	if err != nil {
+		return nil, err
+	}
+	return newestSecretVersions(versions, len(versions)), nil
+}
+
+// AddVersion adds a new secret version with data and returns its name.
+func (s *secretManagerKeyStore) AddVersion(ctx context.Context, data []byte) (string, error) {
+	checksum := int64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	version, err := s.client.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
+		Parent:  fmt.Sprintf("projects/%s/secrets/%s", s.projectID, privateKeySecretName),
+		Payload: &secretmanagerpb.SecretPayload{Data: data, DataCrc32C: &checksum},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to add private key version to Secret Manager: %w", err)
+	}
+	return version.GetName(), nil
+}
+
+// DisableVersion disables the secret version.
+func (s *secretManagerKeyStore) DisableVersion(ctx context.Context, version string) error {
+	if _, err := s.client.DisableSecretVersion(ctx, &secretmanagerpb.DisableSecretVersionRequest{Name: version}); err != nil {
+		return fmt.Errorf("failed to disable private key version %s: %w", version, err)
+	}
+	return nil
+}
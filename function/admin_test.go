@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakePrivateKeyStore is an in-memory PrivateKeyStore.
+type fakePrivateKeyStore struct {
+	versions []string
+	enabled  map[string]bool
+	data     map[string][]byte
+}
+
+func newFakePrivateKeyStore(enabledVersions ...string) *fakePrivateKeyStore {
+	store := &fakePrivateKeyStore{enabled: make(map[string]bool), data: make(map[string][]byte)}
+	for _, version := range enabledVersions {
+		store.versions = append(store.versions, version)
+		store.enabled[version] = true
+	}
+	return store
+}
+
+func (s *fakePrivateKeyStore) EnabledVersions(ctx context.Context) ([]string, error) {
+	var enabled []string
+	for i := len(s.versions) - 1; i >= 0; i-- {
+		if s.enabled[s.versions[i]] {
+			enabled = append(enabled, s.versions[i])
+		}
+	}
+	return enabled, nil
+}
+
+func (s *fakePrivateKeyStore) AddVersion(ctx context.Context, data []byte) (string, error) {
+	version := fmt.Sprintf("versions/%d", len(s.versions)+1)
+	s.versions = append(s.versions, version)
+	s.enabled[version] = true
+	s.data[version] = data
+	return version, nil
+}
+
+func (s *fakePrivateKeyStore) DisableVersion(ctx context.Context, version string) error {
+	s.enabled[version] = false
+	return nil
+}
+
+// TestRotateKey tests rotation of the GitHub App private key.
+//
+// Test steps:
+//  1. Start fake GitHub knowing the test case App ID and public key
+//  2. Create key store with two enabled versions
+//  3. Call RotateKey with the new key
+//  4. Verify the new version is uploaded and previous versions are disabled when requested
+//  5. Verify invalid keys and keys rejected by GitHub are not uploaded
+func TestRotateKey(t *testing.T) {
+	newKey := generateTestRSAKey(t)
+	newKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(newKey)})
+
+	tests := []struct {
+		name            string
+		githubAppID     int64
+		githubKey       *rsa.PublicKey
+		keyPEM          []byte
+		disablePrevious bool
+		wantEnabled     []string
+		errContains     string
+	}{
+		{
+			name:        "keep previous versions",
+			githubAppID: 1,
+			githubKey:   &newKey.PublicKey,
+			keyPEM:      newKeyPEM,
+			wantEnabled: []string{"versions/3", "versions/2", "versions/1"},
+		},
+		{
+			name:            "disable previous versions",
+			githubAppID:     1,
+			githubKey:       &newKey.PublicKey,
+			keyPEM:          newKeyPEM,
+			disablePrevious: true,
+			wantEnabled:     []string{"versions/3"},
+		},
+		{
+			name:        "invalid key",
+			githubAppID: 1,
+			githubKey:   &newKey.PublicKey,
+			keyPEM:      []byte("not a key"),
+			wantEnabled: []string{"versions/2", "versions/1"},
+			errContains: "invalid private key",
+		},
+		{
+			name:        "key not registered on GitHub",
+			githubAppID: 1,
+			githubKey:   &generateTestRSAKey(t).PublicKey,
+			keyPEM:      newKeyPEM,
+			wantEnabled: []string{"versions/2", "versions/1"},
+			errContains: "GitHub rejected the new key",
+		},
+		{
+			name:        "key of another App",
+			githubAppID: 1,
+			githubKey:   nil,
+			keyPEM:      newKeyPEM,
+			wantEnabled: []string{"versions/2", "versions/1"},
+			errContains: "belongs to GitHub App",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Start fake GitHub
+			fake := NewFakeGitHub(tt.githubAppID)
+			fake.AppPublicKey = tt.githubKey
+			githubServer := httptest.NewServer(fake)
+			defer githubServer.Close()
+
+			config := testConfig()
+			config.GitHubAPIURL = githubServer.URL
+			if tt.githubKey == nil {
+				// Without JWT validation, GitHub answers for App 1 while App 2 is configured
+				config.GitHubAppID = "2"
+			}
+
+			// Step 2: Create key store
+			store := newFakePrivateKeyStore("versions/1", "versions/2")
+
+			// Step 3: Rotate key
+			version, err := RotateKey(context.Background(), config, store, RotateKeyOptions{
+				KeyPEM:          tt.keyPEM,
+				DisablePrevious: tt.disablePrevious,
+			})
+
+			// Step 5: Verify errors
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("RotateKey() error = %v, want containing %q", err, tt.errContains)
+				}
+			} else if err != nil {
+				t.Fatalf("RotateKey() unexpected error = %v", err)
+			} else if string(store.data[version]) != string(tt.keyPEM) {
+				t.Errorf("RotateKey() uploaded %q to %s, want the new key", store.data[version], version)
+			}
+
+			// Step 4: Verify enabled versions
+			enabled, _ := store.EnabledVersions(context.Background())
+			if !reflect.DeepEqual(enabled, tt.wantEnabled) {
+				t.Errorf("enabled versions = %v, want %v", enabled, tt.wantEnabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Approval request statuses. ApprovalExpired is never stored; it is derived from ExpiresAt.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+	ApprovalReleased = "released"
+	ApprovalExpired  = "expired"
+)
+
+// Approval decision actions.
+const (
+	approvalActionApprove = "approve"
+	approvalActionDeny    = "deny"
+)
+
+// Authorizations of approval decisions, recorded as DecidedBy. Approvers aren't authenticated individually,
+// so the decision records how it was authorized rather than a name the approver could choose freely.
+const (
+	approvalAuthSignedLink = "signed link"
+	approvalAuthSecret     = "approval secret"
+)
+
+// Headers authorizing approval requests. Authorization is never used: Cloud Run IAM may own it,
+// and browsers opening approval links have no credentials.
+const (
+	approvalTokenHeader  = "X-Approval-Token"
+	approvalSecretHeader = "X-Approval-Secret"
+)
+
+// approvalWebhookTimeout is the timeout of approval request notifications.
+const approvalWebhookTimeout = 10 * time.Second
+
+// errApprovalUnchanged aborts approval request updates that don't apply to the current status.
+var errApprovalUnchanged = errors.New("approval request unchanged")
+
+// Approval is a token request held until a human approver approves or denies it.
+type Approval struct {
+	ID string `json:"id"`
+
+	// PollTokenHash is the hex SHA-256 hash of the poll token returned to the requester.
+	PollTokenHash string `json:"poll_token_hash"`
+
+	Repository        string            `json:"repository"`
+	RepositoryID      int64             `json:"repository_id,omitempty"`
+	RepositoryOwnerID int64             `json:"repository_owner_id,omitempty"`
+	Scopes            map[string]string `json:"scopes"`
+	OptionalScopes    []string          `json:"optional_scopes,omitempty"`
+	Downgrades        []ScopeDowngrade  `json:"downgrades,omitempty"`
+	Profile           string            `json:"profile,omitempty"`
+	Lifetime          time.Duration     `json:"lifetime,omitempty"`
+	ApprovalScopes    []string          `json:"approval_scopes"`
+	Subject           string            `json:"subject,omitempty"`
+	Workflow          string            `json:"workflow,omitempty"`
+
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+
+	// DecidedBy is the authorization of the decision: approvalAuthSignedLink or approvalAuthSecret.
+	DecidedBy string `json:"decided_by,omitempty"`
+}
+
+// clone returns a deep copy of the approval request.
+func (a *Approval) clone() Approval {
+	clone := *a
+	clone.Scopes = make(map[string]string, len(a.Scopes))
+	for scopeID, permission := range a.Scopes {
+		clone.Scopes[scopeID] = permission
+	}
+	clone.OptionalScopes = append([]string(nil), a.OptionalScopes...)
+	clone.Downgrades = append([]ScopeDowngrade(nil), a.Downgrades...)
+	clone.ApprovalScopes = append([]string(nil), a.ApprovalScopes...)
+	if a.DecidedAt != nil {
+		decidedAt := *a.DecidedAt
+		clone.DecidedAt = &decidedAt
+	}
+	return clone
+}
+
+// tokenRepository returns the repository the released token is restricted to.
+func (a *Approval) tokenRepository() TokenRepository {
+	return TokenRepository{ID: a.RepositoryID, OwnerID: a.RepositoryOwnerID, Name: a.Repository}
+}
+
+// status returns the status at now: ApprovalExpired for pending and approved requests past ExpiresAt.
+func (a *Approval) status(now time.Time) string {
+	if (a.Status == ApprovalPending || a.Status == ApprovalApproved) && !now.Before(a.ExpiresAt) {
+		return ApprovalExpired
+	}
+	return a.Status
+}
+
+// ApprovalResponse is the response of pending approval requests and approval decisions.
+type ApprovalResponse struct {
+	ApprovalID     string   `json:"approval_id"`
+	Status         string   `json:"status"`
+	StatusURL      string   `json:"status_url,omitempty"`
+	PollToken      string   `json:"poll_token,omitempty"`
+	ExpiresAt      string   `json:"expires_at,omitempty"`
+	ApprovalScopes []string `json:"approval_scopes,omitempty"`
+}
+
+// approvalNotification is the payload posted to the approval webhook.
+type approvalNotification struct {
+	ApprovalID        string            `json:"approval_id"`
+	Repository        string            `json:"repository"`
+	RepositoryID      int64             `json:"repository_id,omitempty"`
+	RepositoryOwnerID int64             `json:"repository_owner_id,omitempty"`
+	Scopes            map[string]string `json:"scopes"`
+	OptionalScopes    []string          `json:"optional_scopes,omitempty"`
+	DowngradedScopes  []ScopeDowngrade  `json:"downgraded_scopes,omitempty"`
+	Profile           string            `json:"profile,omitempty"`
+	ApprovalScopes    []string          `json:"approval_scopes"`
+	Subject           string            `json:"subject,omitempty"`
+	Workflow          string            `json:"workflow,omitempty"`
+	ExpiresAt         string            `json:"expires_at"`
+	ApproveURL        string            `json:"approve_url"`
+	DenyURL           string            `json:"deny_url"`
+}
+
+// NewApprovalStore returns the approval store of the configuration: a FileApprovalStore if ApprovalStoreFile
+// is set, a MemoryApprovalStore otherwise (dev mode only), or nil if no scopes require approval.
+func NewApprovalStore(config *Config) ApprovalStore {
+	switch {
+	case len(config.ApprovalScopes) == 0:
+		return nil
+	case config.ApprovalStoreFile != "":
+		return NewFileApprovalStore(config.ApprovalStoreFile)
+	default:
+		return NewMemoryApprovalStore()
+	}
+}
+
+// approvalSignature returns the signature of an approval link: the base64url HMAC-SHA256 of "id:action".
+func approvalSignature(secret, id, action string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id + ":" + action))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// approvalLinkQuery returns the query of a signed approval link.
+func approvalLinkQuery(secret, id, action string) string {
+	return url.Values{"sig": {approvalSignature(secret, id, action)}}.Encode()
+}
+
+// hashPollToken returns the hex SHA-256 hash of a poll token.
+func hashPollToken(pollToken string) string {
+	sum := sha256.Sum256([]byte(pollToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes encoded with encode.
+func randomToken(n int, encode func([]byte) string) (string, error) {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return encode(data), nil
+}
+
+// newApproval creates a pending approval request for scopes requiring approval, with a random ID and poll token.
+func newApproval(claims *OIDCClaims, scopes map[string]string, optional []string, downgrades []ScopeDowngrade, profile string,
+	lifetime time.Duration, requested []string, ttl time.Duration) (*Approval, string, error) {
+	id, err := randomToken(16, hex.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	pollToken, err := randomToken(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	return &Approval{
+		ID:                id,
+		PollTokenHash:     hashPollToken(pollToken),
+		Repository:        claims.Repository,
+		RepositoryID:      claims.RepositoryID,
+		RepositoryOwnerID: claims.RepositoryOwnerID,
+		Scopes:            scopes,
+		OptionalScopes:    optional,
+		Downgrades:        downgrades,
+		Profile:           profile,
+		Lifetime:          lifetime,
+		ApprovalScopes:    requested,
+		Subject:           claims.Claim("sub"),
+		Workflow:          claims.Claim("workflow_ref"),
+		Status:            ApprovalPending,
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(ttl),
+	}, pollToken, nil
+}
+
+// requestApproval stores an approval request for scopes requiring approval, notifies the approvers
+// and writes a 202 response with the approval ID and the poll token of the status endpoint.
+func (s *Server) requestApproval(ctx context.Context, w http.ResponseWriter, r *http.Request, logger *RequestLogger,
+	claims *OIDCClaims, scopes map[string]string, optional []string, downgrades []ScopeDowngrade, profile string, lifetime time.Duration,
+	requested []string) {
+	approval, pollToken, err := newApproval(claims, scopes, optional, downgrades, profile, lifetime, requested, s.config.ApprovalTTL)
+	if err == nil {
+		err = s.approvals.Create(ctx, approval)
+	}
+	if err != nil {
+		logger.LogValidationError("approval", err.Error())
+		logger.LogResponse(http.StatusInternalServerError, nil)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create approval request: %v", err), nil)
+		return
+	}
+
+	statusURL := s.approvalBaseURL(r) + "/approvals/" + approval.ID
+	log.Printf("Approval request %s: %s requested %s (expires at %s)",
+		approval.ID, approval.Repository, strings.Join(approval.ApprovalScopes, ", "), approval.ExpiresAt.Format(time.RFC3339))
+
+	if s.config.ApprovalWebhookURL != "" {
+		// Links sent to approvers use the configured base URL only, never request headers
+		approvalURL := strings.TrimSuffix(s.config.ApprovalBaseURL, "/") + "/approvals/" + approval.ID
+		notification := approvalNotification{
+			ApprovalID:        approval.ID,
+			Repository:        approval.Repository,
+			RepositoryID:      approval.RepositoryID,
+			RepositoryOwnerID: approval.RepositoryOwnerID,
+			Scopes:            approval.Scopes,
+			OptionalScopes:    approval.OptionalScopes,
+			DowngradedScopes:  approval.Downgrades,
+			Profile:           approval.Profile,
+			ApprovalScopes:    approval.ApprovalScopes,
+			Subject:           approval.Subject,
+			Workflow:          approval.Workflow,
+			ExpiresAt:         approval.ExpiresAt.Format(time.RFC3339),
+			ApproveURL:        approvalURL + "/approve?" + approvalLinkQuery(s.config.ApprovalSecret, approval.ID, approvalActionApprove),
+			DenyURL:           approvalURL + "/deny?" + approvalLinkQuery(s.config.ApprovalSecret, approval.ID, approvalActionDeny),
+		}
+		if err := notifyApprovers(ctx, s.config.ApprovalWebhookURL, notification); err != nil {
+			logger.LogValidationError("approval", err.Error())
+			logger.LogResponse(http.StatusServiceUnavailable, nil)
+			writeError(w, http.StatusServiceUnavailable, err.Error(), nil)
+			return
+		}
+	}
+
+	logger.LogResponse(http.StatusAccepted, nil)
+	writeJSON(w, http.StatusAccepted, ApprovalResponse{
+		ApprovalID:     approval.ID,
+		Status:         ApprovalPending,
+		StatusURL:      statusURL,
+		PollToken:      pollToken,
+		ExpiresAt:      approval.ExpiresAt.Format(time.RFC3339),
+		ApprovalScopes: approval.ApprovalScopes,
+	})
+}
+
+// approvalBaseURL returns ApprovalBaseURL, or the base URL of the request for the status URL returned to the requester.
+func (s *Server) approvalBaseURL(r *http.Request) string {
+	if s.config.ApprovalBaseURL != "" {
+		return strings.TrimSuffix(s.config.ApprovalBaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// notifyApprovers posts the approval request notification to the webhook.
+func notifyApprovers(ctx context.Context, webhookURL string, notification approvalNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, approvalWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to notify approvers: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to notify approvers: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to notify approvers: webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ApprovalStatusHandler handles GET /approvals/{id} requests polled by the requester with the poll token (X-Approval-Token).
+// Pending requests return 202. Once approved, the token is created and returned exactly once.
+func (s *Server) ApprovalStatusHandler(w http.ResponseWriter, r *http.Request) {
+	logger := NewRequestLogger(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	id := r.PathValue("id")
+	approval, err := s.approvals.Get(ctx, id)
+	if err != nil {
+		writeApprovalStoreError(w, logger, err)
+		return
+	}
+	logger.SetRepository(approval.Repository)
+	logger.SetRepositoryIDs(approval.RepositoryID, approval.RepositoryOwnerID)
+
+	pollToken := r.Header.Get(approvalTokenHeader)
+	if pollToken == "" || subtle.ConstantTimeCompare([]byte(hashPollToken(pollToken)), []byte(approval.PollTokenHash)) != 1 {
+		logger.LogValidationError("auth", "invalid poll token")
+		logger.LogResponse(http.StatusUnauthorized, nil)
+		writeError(w, http.StatusUnauthorized, "invalid approval poll token", nil)
+		return
+	}
+
+	// Mark an approved request as released, so that its token is only issued once
+	approval, err = s.approvals.Update(ctx, id, func(approval *Approval) error {
+		if approval.status(time.Now()) == ApprovalApproved {
+			approval.Status = ApprovalReleased
+			return nil
+		}
+		return errApprovalUnchanged
+	})
+	if errors.Is(err, errApprovalUnchanged) {
+		approval, err = s.approvals.Get(ctx, id)
+		if err != nil {
+			writeApprovalStoreError(w, logger, err)
+			return
+		}
+		writeApprovalStatus(w, logger, approval)
+		return
+	}
+	if err != nil {
+		writeApprovalStoreError(w, logger, err)
+		return
+	}
+
+	repository := approval.tokenRepository()
+	githubClient, installation, ok := s.lookupInstallation(ctx, w, logger, repository)
+	if ok {
+		ok = s.writeInstallationToken(ctx, w, logger, githubClient, installation, repository, approval.Scopes, approval.OptionalScopes,
+			approval.Downgrades, approval.Profile, approval.Lifetime)
+	}
+	if !ok {
+		// Allow the requester to retry until the approval expires
+		_, err := s.approvals.Update(context.WithoutCancel(ctx), id, func(approval *Approval) error {
+			approval.Status = ApprovalApproved
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to reset released approval request %s: %v", id, err)
+		}
+	}
+}
+
+// writeApprovalStatus writes the response of an approval request that isn't released by this request.
+func writeApprovalStatus(w http.ResponseWriter, logger *RequestLogger, approval *Approval) {
+	details := map[string]interface{}{"approval_id": approval.ID}
+	switch status := approval.status(time.Now()); status {
+	case ApprovalPending:
+		logger.LogResponse(http.StatusAccepted, nil)
+		writeJSON(w, http.StatusAccepted, ApprovalResponse{
+			ApprovalID:     approval.ID,
+			Status:         status,
+			ExpiresAt:      approval.ExpiresAt.Format(time.RFC3339),
+			ApprovalScopes: approval.ApprovalScopes,
+		})
+	case ApprovalDenied:
+		details["decided_by"] = approval.DecidedBy
+		logger.LogValidationError("approval", "denied")
+		logger.LogResponse(http.StatusForbidden, nil)
+		writeError(w, http.StatusForbidden, "approval request denied", details)
+	case ApprovalReleased:
+		logger.LogValidationError("approval", "already released")
+		logger.LogResponse(http.StatusGone, nil)
+		writeError(w, http.StatusGone, "token of approval request already issued", details)
+	default:
+		logger.LogValidationError("approval", "expired")
+		logger.LogResponse(http.StatusGone, nil)
+		writeError(w, http.StatusGone, "approval request expired", details)
+	}
+}
+
+// writeApprovalStoreError writes the response of an approval store error.
+func writeApprovalStoreError(w http.ResponseWriter, logger *RequestLogger, err error) {
+	if errors.Is(err, errApprovalNotFound) {
+		logger.LogValidationError("approval", "not found")
+		logger.LogResponse(http.StatusNotFound, nil)
+		writeError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+	logger.LogValidationError("approval", err.Error())
+	logger.LogResponse(http.StatusInternalServerError, nil)
+	writeError(w, http.StatusInternalServerError, fmt.Sprintf("approval store error: %v", err), nil)
+}
+
+// approvalPage renders the approval request confirmation and decision pages of signed links.
+// Signed links only show a form, so that link previews can't approve requests.
+var approvalPage = template.Must(template.New("approval").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Token approval request</title></head>
+<body>
+<h1>Token approval request {{.Approval.ID}}</h1>
+<p>Repository: <b>{{.Approval.Repository}}</b></p>
+<p>Scopes requiring approval: <b>{{range $i, $scope := .Approval.ApprovalScopes}}{{if $i}}, {{end}}{{$scope}}{{end}}</b></p>
+<p>All scopes: {{range $scope, $permission := .Approval.Scopes}}{{$scope}}:{{$permission}} {{end}}</p>
+{{with .Approval.Subject}}<p>Subject: {{.}}</p>{{end}}
+{{with .Approval.Workflow}}<p>Workflow: {{.}}</p>{{end}}
+<p>Status: <b>{{.Status}}</b></p>
+{{if eq .Status "pending"}}
+<form method="post">
+<input type="hidden" name="sig" value="{{.Signature}}">
+<button type="submit">{{if eq .Action "approve"}}Approve{{else}}Deny{{end}}</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// ApprovalConfirmHandler handles GET /approvals/{id}/{action} requests of signed approval links.
+// It shows the approval request with a form submitting the decision.
+func (s *Server) ApprovalConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	logger := NewRequestLogger(r)
+	id, action, signature := r.PathValue("id"), r.PathValue("action"), r.URL.Query().Get("sig")
+	if _, ok := s.validApprovalAction(w, logger, id, action, signature, r.Header.Get(approvalSecretHeader)); !ok {
+		return
+	}
+
+	approval, err := s.approvals.Get(r.Context(), id)
+	if err != nil {
+		writeApprovalStoreError(w, logger, err)
+		return
+	}
+	logger.SetRepository(approval.Repository)
+	logger.SetRepositoryIDs(approval.RepositoryID, approval.RepositoryOwnerID)
+
+	logger.LogResponse(http.StatusOK, nil)
+	writeApprovalPage(w, approval, action, signature)
+}
+
+// ApprovalDecisionHandler handles POST /approvals/{id}/{action} requests approving or denying a pending
+// approval request. Requests are authorized by the signature of an approval link (sig form value),
+// or by the approval secret (X-Approval-Secret). The decision records which of them authorized it.
+func (s *Server) ApprovalDecisionHandler(w http.ResponseWriter, r *http.Request) {
+	logger := NewRequestLogger(r)
+	id, action, signature := r.PathValue("id"), r.PathValue("action"), r.FormValue("sig")
+	authorization, ok := s.validApprovalAction(w, logger, id, action, signature, r.Header.Get(approvalSecretHeader))
+	if !ok {
+		return
+	}
+
+	status := ApprovalApproved
+	if action == approvalActionDeny {
+		status = ApprovalDenied
+	}
+	var current string
+	approval, err := s.approvals.Update(r.Context(), id, func(approval *Approval) error {
+		now := time.Now().UTC()
+		if current = approval.status(now); current != ApprovalPending {
+			return errApprovalUnchanged
+		}
+		approval.Status = status
+		approval.DecidedAt = &now
+		approval.DecidedBy = authorization
+		return nil
+	})
+	if errors.Is(err, errApprovalUnchanged) {
+		logger.LogValidationError("approval", "already "+current)
+		logger.LogResponse(http.StatusConflict, nil)
+		writeError(w, http.StatusConflict, fmt.Sprintf("approval request is %s", current), map[string]interface{}{"approval_id": id, "status": current})
+		return
+	}
+	if err != nil {
+		writeApprovalStoreError(w, logger, err)
+		return
+	}
+	logger.SetRepository(approval.Repository)
+	logger.SetRepositoryIDs(approval.RepositoryID, approval.RepositoryOwnerID)
+	log.Printf("Approval request %s %s with the %s", approval.ID, approval.Status, authorization)
+
+	logger.LogResponse(http.StatusOK, nil)
+	if signature != "" {
+		writeApprovalPage(w, approval, action, signature)
+		return
+	}
+	writeJSON(w, http.StatusOK, ApprovalResponse{ApprovalID: approval.ID, Status: approval.Status})
+}
+
+// validApprovalAction checks the action and the authorization of an approval decision:
+// the signature of the approval link, or the approval secret.
+// Returns the authorization of the decision. On failure, the error response is written and ok is false.
+func (s *Server) validApprovalAction(w http.ResponseWriter, logger *RequestLogger, id, action, signature, approvalSecret string) (string, bool) {
+	if action != approvalActionApprove && action != approvalActionDeny {
+		logger.LogValidationError("approval", "invalid action")
+		logger.LogResponse(http.StatusNotFound, nil)
+		writeError(w, http.StatusNotFound, fmt.Sprintf("invalid approval action '%s' (must be 'approve' or 'deny')", action), nil)
+		return "", false
+	}
+
+	secret := s.config.ApprovalSecret
+	switch {
+	case signature != "" && hmac.Equal([]byte(signature), []byte(approvalSignature(secret, id, action))):
+		return approvalAuthSignedLink, true
+	case approvalSecret != "" && subtle.ConstantTimeCompare([]byte(approvalSecret), []byte(secret)) == 1:
+		return approvalAuthSecret, true
+	}
+
+	logger.LogValidationError("auth", "invalid approval signature")
+	logger.LogResponse(http.StatusForbidden, nil)
+	writeError(w, http.StatusForbidden, "invalid approval link signature or secret", nil)
+	return "", false
+}
+
+// writeApprovalPage renders the approval page of a signed link.
+func writeApprovalPage(w http.ResponseWriter, approval *Approval, action, signature string) {
+	var page bytes.Buffer
+	err := approvalPage.Execute(&page, map[string]interface{}{
+		"Approval":  approval,
+		"Status":    approval.status(time.Now()),
+		"Action":    action,
+		"Signature": signature,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to render approval page: %v", err), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(page.Bytes())
+}
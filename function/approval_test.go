@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testApprovalSecret is the approval secret of approval tests.
+const testApprovalSecret = "0123456789abcdef0123456789abcdef"
+
+// newApprovalEnvironment starts an e2e environment requiring approval of contents:write,
+// with approval requests stored in a file and notifications posted to the returned webhook recorder.
+func newApprovalEnvironment(t *testing.T) (*e2eEnvironment, string, func() []approvalNotification) {
+	t.Helper()
+	var mu sync.Mutex
+	var notifications []approvalNotification
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification approvalNotification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		notifications = append(notifications, notification)
+	}))
+	t.Cleanup(webhook.Close)
+
+	storeFile := filepath.Join(t.TempDir(), "approvals.json")
+	env := newE2EEnvironmentWithConfig(t, func(config *Config) {
+		config.ApprovalScopes = []string{"contents:write"}
+		config.ApprovalSecret = testApprovalSecret
+		config.ApprovalStoreFile = storeFile
+		config.ApprovalWebhookURL = webhook.URL
+	})
+	env.server.config.ApprovalBaseURL = env.service.URL
+	return env, storeFile, func() []approvalNotification {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]approvalNotification(nil), notifications...)
+	}
+}
+
+// doApprovalRequest sends a request to an approval endpoint with header and returns the response status and body.
+func doApprovalRequest(t *testing.T, method, requestURL string, header http.Header, form url.Values) (int, string) {
+	t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+// TestApprovalFlow_SignedLink tests a token request approved through the signed link of the webhook notification.
+//
+// Test steps:
+//  1. Start the service requiring approval of contents:write, with a webhook recorder
+//  2. Request contents:write and verify the 202 response and the notification
+//  3. Poll the status endpoint and verify the request is pending
+//  4. Open the approve link and verify the confirmation form, then submit it
+//  5. Poll and verify the token is released once
+func TestApprovalFlow_SignedLink(t *testing.T) {
+	// Step 1: Start environment
+	env, _, notifications := newApprovalEnvironment(t)
+
+	// Step 2: Request approval scope
+	status, body := env.requestToken(t, "owner/repo", "contents=write&issues=read")
+	if status != http.StatusAccepted {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusAccepted, body)
+	}
+	approvalID, _ := body["approval_id"].(string)
+	pollToken, _ := body["poll_token"].(string)
+	statusURL, _ := body["status_url"].(string)
+	if approvalID == "" || pollToken == "" || statusURL != env.service.URL+"/approvals/"+approvalID || body["status"] != ApprovalPending {
+		t.Fatalf("body = %v, want pending approval with poll token and status URL", body)
+	}
+	sent := notifications()
+	if len(sent) != 1 || sent[0].ApprovalID != approvalID || sent[0].Repository != "owner/repo" ||
+		len(sent[0].ApprovalScopes) != 1 || sent[0].ApprovalScopes[0] != "contents:write" {
+		t.Fatalf("notifications = %+v, want one for %s", sent, approvalID)
+	}
+
+	// Step 3: Poll pending request
+	if status, body := doApprovalRequest(t, http.MethodGet, statusURL, http.Header{approvalTokenHeader: {pollToken}}, nil); status != http.StatusAccepted {
+		t.Errorf("pending poll status = %v, want %v (body: %s)", status, http.StatusAccepted, body)
+	}
+	if status, _ := doApprovalRequest(t, http.MethodGet, statusURL, http.Header{approvalTokenHeader: {"wrong"}}, nil); status != http.StatusUnauthorized {
+		t.Errorf("wrong poll token status = %v, want %v", status, http.StatusUnauthorized)
+	}
+
+	// Step 4: Open and submit approve link
+	status, page := doApprovalRequest(t, http.MethodGet, sent[0].ApproveURL, nil, nil)
+	if status != http.StatusOK || !strings.Contains(page, "owner/repo") || !strings.Contains(page, `<form method="post">`) {
+		t.Fatalf("confirm page status = %v, want form (page: %s)", status, page)
+	}
+	if status, _ := doApprovalRequest(t, http.MethodGet, statusURL, http.Header{approvalTokenHeader: {pollToken}}, nil); status != http.StatusAccepted {
+		t.Errorf("poll after opening link status = %v, want %v", status, http.StatusAccepted)
+	}
+	parsed, _ := url.Parse(sent[0].ApproveURL)
+	form := url.Values{"sig": {parsed.Query().Get("sig")}}
+	if status, page := doApprovalRequest(t, http.MethodPost, sent[0].ApproveURL, nil, form); status != http.StatusOK || !strings.Contains(page, "approved") {
+		t.Fatalf("approve status = %v, want %v (page: %s)", status, http.StatusOK, page)
+	}
+
+	// Step 5: Poll released token
+	status, released := doApprovalRequest(t, http.MethodGet, statusURL, http.Header{approvalTokenHeader: {pollToken}}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("approved poll status = %v, want %v (body: %s)", status, http.StatusOK, released)
+	}
+	var response TokenResponse
+	if err := json.Unmarshal([]byte(released), &response); err != nil || response.Token == "" || response.Scopes["issues"] != "read" {
+		t.Errorf("response = %s, want token with all requested scopes", released)
+	}
+	if status, _ := doApprovalRequest(t, http.MethodGet, statusURL, http.Header{approvalTokenHeader: {pollToken}}, nil); status != http.StatusGone {
+		t.Errorf("second poll status = %v, want %v", status, http.StatusGone)
+	}
+}
+
+// TestApprovalFlow_Decisions tests approval decisions through the API and their outcome for the requester.
+//
+// Test steps:
+//  1. Start the service requiring approval of contents:write
+//  2. Request approval for each test case and apply its decision
+//  3. Verify the decision, its recorded authorization and the poll response
+func TestApprovalFlow_Decisions(t *testing.T) {
+	// Step 1: Start environment
+	env, storeFile, _ := newApprovalEnvironment(t)
+
+	tests := []struct {
+		name           string
+		action         string
+		header         http.Header
+		expire         bool
+		wantDecision   int
+		wantPollStatus int
+	}{
+		{name: "approved by API", action: "approve", header: http.Header{approvalSecretHeader: {testApprovalSecret}}, wantDecision: http.StatusOK, wantPollStatus: http.StatusOK},
+		{name: "denied by API", action: "deny", header: http.Header{approvalSecretHeader: {testApprovalSecret}}, wantDecision: http.StatusOK, wantPollStatus: http.StatusForbidden},
+		{name: "wrong secret", action: "approve", header: http.Header{approvalSecretHeader: {"wrong"}}, wantDecision: http.StatusForbidden, wantPollStatus: http.StatusAccepted},
+		{name: "secret as bearer token", action: "approve", header: http.Header{"Authorization": {"Bearer " + testApprovalSecret}}, wantDecision: http.StatusForbidden, wantPollStatus: http.StatusAccepted},
+		{name: "unknown action", action: "ignore", header: http.Header{approvalSecretHeader: {testApprovalSecret}}, wantDecision: http.StatusNotFound, wantPollStatus: http.StatusAccepted},
+		{name: "expired", action: "approve", header: http.Header{approvalSecretHeader: {testApprovalSecret}}, expire: true, wantDecision: http.StatusConflict, wantPollStatus: http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Request approval and decide
+			status, body := env.requestToken(t, "owner/repo", "contents=write")
+			if status != http.StatusAccepted {
+				t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusAccepted, body)
+			}
+			approvalID, _ := body["approval_id"].(string)
+			pollToken, _ := body["poll_token"].(string)
+			if tt.expire {
+				_, err := NewFileApprovalStore(storeFile).Update(context.Background(), approvalID, func(approval *Approval) error {
+					approval.ExpiresAt = time.Now().Add(-time.Minute)
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("failed to expire approval request: %v", err)
+				}
+			}
+			approvalURL := env.service.URL + "/approvals/" + approvalID
+			status, decision := doApprovalRequest(t, http.MethodPost, approvalURL+"/"+tt.action, tt.header, nil)
+
+			// Step 3: Verify decision and poll response
+			if status != tt.wantDecision {
+				t.Errorf("decision status = %v, want %v (body: %s)", status, tt.wantDecision, decision)
+			}
+			if status, body := doApprovalRequest(t, http.MethodGet, approvalURL, http.Header{approvalTokenHeader: {pollToken}}, nil); status != tt.wantPollStatus {
+				t.Errorf("poll status = %v, want %v (body: %s)", status, tt.wantPollStatus, body)
+			}
+			if tt.wantDecision == http.StatusOK {
+				if approval, err := NewFileApprovalStore(storeFile).Get(context.Background(), approvalID); err != nil || approval.DecidedBy != approvalAuthSecret {
+					t.Errorf("decided by = %+v (error: %v), want %q", approval, err, approvalAuthSecret)
+				}
+			}
+		})
+	}
+}
+
+// TestApprovalFlow_NotRequired tests that scopes not requiring approval are issued directly,
+// and that the approval endpoints reject unknown requests and forged links.
+//
+// Test steps:
+//  1. Start the service requiring approval of contents:write
+//  2. Request contents:read and verify the token is issued
+//  3. Verify unknown approval IDs and forged signatures are rejected
+func TestApprovalFlow_NotRequired(t *testing.T) {
+	// Step 1: Start environment
+	env, _, notifications := newApprovalEnvironment(t)
+
+	// Step 2: Request scope without approval
+	if status, body := env.requestToken(t, "owner/repo", "contents=read"); status != http.StatusOK {
+		t.Errorf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	if sent := notifications(); len(sent) != 0 {
+		t.Errorf("notifications = %+v, want none", sent)
+	}
+
+	// Step 3: Verify rejected requests
+	status, body := env.requestToken(t, "owner/repo", "contents=write")
+	if status != http.StatusAccepted {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusAccepted, body)
+	}
+	approvalID, _ := body["approval_id"].(string)
+	forged := env.service.URL + "/approvals/" + approvalID + "/approve?" + approvalLinkQuery("other-secret", approvalID, "approve")
+	if status, _ := doApprovalRequest(t, http.MethodGet, forged, nil, nil); status != http.StatusForbidden {
+		t.Errorf("forged link status = %v, want %v", status, http.StatusForbidden)
+	}
+	denyAsApprove := env.service.URL + "/approvals/" + approvalID + "/approve?" + approvalLinkQuery(testApprovalSecret, approvalID, "deny")
+	if status, _ := doApprovalRequest(t, http.MethodPost, denyAsApprove, nil, nil); status != http.StatusForbidden {
+		t.Errorf("deny signature on approve status = %v, want %v", status, http.StatusForbidden)
+	}
+	if status, _ := doApprovalRequest(t, http.MethodGet, env.service.URL+"/approvals/unknown", http.Header{approvalTokenHeader: {"token"}}, nil); status != http.StatusNotFound {
+		t.Errorf("unknown approval status = %v, want %v", status, http.StatusNotFound)
+	}
+}
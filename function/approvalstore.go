@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// approvalRetention is how long approval requests are kept after they expire.
+const approvalRetention = 24 * time.Hour
+
+// errApprovalNotFound is returned by ApprovalStore for unknown approval IDs.
+var errApprovalNotFound = errors.New("approval request not found")
+
+// ApprovalStore persists approval requests.
+// Implementations must apply Update atomically, so that concurrent decisions and releases don't race.
+type ApprovalStore interface {
+	// Create stores a new approval request.
+	Create(ctx context.Context, approval *Approval) error
+
+	// Get returns a copy of the approval request, or errApprovalNotFound.
+	Get(ctx context.Context, id string) (*Approval, error)
+
+	// Update applies update to the approval request and stores it, unless update returns an error.
+	// Returns a copy of the updated approval request.
+	Update(ctx context.Context, id string, update func(approval *Approval) error) (*Approval, error)
+}
+
+// MemoryApprovalStore is an in-memory ApprovalStore. Approval requests are lost on restart,
+// and are only visible to the instance that created them.
+type MemoryApprovalStore struct {
+	mu        sync.Mutex
+	approvals map[string]Approval
+}
+
+// NewMemoryApprovalStore creates an empty in-memory store.
+func NewMemoryApprovalStore() *MemoryApprovalStore {
+	return &MemoryApprovalStore{approvals: make(map[string]Approval)}
+}
+
+// Create implements ApprovalStore.
+func (s *MemoryApprovalStore) Create(ctx context.Context, approval *Approval) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pruneApprovals(s.approvals, time.Now())
+	if _, exists := s.approvals[approval.ID]; exists {
+		return fmt.Errorf("approval request %s already exists", approval.ID)
+	}
+	s.approvals[approval.ID] = approval.clone()
+	return nil
+}
+
+// Get implements ApprovalStore.
+func (s *MemoryApprovalStore) Get(ctx context.Context, id string) (*Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	approval, ok := s.approvals[id]
+	if !ok {
+		return nil, errApprovalNotFound
+	}
+	result := approval.clone()
+	return &result, nil
+}
+
+// Update implements ApprovalStore.
+func (s *MemoryApprovalStore) Update(ctx context.Context, id string, update func(approval *Approval) error) (*Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	approval, ok := s.approvals[id]
+	if !ok {
+		return nil, errApprovalNotFound
+	}
+	updated := approval.clone()
+	if err := update(&updated); err != nil {
+		return nil, err
+	}
+	s.approvals[id] = updated.clone()
+	return &updated, nil
+}
+
+// FileApprovalStore is an ApprovalStore persisting all approval requests in a JSON document:
+// a local file, rewritten atomically on every change, for single-instance deployments and tests,
+// or a Cloud Storage object (gs://bucket/object) updated with compare-and-swap, shared by all instances.
+type FileApprovalStore struct {
+	document jsonDocument
+}
+
+// NewFileApprovalStore creates a store persisting approval requests in the file or Cloud Storage object at path.
+// The file is created on the first approval request.
+func NewFileApprovalStore(path string) *FileApprovalStore {
+	return &FileApprovalStore{document: newJSONDocument(path, "approval store")}
+}
+
+// Create implements ApprovalStore.
+func (s *FileApprovalStore) Create(ctx context.Context, approval *Approval) error {
+	return s.update(ctx, func(approvals map[string]Approval) error {
+		pruneApprovals(approvals, time.Now())
+		if _, exists := approvals[approval.ID]; exists {
+			return fmt.Errorf("approval request %s already exists", approval.ID)
+		}
+		approvals[approval.ID] = approval.clone()
+		return nil
+	})
+}
+
+// Get implements ApprovalStore.
+func (s *FileApprovalStore) Get(ctx context.Context, id string) (*Approval, error) {
+	approvals := make(map[string]Approval)
+	if err := s.document.read(ctx, &approvals); err != nil {
+		return nil, err
+	}
+	approval, ok := approvals[id]
+	if !ok {
+		return nil, errApprovalNotFound
+	}
+	return &approval, nil
+}
+
+// Update implements ApprovalStore.
+func (s *FileApprovalStore) Update(ctx context.Context, id string, update func(approval *Approval) error) (*Approval, error) {
+	var updated Approval
+	err := s.update(ctx, func(approvals map[string]Approval) error {
+		approval, ok := approvals[id]
+		if !ok {
+			return errApprovalNotFound
+		}
+		if err := update(&approval); err != nil {
+			return err
+		}
+		approvals[id], updated = approval, approval
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// update applies modify to all approval requests of the document and stores them, unless modify returns an error.
+func (s *FileApprovalStore) update(ctx context.Context, modify func(approvals map[string]Approval) error) error {
+	newValue := func() interface{} {
+		approvals := make(map[string]Approval)
+		return &approvals
+	}
+	return s.document.update(ctx, newValue, func(v interface{}) error {
+		return modify(*v.(*map[string]Approval))
+	})
+}
+
+// pruneApprovals deletes approval requests expired longer than approvalRetention ago.
+func pruneApprovals(approvals map[string]Approval, now time.Time) {
+	for id, approval := range approvals {
+		if now.Sub(approval.ExpiresAt) > approvalRetention {
+			delete(approvals, id)
+		}
+	}
+}
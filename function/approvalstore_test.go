@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestApprovalStores tests the in-memory, file-backed and Cloud Storage approval stores.
+//
+// Test steps:
+//  1. Create the store of the test case
+//  2. Create an approval request and verify duplicates are rejected
+//  3. Verify Get returns copies and unknown IDs are not found
+//  4. Update the approval request and verify failed updates are discarded
+//  5. Verify approval requests expired past the retention are pruned
+func TestApprovalStores(t *testing.T) {
+	tests := []struct {
+		name     string
+		newStore func(t *testing.T) ApprovalStore
+	}{
+		{
+			name:     "memory",
+			newStore: func(t *testing.T) ApprovalStore { return NewMemoryApprovalStore() },
+		},
+		{
+			name: "file",
+			newStore: func(t *testing.T) ApprovalStore {
+				return NewFileApprovalStore(filepath.Join(t.TempDir(), "approvals.json"))
+			},
+		},
+		{
+			name: "cloud storage",
+			newStore: func(t *testing.T) ApprovalStore {
+				_, options := newFakeGCS(t)
+				return &FileApprovalStore{document: newGCSDocument("bucket", "approvals.json", "approval store", options...)}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// Step 1: Create store
+			store := tt.newStore(t)
+
+			// Step 2: Create approval request
+			now := time.Now().UTC()
+			approval := &Approval{
+				ID:             "a1",
+				Repository:     "owner/repo",
+				Scopes:         map[string]string{"secrets": "write"},
+				ApprovalScopes: []string{"secrets:write"},
+				Status:         ApprovalPending,
+				CreatedAt:      now,
+				ExpiresAt:      now.Add(time.Hour),
+			}
+			if err := store.Create(ctx, approval); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if err := store.Create(ctx, approval); err == nil {
+				t.Error("Create() duplicate error = nil, want error")
+			}
+
+			// Step 3: Verify Get
+			got, err := store.Get(ctx, "a1")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			got.Scopes["contents"] = "write"
+			if got, _ := store.Get(ctx, "a1"); got.Repository != "owner/repo" || len(got.Scopes) != 1 || got.Status != ApprovalPending {
+				t.Errorf("Get() = %+v, want stored copy", got)
+			}
+			if _, err := store.Get(ctx, "unknown"); !errors.Is(err, errApprovalNotFound) {
+				t.Errorf("Get() unknown error = %v, want %v", err, errApprovalNotFound)
+			}
+
+			// Step 4: Update approval request
+			updated, err := store.Update(ctx, "a1", func(approval *Approval) error {
+				approval.Status = ApprovalApproved
+				approval.DecidedBy = approvalAuthSecret
+				return nil
+			})
+			if err != nil || updated.Status != ApprovalApproved {
+				t.Fatalf("Update() = %+v, %v, want approved", updated, err)
+			}
+			_, err = store.Update(ctx, "a1", func(approval *Approval) error {
+				approval.Status = ApprovalDenied
+				return errApprovalUnchanged
+			})
+			if !errors.Is(err, errApprovalUnchanged) {
+				t.Errorf("Update() error = %v, want %v", err, errApprovalUnchanged)
+			}
+			if got, _ := store.Get(ctx, "a1"); got.Status != ApprovalApproved || got.DecidedBy != approvalAuthSecret {
+				t.Errorf("Get() after failed update = %+v, want approved by alice", got)
+			}
+			if _, err := store.Update(ctx, "unknown", func(*Approval) error { return nil }); !errors.Is(err, errApprovalNotFound) {
+				t.Errorf("Update() unknown error = %v, want %v", err, errApprovalNotFound)
+			}
+
+			// Step 5: Verify pruning
+			_, _ = store.Update(ctx, "a1", func(approval *Approval) error {
+				approval.ExpiresAt = now.Add(-approvalRetention - time.Minute)
+				return nil
+			})
+			if err := store.Create(ctx, &Approval{ID: "a2", Status: ApprovalPending, ExpiresAt: now.Add(time.Hour)}); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if _, err := store.Get(ctx, "a1"); !errors.Is(err, errApprovalNotFound) {
+				t.Errorf("Get() pruned error = %v, want %v", err, errApprovalNotFound)
+			}
+		})
+	}
+}
+
+// TestFileApprovalStore_Persistence tests that approval requests survive a new file store instance.
+//
+// Test steps:
+//  1. Create an approval request with a file store
+//  2. Open the file with a new store
+//  3. Verify the approval request is loaded
+func TestFileApprovalStore_Persistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "approvals.json")
+
+	// Step 1: Create approval request
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	if err := NewFileApprovalStore(path).Create(ctx, &Approval{ID: "a1", Repository: "owner/repo", Status: ApprovalPending, ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// Step 2: Open with new store
+	got, err := NewFileApprovalStore(path).Get(ctx, "a1")
+
+	// Step 3: Verify loaded
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Repository != "owner/repo" || !got.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Get() = %+v, want persisted approval request", got)
+	}
+}
+
+// TestFileApprovalStore_CloudStorageInstances tests that instances sharing a Cloud Storage approval store
+// decide an approval request once: concurrent decisions of different instances don't overwrite each other.
+//
+// Test steps:
+//  1. Create a pending approval request with the store of one instance
+//  2. Approve it concurrently from the stores of several instances
+//  3. Verify exactly one decision succeeds and the others see it already decided
+func TestFileApprovalStore_CloudStorageInstances(t *testing.T) {
+	ctx := context.Background()
+	const instances = 4
+	_, options := newFakeGCS(t)
+	newStore := func() *FileApprovalStore {
+		return &FileApprovalStore{document: newGCSDocument("bucket", "approvals.json", "approval store", options...)}
+	}
+
+	// Step 1: Create approval request
+	approval := &Approval{ID: "a1", Repository: "owner/repo", Status: ApprovalPending, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := newStore().Create(ctx, approval); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// Step 2: Approve concurrently
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+	for i := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := newStore().Update(ctx, "a1", func(approval *Approval) error {
+				if approval.Status != ApprovalPending {
+					return errApprovalUnchanged
+				}
+				approval.Status = ApprovalApproved
+				approval.DecidedBy = fmt.Sprintf("instance %d", i)
+				return nil
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// Step 3: Verify a single decision
+	decided := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			decided++
+		case !errors.Is(err, errApprovalUnchanged):
+			t.Errorf("Update() error = %v", err)
+		}
+	}
+	if decided != 1 {
+		t.Errorf("decisions = %d, want 1", decided)
+	}
+}
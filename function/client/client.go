@@ -0,0 +1,455 @@
+// Package client is a Go client for the token issuer API.
+//
+// Inside GitHub Actions, the OIDC token is obtained automatically from ACTIONS_ID_TOKEN_REQUEST_URL:
+//
+//	c := client.New("https://github-repository-token-issuer-xyz.run.app")
+//	token, err := c.RequestToken(ctx, client.TokenRequest{Scopes: map[string]string{"contents": "write"}})
+//
+// Scope combinations configured on the service can be requested by name with TokenRequest.Profile.
+// Scopes listed in TokenRequest.Optional are left out of the token if the App installation lacks them,
+// instead of failing the request; they are reported in TokenResponse.DroppedScopes.
+// TokenRequest.Lifetime shortens the lifetime of the token: the service revokes it when the lifetime ends,
+// and TokenResponse.ExpiresAt reports the end of the lifetime.
+//
+// TokenSource returns an oauth2.TokenSource that caches the token and requests a new one before it expires.
+//
+// Scopes returns the allowed scopes and profiles, and the decisions of the service for the caller's repository,
+// to validate scopes before requesting a token.
+//
+// When the requested scopes require a human approval, RequestToken polls the approval request
+// until the token is released, the request is denied, or it expires.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of Client.
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = time.Second
+	DefaultPollInterval = 10 * time.Second
+	defaultTimeout      = 30 * time.Second
+)
+
+// approvalTokenHeader carries the poll token of approval requests. Authorization is left to the OIDC token,
+// which Cloud Run IAM may validate in front of the service.
+const approvalTokenHeader = "X-Approval-Token"
+
+// TokenRequest is a token request.
+type TokenRequest struct {
+	// Scopes maps scope IDs to permission levels, e.g. {"contents": "write"}.
+	Scopes map[string]string
+
+	// Profile names a scope profile configured on the service, e.g. "release".
+	// Its scopes are merged with Scopes.
+	Profile string
+
+	// Permissions is the shorthand of workflow permissions blocks, "read-all" or "write-all",
+	// requesting every scope the caller can get. Scopes are merged with it.
+	Permissions string
+
+	// Optional lists the requested scope IDs the token may be issued without, e.g. ["issues"].
+	Optional []string
+
+	// Lifetime is the lifetime of the token between 1m and 1h, e.g. 10 * time.Minute.
+	// Zero keeps the default lifetime of GitHub installation tokens (1h), unless the service caps it.
+	Lifetime time.Duration
+}
+
+// TokenResponse is the successful response of the token endpoint.
+type TokenResponse struct {
+	Token     string            `json:"token"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	Scopes    map[string]string `json:"scopes"`
+	Profile   string            `json:"profile,omitempty"`
+
+	// DroppedScopes are the optional scopes left out of the token, as "scope:level" entries.
+	DroppedScopes []string `json:"dropped_scopes,omitempty"`
+
+	// DowngradedScopes are the scopes issued at a lower level than requested, with the downgrading policy.
+	DowngradedScopes []ScopeDowngrade `json:"downgraded_scopes,omitempty"`
+}
+
+// ScopeDowngrade is a scope issued at a lower level than requested, e.g. write issued as read
+// by the pull_request_policy of the service for a pull_request workflow.
+type ScopeDowngrade struct {
+	Scope     string `json:"scope"`
+	Requested string `json:"requested"`
+	Granted   string `json:"granted"`
+	Policy    string `json:"policy"`
+	EventName string `json:"event_name"`
+}
+
+// ScopesResponse is the response of the scope discovery endpoint.
+type ScopesResponse struct {
+	// Scopes maps the allowed scope IDs to their permission levels.
+	Scopes            map[string][]string `json:"scopes"`
+	BlacklistedScopes []string            `json:"blacklisted_scopes"`
+	Profiles          map[string][]string `json:"profiles"`
+
+	// OrganizationScopes maps organization permission IDs to their levels, issued only under the service's policy.
+	OrganizationScopes map[string][]string `json:"organization_scopes"`
+
+	// Policy maps scope IDs and permission levels to the decisions of the service for the caller's repository.
+	Policy *ScopePolicy `json:"policy"`
+}
+
+// ScopePolicy is the effective policy of the caller's repository.
+type ScopePolicy struct {
+	Repository string                              `json:"repository"`
+	Scopes     map[string]map[string]ScopeDecision `json:"scopes"`
+}
+
+// ScopeDecision is the decision for a scope at a permission level: allow, downgrade (to Level), approval or deny.
+type ScopeDecision struct {
+	Decision string `json:"decision"`
+	Level    string `json:"level"`
+	Policy   string `json:"policy"`
+	Reason   string `json:"reason"`
+}
+
+// tokenOrApproval is the response of the token and approval status endpoints:
+// a token, or a pending approval request (202).
+type tokenOrApproval struct {
+	TokenResponse
+	ApprovalID string `json:"approval_id"`
+	PollToken  string `json:"poll_token"`
+}
+
+// ErrorCode classifies token endpoint errors by the HTTP status code.
+type ErrorCode string
+
+// Error codes.
+const (
+	CodeInvalidRequest   ErrorCode = "invalid_request"   // 400: invalid, duplicate or disallowed scopes
+	CodeUnauthenticated  ErrorCode = "unauthenticated"   // 401: missing, invalid or expired OIDC token
+	CodePermissionDenied ErrorCode = "permission_denied" // 403: App not installed, insufficient permissions, suspended, approval denied
+	CodeExpired          ErrorCode = "expired"           // 410: approval request expired or its token already released
+	CodeInternal         ErrorCode = "internal"          // 500: service misconfiguration (e.g., private key)
+	CodeUnavailable      ErrorCode = "unavailable"       // 503: GitHub API errors and rate limits, retried
+	CodeUnknown          ErrorCode = "unknown"
+)
+
+// errorCodeForStatus returns the error code of an HTTP status code.
+func errorCodeForStatus(statusCode int) ErrorCode {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusGone:
+		return CodeExpired
+	case http.StatusInternalServerError:
+		return CodeInternal
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		return CodeUnknown
+	}
+}
+
+// Error is an error response of the token issuer API.
+type Error struct {
+	StatusCode int                    `json:"-"`
+	Code       ErrorCode              `json:"-"`
+	Message    string                 `json:"error"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// ErrorCodeOf returns the error code of err, or CodeUnknown if err is not an *Error.
+func ErrorCodeOf(err error) ErrorCode {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return CodeUnknown
+}
+
+// Client is a token issuer API client.
+type Client struct {
+	// URL is the service URL.
+	URL string
+
+	// Audience is the OIDC token audience. Defaults to URL.
+	Audience string
+
+	// OIDCToken returns an OIDC token for the audience. Defaults to ActionsOIDCToken.
+	OIDCToken func(ctx context.Context, audience string) (string, error)
+
+	// HTTPClient is used for all requests. Defaults to a client with a 30s timeout.
+	HTTPClient *http.Client
+
+	// MaxRetries is the number of retries of 503 responses.
+	MaxRetries int
+
+	// RetryBackoff is the initial delay between retries, doubled after each retry.
+	// Retry-After response headers take precedence.
+	RetryBackoff time.Duration
+
+	// PollInterval is the delay between status requests of pending approval requests.
+	PollInterval time.Duration
+
+	// OnApprovalPending is called (if not nil) when the token request waits for a human approval.
+	OnApprovalPending func(approvalID string)
+}
+
+// New creates a client for the service URL.
+func New(serviceURL string) *Client {
+	return &Client{
+		URL:          strings.TrimSuffix(serviceURL, "/"),
+		HTTPClient:   &http.Client{Timeout: defaultTimeout},
+		MaxRetries:   DefaultMaxRetries,
+		RetryBackoff: DefaultRetryBackoff,
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// RequestToken requests an installation token. 503 responses are retried up to MaxRetries times.
+// Pending approval requests are polled every PollInterval until the token is released.
+func (c *Client) RequestToken(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+	if len(req.Scopes) == 0 && req.Profile == "" && req.Permissions == "" {
+		return nil, fmt.Errorf("at least one scope, a profile or permissions is required")
+	}
+
+	token, err := c.oidcToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scopeIDs := make([]string, 0, len(req.Scopes))
+	for scopeID := range req.Scopes {
+		scopeIDs = append(scopeIDs, scopeID)
+	}
+	sort.Strings(scopeIDs)
+	query := url.Values{}
+	for _, scopeID := range scopeIDs {
+		query.Set(scopeID, req.Scopes[scopeID])
+	}
+	if req.Profile != "" {
+		query.Set("profile", req.Profile)
+	}
+	if req.Permissions != "" {
+		query.Set("permissions", req.Permissions)
+	}
+	if len(req.Optional) > 0 {
+		optional := append([]string(nil), req.Optional...)
+		sort.Strings(optional)
+		query.Set("optional", strings.Join(optional, ","))
+	}
+	if req.Lifetime != 0 {
+		query.Set("lifetime", req.Lifetime.String())
+	}
+	requestURL := c.URL + "/token?" + query.Encode()
+
+	response, err := c.doWithRetries(ctx, http.MethodPost, requestURL, bearer(token))
+	if err != nil {
+		return nil, err
+	}
+	if response.ApprovalID == "" {
+		return &response.TokenResponse, nil
+	}
+
+	// Poll the approval request with its poll token
+	approvalID, pollToken := response.ApprovalID, response.PollToken
+	if c.OnApprovalPending != nil {
+		c.OnApprovalPending(approvalID)
+	}
+	statusURL := c.URL + "/approvals/" + url.PathEscape(approvalID)
+	for response.ApprovalID != "" {
+		if err := sleep(ctx, c.PollInterval); err != nil {
+			return nil, err
+		}
+		response, err = c.doWithRetries(ctx, http.MethodGet, statusURL, http.Header{approvalTokenHeader: {pollToken}})
+		if err != nil {
+			return nil, fmt.Errorf("approval request %s: %w", approvalID, err)
+		}
+	}
+	return &response.TokenResponse, nil
+}
+
+// Scopes returns the allowed scopes, the profiles and the effective policy of the caller's repository.
+func (c *Client) Scopes(ctx context.Context) (*ScopesResponse, error) {
+	token, err := c.oidcToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var response ScopesResponse
+	if _, err := doJSON(ctx, c.httpClient(), http.MethodGet, c.URL+"/scopes", bearer(token), &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// oidcToken returns an OIDC token for the Audience, or the service URL.
+func (c *Client) oidcToken(ctx context.Context) (string, error) {
+	audience := c.Audience
+	if audience == "" {
+		audience = c.URL
+	}
+	if c.OIDCToken != nil {
+		return c.OIDCToken(ctx, audience)
+	}
+	return ActionsOIDCToken(ctx, c.httpClient(), audience)
+}
+
+// doWithRetries sends a request to the token issuer, retrying 503 responses up to MaxRetries times.
+func (c *Client) doWithRetries(ctx context.Context, method, requestURL string, header http.Header) (*tokenOrApproval, error) {
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		var response tokenOrApproval
+		retryAfter, err := doJSON(ctx, c.httpClient(), method, requestURL, header, &response)
+		if err == nil {
+			return &response, nil
+		}
+		if ErrorCodeOf(err) != CodeUnavailable || attempt >= c.MaxRetries {
+			return nil, err
+		}
+
+		delay := backoff
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// sleep waits for the delay, or returns the error of ctx if it is done first.
+func sleep(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// ActionsOIDCToken obtains a GitHub Actions OIDC token for the audience
+// using the ACTIONS_ID_TOKEN_REQUEST_URL and ACTIONS_ID_TOKEN_REQUEST_TOKEN environment variables.
+// The job needs the "id-token: write" permission.
+func ActionsOIDCToken(ctx context.Context, httpClient *http.Client, audience string) (string, error) {
+	return RequestActionsOIDCToken(ctx, httpClient, os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"), os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN"), audience)
+}
+
+// RequestActionsOIDCToken obtains a GitHub Actions OIDC token for the audience from the token request URL.
+func RequestActionsOIDCToken(ctx context.Context, httpClient *http.Client, requestURL, requestToken, audience string) (string, error) {
+	if requestURL == "" || requestToken == "" {
+		return "", fmt.Errorf("ACTIONS_ID_TOKEN_REQUEST_URL is not set (grant the job 'id-token: write' permission)")
+	}
+
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid ACTIONS_ID_TOKEN_REQUEST_URL: %w", err)
+	}
+	query := parsedURL.Query()
+	query.Set("audience", audience)
+	parsedURL.RawQuery = query.Encode()
+
+	var response struct {
+		Value string `json:"value"`
+	}
+	if _, err := doJSON(ctx, httpClient, http.MethodGet, parsedURL.String(), http.Header{"Authorization": {"bearer " + requestToken}}, &response); err != nil {
+		return "", fmt.Errorf("failed to obtain OIDC token: %w", err)
+	}
+	if response.Value == "" {
+		return "", fmt.Errorf("failed to obtain OIDC token: empty token in response")
+	}
+	return response.Value, nil
+}
+
+// RevokeToken revokes an installation token with the GitHub REST API at apiURL (e.g., https://api.github.com).
+func RevokeToken(ctx context.Context, httpClient *http.Client, apiURL, token string) error {
+	if token == "" {
+		return fmt.Errorf("no token to revoke")
+	}
+	if _, err := doJSON(ctx, httpClient, http.MethodDelete, strings.TrimSuffix(apiURL, "/")+"/installation/token", bearer(token), nil); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// bearer returns the header authorizing a request with token.
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// doJSON sends a request and decodes the JSON response into target (if not nil).
+// It returns the Retry-After delay of error responses. Error responses are returned as *Error with the "error" (token issuer) or "message" (GitHub) field.
+func doJSON(ctx context.Context, httpClient *http.Client, method, requestURL string, header http.Header, target interface{}) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Code: errorCodeForStatus(resp.StatusCode)}
+		var githubErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(body, apiErr)
+		_ = json.Unmarshal(body, &githubErr)
+		if apiErr.Message == "" {
+			apiErr.Message = githubErr.Message
+		}
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		var retryAfter time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return retryAfter, apiErr
+	}
+
+	if target == nil {
+		return 0, nil
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return 0, nil
+}
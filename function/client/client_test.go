@@ -0,0 +1,489 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeService is a fake token issuer service.
+type fakeService struct {
+	mu        sync.Mutex
+	requests  int
+	queries   []string
+	failures  []int
+	expiresAt time.Time
+}
+
+// newFakeService starts a fake service. Each request fails with the next status of failures, if any.
+func newFakeService(t *testing.T, failures ...int) (*fakeService, *httptest.Server) {
+	t.Helper()
+	service := &fakeService{failures: failures, expiresAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		service.requests++
+		service.queries = append(service.queries, r.URL.RawQuery)
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer oidc-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid OIDC token"})
+			return
+		}
+		if len(service.failures) > 0 {
+			status := service.failures[0]
+			service.failures = service.failures[1:]
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "failure " + http.StatusText(status),
+				"details": map[string]interface{}{"scope": "contents"},
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      "ghs_" + string(rune('0'+service.requests)),
+			"expires_at": service.expiresAt.Format(time.RFC3339),
+			"scopes":     map[string]string{"contents": "write"},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return service, server
+}
+
+// newTestClient creates a client for the server with a static OIDC token and fast retries.
+func newTestClient(serverURL string) *Client {
+	c := New(serverURL + "/")
+	c.RetryBackoff = time.Millisecond
+	c.OIDCToken = func(ctx context.Context, audience string) (string, error) {
+		if audience != serverURL {
+			return "", errors.New("unexpected audience " + audience)
+		}
+		return "oidc-token", nil
+	}
+	return c
+}
+
+// TestRequestToken verifies successful token requests.
+//
+// Test steps:
+//  1. Request a token with two scopes
+//  2. Verify the query, the audience (defaults to the service URL) and the typed response
+func TestRequestToken(t *testing.T) {
+	service, server := newFakeService(t)
+	c := newTestClient(server.URL)
+
+	// Step 1: Request
+	token, err := c.RequestToken(context.Background(), TokenRequest{Scopes: map[string]string{"issues": "read", "contents": "write"}})
+	if err != nil {
+		t.Fatalf("RequestToken() error = %v", err)
+	}
+
+	// Step 2: Verify
+	if service.queries[0] != "contents=write&issues=read" {
+		t.Errorf("query = %q, want %q", service.queries[0], "contents=write&issues=read")
+	}
+	if token.Token != "ghs_1" {
+		t.Errorf("Token = %q, want %q", token.Token, "ghs_1")
+	}
+	if !token.ExpiresAt.Equal(service.expiresAt) {
+		t.Errorf("ExpiresAt = %v, want %v", token.ExpiresAt, service.expiresAt)
+	}
+	if token.Scopes["contents"] != "write" {
+		t.Errorf("Scopes = %v, want contents:write", token.Scopes)
+	}
+}
+
+// TestRequestToken_Profile verifies token requests with a scope profile.
+//
+// Test steps:
+//  1. Request a token with a profile only
+//  2. Verify the query
+//  3. Verify requests without scopes and profile are rejected without calling the service
+func TestRequestToken_Profile(t *testing.T) {
+	service, server := newFakeService(t)
+	c := newTestClient(server.URL)
+
+	// Step 1: Request
+	if _, err := c.RequestToken(context.Background(), TokenRequest{Profile: "release"}); err != nil {
+		t.Fatalf("RequestToken() error = %v", err)
+	}
+
+	// Step 2: Verify query
+	if service.queries[0] != "profile=release" {
+		t.Errorf("query = %q, want %q", service.queries[0], "profile=release")
+	}
+
+	// Step 3: Verify empty request
+	if _, err := c.RequestToken(context.Background(), TokenRequest{}); err == nil || service.requests != 1 {
+		t.Errorf("RequestToken() error = %v, requests = %d, want error without request", err, service.requests)
+	}
+}
+
+// TestRequestToken_Permissions verifies token requests with the read-all or write-all shorthand.
+//
+// Test steps:
+//  1. Request a token with the shorthand and a scope
+//  2. Verify the shorthand is sent as the permissions parameter, separately from the scope
+func TestRequestToken_Permissions(t *testing.T) {
+	service, server := newFakeService(t)
+	c := newTestClient(server.URL)
+
+	// Step 1: Request
+	request := TokenRequest{Permissions: "read-all", Scopes: map[string]string{"issues": "write"}}
+	if _, err := c.RequestToken(context.Background(), request); err != nil {
+		t.Fatalf("RequestToken() error = %v", err)
+	}
+
+	// Step 2: Verify query
+	if want := "issues=write&permissions=read-all"; service.queries[0] != want {
+		t.Errorf("query = %q, want %q", service.queries[0], want)
+	}
+}
+
+// TestRequestToken_Optional verifies token requests with optional scopes.
+//
+// Test steps:
+//  1. Request a token with an optional scope
+//  2. Verify the query
+func TestRequestToken_Optional(t *testing.T) {
+	service, server := newFakeService(t)
+	c := newTestClient(server.URL)
+
+	// Step 1: Request
+	req := TokenRequest{Scopes: map[string]string{"contents": "write", "issues": "write", "pull_requests": "read"}, Optional: []string{"pull_requests", "issues"}}
+	if _, err := c.RequestToken(context.Background(), req); err != nil {
+		t.Fatalf("RequestToken() error = %v", err)
+	}
+
+	// Step 2: Verify query
+	want := "contents=write&issues=write&optional=issues%2Cpull_requests&pull_requests=read"
+	if service.queries[0] != want {
+		t.Errorf("query = %q, want %q", service.queries[0], want)
+	}
+}
+
+// TestRequestToken_Lifetime verifies token requests with a lifetime.
+//
+// Test steps:
+//  1. Request a token with a 10 minute lifetime
+//  2. Verify the query
+func TestRequestToken_Lifetime(t *testing.T) {
+	service, server := newFakeService(t)
+	c := newTestClient(server.URL)
+
+	// Step 1: Request
+	req := TokenRequest{Scopes: map[string]string{"contents": "read"}, Lifetime: 10 * time.Minute}
+	if _, err := c.RequestToken(context.Background(), req); err != nil {
+		t.Fatalf("RequestToken() error = %v", err)
+	}
+
+	// Step 2: Verify query
+	want := "contents=read&lifetime=10m0s"
+	if service.queries[0] != want {
+		t.Errorf("query = %q, want %q", service.queries[0], want)
+	}
+}
+
+// TestScopes verifies the scope discovery request.
+//
+// Test steps:
+//  1. Start a fake service returning scopes and the policy of the caller
+//  2. Get the scopes and verify the OIDC token is sent and the response is decoded
+func TestScopes(t *testing.T) {
+	// Step 1: Start service
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/scopes" || r.Header.Get("Authorization") != "Bearer oidc-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid OIDC token"})
+			return
+		}
+		_, _ = w.Write([]byte(`{"scopes":{"contents":["read","write"]},"blacklisted_scopes":[],"profiles":{"release":["contents:write"]},` +
+			`"policy":{"repository":"owner/repo","scopes":{"contents":{"read":{"decision":"allow"},"write":{"decision":"downgrade","level":"read"}}}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	// Step 2: Get scopes
+	scopes, err := newTestClient(server.URL).Scopes(context.Background())
+	if err != nil {
+		t.Fatalf("Scopes() error = %v", err)
+	}
+	if len(scopes.Scopes["contents"]) != 2 || scopes.Profiles["release"][0] != "contents:write" {
+		t.Errorf("Scopes() = %+v, want scopes and profiles", scopes)
+	}
+	if scopes.Policy == nil || scopes.Policy.Scopes["contents"]["write"].Level != "read" {
+		t.Errorf("Policy = %+v, want contents:write downgraded to read", scopes.Policy)
+	}
+}
+
+// TestRequestToken_Errors verifies error responses are returned as *Error with codes, and 503 is retried.
+//
+// Test steps:
+//  1. Request a token from a service failing with the given statuses
+//  2. Verify the error code, message, details and number of requests
+func TestRequestToken_Errors(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     []int
+		maxRetries   int
+		wantErr      bool
+		wantCode     ErrorCode
+		wantRequests int
+	}{
+		{name: "bad request", failures: []int{400}, maxRetries: 3, wantErr: true, wantCode: CodeInvalidRequest, wantRequests: 1},
+		{name: "forbidden", failures: []int{403}, maxRetries: 3, wantErr: true, wantCode: CodePermissionDenied, wantRequests: 1},
+		{name: "internal", failures: []int{500}, maxRetries: 3, wantErr: true, wantCode: CodeInternal, wantRequests: 1},
+		{name: "unavailable retried", failures: []int{503, 503}, maxRetries: 3, wantRequests: 3},
+		{name: "unavailable retries exhausted", failures: []int{503, 503, 503}, maxRetries: 2, wantErr: true, wantCode: CodeUnavailable, wantRequests: 3},
+		{name: "unavailable without retries", failures: []int{503}, maxRetries: 0, wantErr: true, wantCode: CodeUnavailable, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Request
+			service, server := newFakeService(t, tt.failures...)
+			c := newTestClient(server.URL)
+			c.MaxRetries = tt.maxRetries
+			_, err := c.RequestToken(context.Background(), TokenRequest{Scopes: map[string]string{"contents": "read"}})
+
+			// Step 2: Verify
+			if service.requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", service.requests, tt.wantRequests)
+			}
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("RequestToken() unexpected error = %v", err)
+				}
+				return
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("RequestToken() error = %v, want *Error", err)
+			}
+			if ErrorCodeOf(err) != tt.wantCode {
+				t.Errorf("ErrorCodeOf() = %q, want %q", ErrorCodeOf(err), tt.wantCode)
+			}
+			if !strings.HasPrefix(apiErr.Message, "failure ") || apiErr.Details["scope"] != "contents" {
+				t.Errorf("Error = %+v, want message and details from the response", apiErr)
+			}
+		})
+	}
+}
+
+// TestRequestToken_Approval verifies polling of token requests requiring a human approval.
+//
+// Test steps:
+//  1. Start a fake service holding the request for approval, released or denied after two polls
+//  2. Request a token and verify the pending callback and the poll token
+//  3. Verify the released token or the denial
+func TestRequestToken_Approval(t *testing.T) {
+	tests := []struct {
+		name        string
+		finalStatus int
+		wantErr     bool
+		wantCode    ErrorCode
+	}{
+		{name: "approved", finalStatus: http.StatusOK},
+		{name: "denied", finalStatus: http.StatusForbidden, wantErr: true, wantCode: CodePermissionDenied},
+		{name: "expired", finalStatus: http.StatusGone, wantErr: true, wantCode: CodeExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Start fake service
+			polls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/token":
+					w.WriteHeader(http.StatusAccepted)
+					_ = json.NewEncoder(w).Encode(map[string]string{"approval_id": "a1", "status": "pending", "poll_token": "poll"})
+				case r.URL.Path != "/approvals/a1" || r.Header.Get("X-Approval-Token") != "poll" || r.Header.Get("Authorization") != "":
+					w.WriteHeader(http.StatusUnauthorized)
+					_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid approval poll token"})
+				case polls < 2:
+					polls++
+					w.WriteHeader(http.StatusAccepted)
+					_ = json.NewEncoder(w).Encode(map[string]string{"approval_id": "a1", "status": "pending"})
+				case tt.finalStatus == http.StatusOK:
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{"token": "ghs_approved", "expires_at": "2030-01-01T00:00:00Z"})
+				default:
+					w.WriteHeader(tt.finalStatus)
+					_ = json.NewEncoder(w).Encode(map[string]string{"error": "approval request " + http.StatusText(tt.finalStatus)})
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			// Step 2: Request
+			c := newTestClient(server.URL)
+			c.PollInterval = time.Millisecond
+			var pending []string
+			c.OnApprovalPending = func(approvalID string) { pending = append(pending, approvalID) }
+			token, err := c.RequestToken(context.Background(), TokenRequest{Scopes: map[string]string{"secrets": "write"}})
+			if len(pending) != 1 || pending[0] != "a1" {
+				t.Errorf("OnApprovalPending calls = %v, want [a1]", pending)
+			}
+
+			// Step 3: Verify
+			if polls != 2 {
+				t.Errorf("pending polls = %d, want 2", polls)
+			}
+			if tt.wantErr {
+				if ErrorCodeOf(err) != tt.wantCode {
+					t.Errorf("RequestToken() error = %v, want code %q", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RequestToken() error = %v", err)
+			}
+			if token.Token != "ghs_approved" {
+				t.Errorf("Token = %q, want %q", token.Token, "ghs_approved")
+			}
+		})
+	}
+}
+
+// TestActionsOIDCToken verifies OIDC token acquisition in GitHub Actions.
+//
+// Test steps:
+//  1. Request a token without ACTIONS_ID_TOKEN_REQUEST_URL and verify the error
+//  2. Request a token from a fake endpoint and verify the audience and authorization
+func TestActionsOIDCToken(t *testing.T) {
+	var gotAudience, gotAuthorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAudience = r.URL.Query().Get("audience")
+		gotAuthorization = r.Header.Get("Authorization")
+		_ = json.NewEncoder(w).Encode(map[string]string{"value": "oidc-token"})
+	}))
+	defer server.Close()
+
+	// Step 1: Not in Actions
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", "")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "")
+	if _, err := ActionsOIDCToken(context.Background(), http.DefaultClient, "aud"); err == nil || !strings.Contains(err.Error(), "id-token: write") {
+		t.Errorf("ActionsOIDCToken() error = %v, want containing %q", err, "id-token: write")
+	}
+
+	// Step 2: In Actions
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", server.URL+"/?api-version=2.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "request-token")
+	token, err := ActionsOIDCToken(context.Background(), http.DefaultClient, "https://issuer.example.com")
+	if err != nil {
+		t.Fatalf("ActionsOIDCToken() error = %v", err)
+	}
+	if token != "oidc-token" {
+		t.Errorf("ActionsOIDCToken() = %q, want %q", token, "oidc-token")
+	}
+	if gotAudience != "https://issuer.example.com" {
+		t.Errorf("audience = %q, want %q", gotAudience, "https://issuer.example.com")
+	}
+	if gotAuthorization != "bearer request-token" {
+		t.Errorf("Authorization = %q, want %q", gotAuthorization, "bearer request-token")
+	}
+}
+
+// TestTokenSource verifies tokens are cached and refreshed before they expire.
+//
+// Test steps:
+//  1. Get a token twice and verify the second call is served from the cache
+//  2. Advance the clock into the refresh window and verify a new token is requested
+func TestTokenSource(t *testing.T) {
+	service, server := newFakeService(t)
+	c := newTestClient(server.URL)
+	now := service.expiresAt.Add(-time.Hour)
+	source := c.TokenSource(context.Background(), TokenRequest{Scopes: map[string]string{"contents": "write"}}).(*tokenSource)
+	source.now = func() time.Time { return now }
+
+	// Step 1: Cached
+	first, err := source.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	second, err := source.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if first.AccessToken != "ghs_1" || second.AccessToken != "ghs_1" || service.requests != 1 {
+		t.Errorf("tokens = %q, %q after %d requests, want cached ghs_1", first.AccessToken, second.AccessToken, service.requests)
+	}
+	if !first.Expiry.Equal(service.expiresAt) {
+		t.Errorf("Expiry = %v, want %v", first.Expiry, service.expiresAt)
+	}
+
+	// Step 2: Refreshed
+	now = service.expiresAt.Add(-DefaultRefreshBefore + time.Second)
+	third, err := source.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if third.AccessToken != "ghs_2" {
+		t.Errorf("Token() = %q, want refreshed ghs_2", third.AccessToken)
+	}
+}
+
+// TestTokenSource_ShortLifetime verifies tokens with a lifetime shorter than twice DefaultRefreshBefore
+// are refreshed halfway through their lifetime instead of on every call.
+//
+// Test steps:
+//  1. Get a token with a lifetime of 2 minutes twice and verify the second call is served from the cache
+//  2. Advance the clock to just before half of the lifetime and verify the token is still cached
+//  3. Advance the clock past half of the lifetime and verify a new token is requested
+func TestTokenSource_ShortLifetime(t *testing.T) {
+	service, server := newFakeService(t)
+	c := newTestClient(server.URL)
+	now := service.expiresAt.Add(-2 * time.Minute)
+	source := c.TokenSource(context.Background(), TokenRequest{Scopes: map[string]string{"contents": "write"}, Lifetime: 2 * time.Minute}).(*tokenSource)
+	source.now = func() time.Time { return now }
+
+	// Step 1: Cached
+	for i := 0; i < 2; i++ {
+		if token, err := source.Token(); err != nil || token.AccessToken != "ghs_1" {
+			t.Fatalf("Token() = %v, %v, want ghs_1", token, err)
+		}
+	}
+
+	// Step 2: Still cached before half of the lifetime
+	now = service.expiresAt.Add(-time.Minute - time.Second)
+	if token, err := source.Token(); err != nil || token.AccessToken != "ghs_1" || service.requests != 1 {
+		t.Errorf("Token() = %v, %v after %d requests, want cached ghs_1", token, err, service.requests)
+	}
+
+	// Step 3: Refreshed after half of the lifetime
+	now = service.expiresAt.Add(-time.Minute + time.Second)
+	if token, err := source.Token(); err != nil || token.AccessToken != "ghs_2" {
+		t.Errorf("Token() = %v, %v, want refreshed ghs_2", token, err)
+	}
+}
+
+// TestTokenSource_ExpiredResponse verifies a token that is already expired when it's received is not cached.
+//
+// Test steps:
+//  1. Get a token whose expiry is before the current time and verify it's due for refresh
+//  2. Get a token again and verify a new token is requested
+func TestTokenSource_ExpiredResponse(t *testing.T) {
+	service, server := newFakeService(t)
+	c := newTestClient(server.URL)
+	now := service.expiresAt.Add(time.Minute)
+	source := c.TokenSource(context.Background(), TokenRequest{Scopes: map[string]string{"contents": "write"}}).(*tokenSource)
+	source.now = func() time.Time { return now }
+
+	// Step 1: Expired response
+	if token, err := source.Token(); err != nil || token.AccessToken != "ghs_1" {
+		t.Fatalf("Token() = %v, %v, want ghs_1", token, err)
+	}
+	if source.refreshAt.After(service.expiresAt) {
+		t.Errorf("refreshAt = %v, want at or before the expiry %v", source.refreshAt, service.expiresAt)
+	}
+
+	// Step 2: Refreshed
+	if token, err := source.Token(); err != nil || token.AccessToken != "ghs_2" || service.requests != 2 {
+		t.Errorf("Token() = %v, %v after %d requests, want refreshed ghs_2", token, err, service.requests)
+	}
+}
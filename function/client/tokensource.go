@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultRefreshBefore is how long before expiry TokenSource requests a new token.
+const DefaultRefreshBefore = 5 * time.Minute
+
+// tokenSource caches installation tokens and requests a new one refreshBefore ExpiresAt,
+// or halfway through the lifetime of tokens living shorter than twice refreshBefore.
+type tokenSource struct {
+	ctx           context.Context
+	client        *Client
+	request       TokenRequest
+	refreshBefore time.Duration
+	now           func() time.Time
+
+	mu        sync.Mutex
+	token     *oauth2.Token
+	refreshAt time.Time
+}
+
+// TokenSource returns an oauth2.TokenSource of installation tokens for the request.
+// The token is cached and a new one is requested DefaultRefreshBefore it expires, or halfway through
+// its lifetime if that is shorter, e.g. after 1m for a Lifetime of 2m.
+// ctx is used for all token requests.
+func (c *Client) TokenSource(ctx context.Context, req TokenRequest) oauth2.TokenSource {
+	return &tokenSource{
+		ctx:           ctx,
+		client:        c,
+		request:       req,
+		refreshBefore: DefaultRefreshBefore,
+		now:           time.Now,
+	}
+}
+
+// Token implements oauth2.TokenSource.
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.now().Before(s.refreshAt) {
+		return s.token, nil
+	}
+
+	requestedAt := s.now()
+	response, err := s.client.RequestToken(s.ctx, s.request)
+	if err != nil {
+		return nil, err
+	}
+	s.token = &oauth2.Token{
+		AccessToken: response.Token,
+		TokenType:   "Bearer",
+		Expiry:      response.ExpiresAt,
+	}
+	// An expired response (e.g. clock skew) has no lifetime left and is refreshed on the next call
+	lifetime := max(0, response.ExpiresAt.Sub(requestedAt))
+	s.refreshAt = response.ExpiresAt.Add(-min(s.refreshBefore, lifetime/2))
+	return s.token, nil
+}
@@ -0,0 +1,347 @@
+// Command token-issuer-client requests a GitHub installation token from the token issuer service.
+//
+// Inside GitHub Actions, it obtains the OIDC token from ACTIONS_ID_TOKEN_REQUEST_URL, masks the issued token,
+// and writes it to GITHUB_OUTPUT (and optionally GITHUB_ENV). With a command after "--", it runs the command
+// with the token in its environment and revokes the token when the command exits.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/your-org/github-token-issuer/function/client"
+)
+
+// requestTimeout is the timeout of each HTTP request.
+const requestTimeout = 30 * time.Second
+
+// httpClient is used for all HTTP requests.
+var httpClient = &http.Client{Timeout: requestTimeout}
+
+// usage describes the command line.
+const usage = `Usage:
+  token-issuer-client -url URL -scopes SCOPES [flags]               Request a token
+  token-issuer-client -url URL -profile NAME [flags]                Request a token with a scope profile
+  token-issuer-client -url URL -scopes SCOPES [flags] -- CMD [ARGS]  Run CMD with the token, then revoke it
+  token-issuer-client revoke                                        Revoke the token in $TOKEN_ISSUER_TOKEN
+
+Flags:
+`
+
+// options are the parsed command line options.
+type options struct {
+	serviceURL     string
+	audience       string
+	scopes         map[string]string
+	permissions    string
+	profile        string
+	optional       []string
+	lifetime       time.Duration
+	oidcToken      string
+	outputName     string
+	envName        string
+	gitCredentials bool
+	command        []string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	exitCode := run(ctx, os.Args[1:], os.Getenv, os.Stdout)
+	stop()
+	os.Exit(exitCode)
+}
+
+// run runs the client and returns the process exit code.
+func run(ctx context.Context, args []string, getenv func(string) string, stdout io.Writer) int {
+	if len(args) > 0 && args[0] == "revoke" {
+		if err := client.RevokeToken(ctx, httpClient, githubAPIURL(getenv), getenv("TOKEN_ISSUER_TOKEN")); err != nil {
+			fmt.Fprintf(os.Stderr, "token-issuer-client: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	opts, err := parseOptions(args, getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "token-issuer-client: %v\n", err)
+		return 2
+	}
+
+	token, err := issueToken(ctx, opts, getenv, stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "token-issuer-client: %v\n", err)
+		return 1
+	}
+
+	if len(opts.command) == 0 {
+		return 0
+	}
+
+	// Run the command with the token, then revoke it
+	exitCode, err := runCommand(ctx, opts.command, opts.envName, token.Token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "token-issuer-client: %v\n", err)
+	}
+	revokeCtx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := client.RevokeToken(revokeCtx, httpClient, githubAPIURL(getenv), token.Token); err != nil {
+		fmt.Fprintf(os.Stderr, "token-issuer-client: %v\n", err)
+		if exitCode == 0 {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// parseOptions parses the command line. Flags default to TOKEN_ISSUER_* environment variables.
+func parseOptions(args []string, getenv func(string) string) (*options, error) {
+	flags := flag.NewFlagSet("token-issuer-client", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	serviceURL := flags.String("url", getenv("TOKEN_ISSUER_URL"), "token issuer service URL ($TOKEN_ISSUER_URL)")
+	audience := flags.String("audience", getenv("TOKEN_ISSUER_AUDIENCE"), "OIDC token audience, defaults to the service URL ($TOKEN_ISSUER_AUDIENCE)")
+	scopes := flags.String("scopes", getenv("TOKEN_ISSUER_SCOPES"), "scope_id:permission pairs separated by commas or newlines ($TOKEN_ISSUER_SCOPES)")
+	profile := flags.String("profile", getenv("TOKEN_ISSUER_PROFILE"), "scope profile configured on the service, merged with -scopes ($TOKEN_ISSUER_PROFILE)")
+	optional := flags.String("optional", getenv("TOKEN_ISSUER_OPTIONAL"), "scope IDs separated by commas the token may be issued without ($TOKEN_ISSUER_OPTIONAL)")
+	lifetime := flags.String("lifetime", getenv("TOKEN_ISSUER_LIFETIME"), "token lifetime between 1m and 1h, e.g. 10m; the service revokes the token when it ends ($TOKEN_ISSUER_LIFETIME)")
+	oidcToken := flags.String("oidc-token", getenv("TOKEN_ISSUER_OIDC_TOKEN"), "OIDC token to use outside GitHub Actions ($TOKEN_ISSUER_OIDC_TOKEN)")
+	outputName := flags.String("output", "token", "GITHUB_OUTPUT name of the token")
+	envName := flags.String("env", "", "environment variable to export the token to (GITHUB_ENV, or the command environment; GITHUB_TOKEN for commands by default)")
+	gitCredentials := flags.Bool("git-credentials", false, "configure git to use the token for the GitHub server (requires -env)")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	opts := &options{
+		serviceURL:     strings.TrimSuffix(*serviceURL, "/"),
+		audience:       *audience,
+		profile:        *profile,
+		oidcToken:      *oidcToken,
+		outputName:     *outputName,
+		envName:        *envName,
+		gitCredentials: *gitCredentials,
+		command:        flags.Args(),
+	}
+	if opts.serviceURL == "" {
+		return nil, fmt.Errorf("-url is required")
+	}
+	if opts.audience == "" {
+		opts.audience = opts.serviceURL
+	}
+	if len(opts.command) > 0 && opts.envName == "" {
+		opts.envName = "GITHUB_TOKEN"
+	}
+	if opts.gitCredentials && opts.envName == "" {
+		return nil, fmt.Errorf("-git-credentials requires -env")
+	}
+
+	parsedScopes, permissions, err := parseScopes(*scopes)
+	if err != nil {
+		return nil, err
+	}
+	opts.scopes, opts.permissions = parsedScopes, permissions
+	for _, scopeID := range strings.Split(*optional, ",") {
+		if scopeID = strings.TrimSpace(scopeID); scopeID != "" {
+			opts.optional = append(opts.optional, scopeID)
+		}
+	}
+	if *lifetime != "" {
+		if opts.lifetime, err = time.ParseDuration(*lifetime); err != nil {
+			return nil, fmt.Errorf("invalid -lifetime '%s': %w", *lifetime, err)
+		}
+	}
+	if len(opts.scopes) == 0 && opts.permissions == "" && opts.profile == "" {
+		return nil, fmt.Errorf("-scopes or -profile is required")
+	}
+
+	return opts, nil
+}
+
+// parseScopes parses scope_id:permission pairs separated by commas or newlines.
+// Like workflow permissions blocks, a pair may have a space after the colon (pull-requests: write),
+// and read-all or write-all request every scope the caller can get; the shorthand is returned as permissions.
+func parseScopes(value string) (scopes map[string]string, permissions string, err error) {
+	scopes = make(map[string]string)
+	for _, pair := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		if pair == "read-all" || pair == "write-all" {
+			if permissions != "" {
+				return nil, "", fmt.Errorf("duplicate shorthand '%s'", pair)
+			}
+			permissions = pair
+			continue
+		}
+		scopeID, permission, ok := strings.Cut(pair, ":")
+		scopeID, permission = strings.TrimSpace(scopeID), strings.TrimSpace(permission)
+		if !ok || scopeID == "" || permission == "" {
+			return nil, "", fmt.Errorf("invalid scope '%s' (must be scope_id:permission)", pair)
+		}
+		if _, exists := scopes[scopeID]; exists {
+			return nil, "", fmt.Errorf("duplicate scope '%s'", scopeID)
+		}
+		scopes[scopeID] = permission
+	}
+	return scopes, permissions, nil
+}
+
+// issueToken requests the token and publishes it to GitHub Actions and git as configured.
+func issueToken(ctx context.Context, opts *options, getenv func(string) string, stdout io.Writer) (*client.TokenResponse, error) {
+	c := client.New(opts.serviceURL)
+	c.Audience = opts.audience
+	c.HTTPClient = httpClient
+	c.OIDCToken = func(ctx context.Context, audience string) (string, error) {
+		if opts.oidcToken != "" {
+			return opts.oidcToken, nil
+		}
+		return client.RequestActionsOIDCToken(ctx, httpClient, getenv("ACTIONS_ID_TOKEN_REQUEST_URL"), getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN"), audience)
+	}
+	c.OnApprovalPending = func(approvalID string) {
+		fmt.Fprintf(os.Stderr, "token-issuer-client: waiting for approval of request %s\n", approvalID)
+	}
+
+	token, err := c.RequestToken(ctx, client.TokenRequest{Scopes: opts.scopes, Permissions: opts.permissions, Profile: opts.profile, Optional: opts.optional, Lifetime: opts.lifetime})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token: %w", err)
+	}
+	if len(token.DroppedScopes) > 0 {
+		fmt.Fprintf(os.Stderr, "token-issuer-client: optional scopes not granted: %s\n", strings.Join(token.DroppedScopes, ", "))
+	}
+	for _, downgrade := range token.DowngradedScopes {
+		fmt.Fprintf(os.Stderr, "token-issuer-client: scope %s issued as %s instead of %s by %s\n",
+			downgrade.Scope, downgrade.Granted, downgrade.Requested, downgrade.Policy)
+	}
+
+	inActions := getenv("GITHUB_ACTIONS") == "true"
+	if inActions {
+		// Mask the token in all subsequent log output of the job
+		if _, err := fmt.Fprintf(stdout, "::add-mask::%s\n", token.Token); err != nil {
+			return nil, err
+		}
+	}
+
+	if outputFile := getenv("GITHUB_OUTPUT"); outputFile != "" {
+		if err := appendGitHubFile(outputFile, opts.outputName, token.Token); err != nil {
+			return nil, err
+		}
+	} else if len(opts.command) == 0 {
+		// Outside Actions, print the token for shell capture
+		if _, err := fmt.Fprintln(stdout, token.Token); err != nil {
+			return nil, err
+		}
+	}
+
+	if envFile := getenv("GITHUB_ENV"); envFile != "" && opts.envName != "" && len(opts.command) == 0 {
+		if err := appendGitHubFile(envFile, opts.envName, token.Token); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.gitCredentials {
+		if err := configureGitCredentials(ctx, githubServerURL(getenv), opts.envName); err != nil {
+			return nil, err
+		}
+	}
+
+	return token, nil
+}
+
+// appendGitHubFile appends name=value to a GitHub Actions file command file (GITHUB_OUTPUT, GITHUB_ENV).
+func appendGitHubFile(path, name, value string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	if _, err := fmt.Fprintf(file, "%s=%s\n", name, value); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// configureGitCredentials configures a global git credential helper for the GitHub server
+// reading the token from the envName environment variable, so the token is never written to disk.
+func configureGitCredentials(ctx context.Context, serverURL, envName string) error {
+	section := "credential." + strings.TrimSuffix(serverURL, "/")
+	helper := fmt.Sprintf(`!f() { test "$1" = get && echo username=x-access-token && echo "password=${%s}"; }; f`, envName)
+
+	commands := [][]string{
+		// An empty helper resets helpers configured before for the server (e.g., by actions/checkout)
+		{"config", "--global", "--replace-all", section + ".helper", ""},
+		{"config", "--global", "--add", section + ".helper", helper},
+	}
+	for _, args := range commands {
+		if output, err := exec.CommandContext(ctx, "git", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to configure git credentials: %w: %s", err, output)
+		}
+	}
+	return nil
+}
+
+// runCommand runs the command with the token in the envName environment variable
+// and returns its exit code. Signals are forwarded to the command.
+func runCommand(ctx context.Context, command []string, envName, token string) (int, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), envName+"="+token)
+
+	if err := cmd.Start(); err != nil {
+		return 127, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return commandExitCode(err)
+	case <-ctx.Done():
+		// Let the command shut down gracefully, the token is revoked after it exits
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		return commandExitCode(<-done)
+	}
+}
+
+// commandExitCode returns the exit code of a finished command.
+func commandExitCode(err error) (int, error) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// githubAPIURL returns the GitHub REST API URL of the workflow run (GitHub Enterprise Server aware).
+func githubAPIURL(getenv func(string) string) string {
+	if apiURL := getenv("GITHUB_API_URL"); apiURL != "" {
+		return apiURL
+	}
+	return "https://api.github.com"
+}
+
+// githubServerURL returns the GitHub server URL of the workflow run.
+func githubServerURL(getenv func(string) string) string {
+	if serverURL := getenv("GITHUB_SERVER_URL"); serverURL != "" {
+		return serverURL
+	}
+	return "https://github.com"
+}
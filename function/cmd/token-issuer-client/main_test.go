@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testServers are fake OIDC, token issuer and GitHub API servers.
+type testServers struct {
+	oidc      *httptest.Server
+	issuer    *httptest.Server
+	github    *httptest.Server
+	mu        sync.Mutex
+	audience  string
+	query     string
+	revoked   []string
+	issuerErr string
+}
+
+// newTestServers starts fake servers issuing "ghs_test" for the OIDC token "oidc-token".
+func newTestServers(t *testing.T) *testServers {
+	t.Helper()
+	s := &testServers{}
+
+	s.oidc = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "bearer request-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		s.audience = r.URL.Query().Get("audience")
+		s.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]string{"value": "oidc-token"})
+	}))
+	t.Cleanup(s.oidc.Close)
+
+	s.issuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.query = r.URL.RawQuery
+		issuerErr := s.issuerErr
+		s.mu.Unlock()
+		if issuerErr != "" {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": issuerErr})
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/token" || r.Header.Get("Authorization") != "Bearer oidc-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid OIDC token"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": "ghs_test", "expires_at": "2030-01-01T00:00:00Z"})
+	}))
+	t.Cleanup(s.issuer.Close)
+
+	s.github = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != "/installation/token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.mu.Lock()
+		s.revoked = append(s.revoked, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.github.Close)
+
+	return s
+}
+
+// actionsEnv returns a GitHub Actions environment using the test servers and files in a temporary directory.
+func (s *testServers) actionsEnv(t *testing.T) map[string]string {
+	t.Helper()
+	dir := t.TempDir()
+	return map[string]string{
+		"GITHUB_ACTIONS":                 "true",
+		"ACTIONS_ID_TOKEN_REQUEST_URL":   s.oidc.URL + "/token?api-version=2.0",
+		"ACTIONS_ID_TOKEN_REQUEST_TOKEN": "request-token",
+		"GITHUB_OUTPUT":                  filepath.Join(dir, "output"),
+		"GITHUB_ENV":                     filepath.Join(dir, "env"),
+		"GITHUB_API_URL":                 s.github.URL,
+	}
+}
+
+func getenvFrom(env map[string]string) func(string) string {
+	return func(name string) string { return env[name] }
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	return string(data)
+}
+
+// TestParseScopes verifies parsing of scope_id:permission lists.
+//
+// Test steps:
+//  1. Parse comma and newline separated lists
+//  2. Verify invalid and duplicate lists are rejected
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name            string
+		value           string
+		want            map[string]string
+		wantPermissions string
+		wantErr         bool
+		errContains     string
+	}{
+		{
+			name:  "comma separated",
+			value: "contents:write,issues:read",
+			want:  map[string]string{"contents": "write", "issues": "read"},
+		},
+		{
+			name:  "newline separated with blank lines",
+			value: "contents:write\n\n  issues:read  \n",
+			want:  map[string]string{"contents": "write", "issues": "read"},
+		},
+		{
+			name:            "workflow permissions syntax",
+			value:           "read-all\npull-requests: write\nid-token: write",
+			want:            map[string]string{"pull-requests": "write", "id-token": "write"},
+			wantPermissions: "read-all",
+		},
+		{
+			name:        "missing permission",
+			value:       "contents",
+			wantErr:     true,
+			errContains: "invalid scope 'contents'",
+		},
+		{
+			name:        "duplicate scope",
+			value:       "contents:read,contents:write",
+			wantErr:     true,
+			errContains: "duplicate scope 'contents'",
+		},
+		{
+			name:        "duplicate shorthand",
+			value:       "read-all,write-all",
+			wantErr:     true,
+			errContains: "duplicate shorthand 'write-all'",
+		},
+		{
+			name:  "empty",
+			value: " \n",
+			want:  map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Parse
+			got, permissions, err := parseScopes(tt.value)
+
+			// Step 2: Verify
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("parseScopes() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseScopes() unexpected error = %v", err)
+			}
+			if permissions != tt.wantPermissions {
+				t.Errorf("parseScopes() permissions = %q, want %q", permissions, tt.wantPermissions)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseScopes() = %v, want %v", got, tt.want)
+			}
+			for scopeID, permission := range tt.want {
+				if got[scopeID] != permission {
+					t.Errorf("parseScopes()[%s] = %q, want %q", scopeID, got[scopeID], permission)
+				}
+			}
+		})
+	}
+}
+
+// TestRun_Actions verifies the client inside GitHub Actions.
+//
+// Test steps:
+//  1. Run the client with scopes and -env
+//  2. Verify the OIDC audience defaults to the service URL and scopes are sent
+//  3. Verify the token is masked and written to GITHUB_OUTPUT and GITHUB_ENV
+func TestRun_Actions(t *testing.T) {
+	servers := newTestServers(t)
+	env := servers.actionsEnv(t)
+	var stdout bytes.Buffer
+
+	// Step 1: Run
+	exitCode := run(context.Background(), []string{"-url", servers.issuer.URL + "/", "-scopes", "issues:read,contents:write", "-env", "GH_TOKEN"}, getenvFrom(env), &stdout)
+	if exitCode != 0 {
+		t.Fatalf("run() = %d, want 0", exitCode)
+	}
+
+	// Step 2: Verify requests
+	if servers.audience != servers.issuer.URL {
+		t.Errorf("OIDC audience = %q, want %q", servers.audience, servers.issuer.URL)
+	}
+	if servers.query != "contents=write&issues=read" {
+		t.Errorf("token request query = %q, want %q", servers.query, "contents=write&issues=read")
+	}
+
+	// Step 3: Verify outputs
+	if stdout.String() != "::add-mask::ghs_test\n" {
+		t.Errorf("stdout = %q, want only the add-mask command", stdout.String())
+	}
+	if got := readFile(t, env["GITHUB_OUTPUT"]); got != "token=ghs_test\n" {
+		t.Errorf("GITHUB_OUTPUT = %q, want %q", got, "token=ghs_test\n")
+	}
+	if got := readFile(t, env["GITHUB_ENV"]); got != "GH_TOKEN=ghs_test\n" {
+		t.Errorf("GITHUB_ENV = %q, want %q", got, "GH_TOKEN=ghs_test\n")
+	}
+	if len(servers.revoked) != 0 {
+		t.Errorf("revoked tokens = %v, want none", servers.revoked)
+	}
+}
+
+// TestRun_Profile verifies a scope profile is requested from $TOKEN_ISSUER_PROFILE.
+//
+// Test steps:
+//  1. Run the client with TOKEN_ISSUER_PROFILE and an additional scope
+//  2. Verify the profile and the scope are sent
+func TestRun_Profile(t *testing.T) {
+	servers := newTestServers(t)
+	env := servers.actionsEnv(t)
+	env["TOKEN_ISSUER_PROFILE"] = "release"
+
+	// Step 1: Run
+	exitCode := run(context.Background(), []string{"-url", servers.issuer.URL, "-scopes", "issues:read"}, getenvFrom(env), &bytes.Buffer{})
+	if exitCode != 0 {
+		t.Fatalf("run() = %d, want 0", exitCode)
+	}
+
+	// Step 2: Verify request
+	if servers.query != "issues=read&profile=release" {
+		t.Errorf("token request query = %q, want %q", servers.query, "issues=read&profile=release")
+	}
+}
+
+// TestRun_Optional verifies optional scopes are requested from -optional.
+//
+// Test steps:
+//  1. Run the client with an optional scope
+//  2. Verify the optional scope is sent
+func TestRun_Optional(t *testing.T) {
+	servers := newTestServers(t)
+
+	// Step 1: Run
+	args := []string{"-url", servers.issuer.URL, "-scopes", "contents:write,issues:write", "-optional", "issues"}
+	exitCode := run(context.Background(), args, getenvFrom(servers.actionsEnv(t)), &bytes.Buffer{})
+	if exitCode != 0 {
+		t.Fatalf("run() = %d, want 0", exitCode)
+	}
+
+	// Step 2: Verify request
+	if servers.query != "contents=write&issues=write&optional=issues" {
+		t.Errorf("token request query = %q, want %q", servers.query, "contents=write&issues=write&optional=issues")
+	}
+}
+
+// TestRun_Lifetime verifies the token lifetime is requested from $TOKEN_ISSUER_LIFETIME.
+//
+// Test steps:
+//  1. Run the client with a 10 minute lifetime
+//  2. Verify the lifetime is sent
+func TestRun_Lifetime(t *testing.T) {
+	servers := newTestServers(t)
+
+	// Step 1: Run
+	env := servers.actionsEnv(t)
+	env["TOKEN_ISSUER_LIFETIME"] = "10m"
+	exitCode := run(context.Background(), []string{"-url", servers.issuer.URL, "-scopes", "contents:read"}, getenvFrom(env), &bytes.Buffer{})
+	if exitCode != 0 {
+		t.Fatalf("run() = %d, want 0", exitCode)
+	}
+
+	// Step 2: Verify request
+	if servers.query != "contents=read&lifetime=10m0s" {
+		t.Errorf("token request query = %q, want %q", servers.query, "contents=read&lifetime=10m0s")
+	}
+}
+
+// TestRun_Errors verifies the client reports failures with a non-zero exit code.
+//
+// Test steps:
+//  1. Run the client without the OIDC request variables, without -url, and with a service error
+//  2. Verify the exit code and that no token is written
+func TestRun_Errors(t *testing.T) {
+	servers := newTestServers(t)
+
+	tests := []struct {
+		name      string
+		args      []string
+		env       func(map[string]string)
+		issuerErr string
+		wantCode  int
+	}{
+		{
+			name:     "missing url",
+			args:     []string{"-scopes", "contents:read"},
+			wantCode: 2,
+		},
+		{
+			name:     "missing scopes and profile",
+			args:     []string{"-url", servers.issuer.URL},
+			wantCode: 2,
+		},
+		{
+			name:     "git credentials without env",
+			args:     []string{"-url", servers.issuer.URL, "-scopes", "contents:read", "-git-credentials"},
+			wantCode: 2,
+		},
+		{
+			name:     "invalid lifetime",
+			args:     []string{"-url", servers.issuer.URL, "-scopes", "contents:read", "-lifetime", "ten minutes"},
+			wantCode: 2,
+		},
+		{
+			name: "no id-token permission",
+			args: []string{"-url", servers.issuer.URL, "-scopes", "contents:read"},
+			env: func(env map[string]string) {
+				delete(env, "ACTIONS_ID_TOKEN_REQUEST_URL")
+			},
+			wantCode: 1,
+		},
+		{
+			name:      "service error",
+			args:      []string{"-url", servers.issuer.URL, "-scopes", "contents:read"},
+			issuerErr: "insufficient permissions",
+			wantCode:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Run
+			env := servers.actionsEnv(t)
+			if tt.env != nil {
+				tt.env(env)
+			}
+			servers.mu.Lock()
+			servers.issuerErr = tt.issuerErr
+			servers.mu.Unlock()
+			exitCode := run(context.Background(), tt.args, getenvFrom(env), &bytes.Buffer{})
+
+			// Step 2: Verify
+			if exitCode != tt.wantCode {
+				t.Errorf("run() = %d, want %d", exitCode, tt.wantCode)
+			}
+			if got := readFile(t, env["GITHUB_OUTPUT"]); got != "" {
+				t.Errorf("GITHUB_OUTPUT = %q, want empty", got)
+			}
+		})
+	}
+}
+
+// TestRun_Command verifies a wrapped command gets the token and the token is revoked after it exits.
+//
+// Test steps:
+//  1. Run a shell command after "--" that checks GITHUB_TOKEN and exits with code 3
+//  2. Verify the exit code is propagated and the token is revoked
+func TestRun_Command(t *testing.T) {
+	servers := newTestServers(t)
+	env := servers.actionsEnv(t)
+	delete(env, "GITHUB_OUTPUT")
+	var stdout bytes.Buffer
+
+	// Step 1: Run
+	exitCode := run(context.Background(), []string{
+		"-url", servers.issuer.URL, "-scopes", "contents:read", "--",
+		"sh", "-c", `test "$GITHUB_TOKEN" = ghs_test && exit 3`,
+	}, getenvFrom(env), &stdout)
+
+	// Step 2: Verify
+	if exitCode != 3 {
+		t.Errorf("run() = %d, want 3 (command exit code)", exitCode)
+	}
+	if strings.Contains(strings.ReplaceAll(stdout.String(), "::add-mask::ghs_test", ""), "ghs_test") {
+		t.Errorf("stdout = %q, token printed outside add-mask", stdout.String())
+	}
+	if len(servers.revoked) != 1 || servers.revoked[0] != "ghs_test" {
+		t.Errorf("revoked tokens = %v, want [ghs_test]", servers.revoked)
+	}
+}
+
+// TestRun_Revoke verifies the revoke subcommand.
+//
+// Test steps:
+//  1. Run "revoke" with TOKEN_ISSUER_TOKEN set and unset
+//  2. Verify the token is revoked and a missing token is an error
+func TestRun_Revoke(t *testing.T) {
+	servers := newTestServers(t)
+	env := servers.actionsEnv(t)
+
+	// Step 1: Revoke without a token
+	if exitCode := run(context.Background(), []string{"revoke"}, getenvFrom(env), &bytes.Buffer{}); exitCode != 1 {
+		t.Errorf("run(revoke) without token = %d, want 1", exitCode)
+	}
+
+	// Step 2: Revoke with a token
+	env["TOKEN_ISSUER_TOKEN"] = "ghs_revoke"
+	if exitCode := run(context.Background(), []string{"revoke"}, getenvFrom(env), &bytes.Buffer{}); exitCode != 0 {
+		t.Errorf("run(revoke) = %d, want 0", exitCode)
+	}
+	if len(servers.revoked) != 1 || servers.revoked[0] != "ghs_revoke" {
+		t.Errorf("revoked tokens = %v, want [ghs_revoke]", servers.revoked)
+	}
+}
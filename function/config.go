@@ -0,0 +1,646 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secret configuration values when the configuration is printed.
+const redactedValue = "REDACTED"
+
+// minApprovalSecretLength is the minimum length of APPROVAL_SECRET.
+const minApprovalSecretLength = 32
+
+// Serving modes, named like the commands that run them.
+const (
+	// ModeFunction serves through the Functions Framework on Cloud Run, where GCP IAM validates
+	// GitHub Actions OIDC tokens before requests reach the service.
+	ModeFunction = ""
+
+	// ModeStandalone serves with the standalone HTTP server, without GCP IAM in front of it.
+	ModeStandalone = "serve"
+
+	// ModeDev serves with the fake GitHub API and OIDC issuer of the dev command.
+	ModeDev = "dev"
+)
+
+// Config is the service configuration.
+// It is loaded once at startup from an optional YAML file and environment variables,
+// where environment variables take precedence over the file.
+type Config struct {
+	// Mode is the serving mode, set by the command the service runs with (not configurable).
+	Mode string `yaml:"-"`
+
+	// GitHubAppID is the GitHub App ID used as the JWT issuer (GITHUB_APP_ID).
+	GitHubAppID string `yaml:"github_app_id"`
+
+	// ProjectID is the GCP project holding the private key secret (GOOGLE_CLOUD_PROJECT or GCP_PROJECT).
+	ProjectID string `yaml:"project_id"`
+
+	// PrivateKey is an optional PEM-encoded GitHub App private key (GITHUB_APP_PRIVATE_KEY).
+	// When set, it is used instead of the key stored in Secret Manager.
+	// Multiple concatenated keys are tried in order, like KeyCandidates.
+	PrivateKey string `yaml:"private_key"`
+
+	// KeyCandidates is the number of newest enabled Secret Manager key versions to use (KEY_CANDIDATES).
+	// The newest key is preferred; older keys are tried when GitHub rejects a JWT with 401,
+	// so that the App key can be rotated without failed issuances.
+	KeyCandidates int `yaml:"key_candidates"`
+
+	// KMSKeyVersion is an optional Cloud KMS asymmetric signing key version holding the GitHub App key
+	// (KMS_KEY_VERSION). When set, JWTs are signed by Cloud KMS and the key never leaves it.
+	KMSKeyVersion string `yaml:"kms_key_version"`
+
+	// PKCS11Module is an optional PKCS#11 module path (PKCS11_MODULE). When set, JWTs are signed
+	// by the key PKCS11KeyLabel on the token PKCS11TokenLabel, logged in with PKCS11PIN
+	// (PKCS11_KEY_LABEL, PKCS11_TOKEN_LABEL, PKCS11_PIN). Requires a build with the pkcs11 tag.
+	PKCS11Module     string `yaml:"pkcs11_module"`
+	PKCS11TokenLabel string `yaml:"pkcs11_token_label"`
+	PKCS11PIN        string `yaml:"pkcs11_pin"`
+	PKCS11KeyLabel   string `yaml:"pkcs11_key_label"`
+
+	// OIDCProviders are CI providers whose OIDC tokens are verified and accepted (YAML only).
+	// Without providers, GitHub Actions tokens are trusted as validated by GCP IAM, which is only
+	// allowed in ModeFunction. With providers, a github-actions provider is required, since the service
+	// is then invocable without IAM authentication.
+	OIDCProviders []OIDCProviderConfig `yaml:"oidc_providers"`
+
+	// Profiles are named sets of "scope:level" entries requested with ?profile=name (YAML only),
+	// e.g. release: [contents:write, pull_requests:write].
+	Profiles map[string][]string `yaml:"profiles"`
+
+	// PullRequestPolicy applies to write scopes requested by pull_request workflows (PULL_REQUEST_POLICY):
+	// deny (default), downgrade to read, or allow. Their OIDC tokens are issued for the base repository,
+	// even when the workflow runs the code of a fork.
+	PullRequestPolicy string `yaml:"pull_request_policy"`
+
+	// PullRequestTargetPolicy applies to write scopes requested by pull_request_target workflows
+	// (PULL_REQUEST_TARGET_POLICY): allow (default), downgrade to read, or deny.
+	PullRequestTargetPolicy string `yaml:"pull_request_target_policy"`
+
+	// PrivilegedScopes are "scope:level" entries only issued to GitHub Actions jobs running in a deployment
+	// environment (PRIVILEGED_SCOPES, comma-separated). A read entry also covers write.
+	// Defaults to DefaultPrivilegedScopes.
+	PrivilegedScopes []string `yaml:"privileged_scopes"`
+
+	// ProtectedEnvironments restricts privileged scopes to these environment names (PROTECTED_ENVIRONMENTS,
+	// comma-separated). Any environment is accepted if empty.
+	ProtectedEnvironments []string `yaml:"protected_environments"`
+
+	// VerifyEnvironmentProtection checks with the GitHub API that the environment of privileged scopes
+	// has required reviewers (VERIFY_ENVIRONMENT_PROTECTION). The App needs the Actions (read) permission.
+	VerifyEnvironmentProtection bool `yaml:"verify_environment_protection"`
+
+	// ApprovedWorkflows are the reusable workflows allowed to get ApprovedWorkflowScopes and privileged scopes
+	// (YAML only). No workflow restriction applies if empty.
+	ApprovedWorkflows []WorkflowRule `yaml:"approved_workflows"`
+
+	// ApprovedWorkflowScopes are "scope:level" entries restricted to ApprovedWorkflows (APPROVED_WORKFLOW_SCOPES,
+	// comma-separated). "*" matches every scope. Defaults to DefaultApprovedWorkflowScopes (all write scopes).
+	ApprovedWorkflowScopes []string `yaml:"approved_workflow_scopes"`
+
+	// OrganizationRepositories maps organization permission "scope:level" entries to the IDs of the repositories
+	// they are issued to (YAML only), matched with the repository_id claim. A write entry also covers read.
+	// Organization permissions are not issued without an entry here and in OrganizationWorkflows.
+	OrganizationRepositories map[string][]int64 `yaml:"organization_repositories"`
+
+	// OrganizationWorkflows maps organization permission "scope:level" entries to the workflows
+	// allowed to request them (YAML only), matched like ApprovedWorkflows.
+	OrganizationWorkflows map[string][]WorkflowRule `yaml:"organization_workflows"`
+
+	// ApprovalScopes are "scope:level" entries requiring a human approval of each issuance (APPROVAL_SCOPES,
+	// comma-separated). "*" matches every scope, and a read entry also covers write. No approvals if empty.
+	ApprovalScopes []string `yaml:"approval_scopes"`
+
+	// ApprovalSecret signs approval links and authorizes approval decisions of the API (APPROVAL_SECRET).
+	// Required with ApprovalScopes.
+	ApprovalSecret string `yaml:"approval_secret"`
+
+	// ApprovalTTL is how long approval requests wait for a decision and approved tokens for release (APPROVAL_TTL).
+	ApprovalTTL time.Duration `yaml:"approval_ttl"`
+
+	// ApprovalStoreFile is the JSON file persisting approval requests (APPROVAL_STORE_FILE): a local path
+	// for a single instance, or a Cloud Storage object (gs://bucket/object) shared by all instances.
+	// Required with ApprovalScopes except in ModeDev, which keeps approval requests in memory.
+	ApprovalStoreFile string `yaml:"approval_store_file"`
+
+	// ApprovalWebhookURL receives a JSON notification with the signed approve and deny links
+	// of each approval request (APPROVAL_WEBHOOK_URL). Requires ApprovalBaseURL.
+	ApprovalWebhookURL string `yaml:"approval_webhook_url"`
+
+	// ApprovalBaseURL is the public service URL of approval links and status URLs (APPROVAL_BASE_URL).
+	// Status URLs default to the URL of the token request; approval links are never built from request headers.
+	ApprovalBaseURL string `yaml:"approval_base_url"`
+
+	// MaxTokenLifetime caps the lifetime of issued tokens (MAX_TOKEN_LIFETIME), between 1m and 1h.
+	// Tokens are revoked when their lifetime ends; GitHub's one hour applies if zero.
+	// Like the lifetime parameter, it requires token revocation (see revocationUnavailable).
+	MaxTokenLifetime time.Duration `yaml:"max_token_lifetime"`
+
+	// RevocationStoreFile is the JSON file persisting scheduled token revocations (REVOCATION_STORE_FILE),
+	// so that revocations survive restarts: a local path for a single instance, or a Cloud Storage object
+	// (gs://bucket/object) shared by all instances. Required for token lifetimes except in ModeDev,
+	// which keeps revocations in memory.
+	RevocationStoreFile string `yaml:"revocation_store_file"`
+
+	// RevocationStoreKey is the base64 AES-256 key encrypting the tokens in RevocationStoreFile
+	// (REVOCATION_STORE_KEY). Required with RevocationStoreFile.
+	RevocationStoreKey string `yaml:"revocation_store_key"`
+
+	// RevocationEndpoint serves POST /revocations, revoking the tokens whose lifetime ended (REVOCATION_ENDPOINT).
+	// Function mode has no background revocation loop, so token lifetimes there require this endpoint,
+	// called every minute by a Cloud Scheduler job, and a Cloud Storage RevocationStoreFile.
+	RevocationEndpoint bool `yaml:"revocation_endpoint"`
+
+	// AllowedOwners are the account logins or numeric account IDs whose App installations can get tokens
+	// (ALLOWED_OWNERS, comma-separated). Installations of any account are allowed if both AllowedOwners
+	// and AllowedInstallations are empty.
+	AllowedOwners []string `yaml:"allowed_owners"`
+
+	// AllowedInstallations are the App installation IDs that can get tokens (ALLOWED_INSTALLATIONS, comma-separated),
+	// in addition to the installations of AllowedOwners.
+	AllowedInstallations []int64 `yaml:"allowed_installations"`
+
+	// GitHubWebhookSecret is the webhook secret of the GitHub App (GITHUB_WEBHOOK_SECRET).
+	// When set, App webhooks are accepted on POST /webhook.
+	GitHubWebhookSecret string `yaml:"github_webhook_secret"`
+
+	// UninstallUnknownInstallations deletes App installations not allowed by AllowedOwners and AllowedInstallations
+	// when their installation webhook is received (UNINSTALL_UNKNOWN_INSTALLATIONS).
+	// Requires GitHubWebhookSecret and an allowlist.
+	UninstallUnknownInstallations bool `yaml:"uninstall_unknown_installations"`
+
+	// GitHubAPIURL is the base URL of the GitHub REST API (GITHUB_API_URL).
+	// Defaults to https://api.github.com/.
+	GitHubAPIURL string `yaml:"github_api_url"`
+
+	// Port is the HTTP port to listen on (PORT).
+	Port string `yaml:"port"`
+
+	// ReadyzCheckGitHub enables the GitHub /app check of the readiness endpoint (READYZ_CHECK_GITHUB).
+	ReadyzCheckGitHub bool `yaml:"readyz_check_github"`
+
+	// ListenAddr is the address the standalone server listens on (LISTEN_ADDR).
+	// Defaults to all interfaces on Port.
+	ListenAddr string `yaml:"listen_addr"`
+
+	// TLSCertFile and TLSKeyFile enable TLS for the standalone server (TLS_CERT_FILE, TLS_KEY_FILE).
+	// The files are reloaded when they change on disk.
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+
+	// ReadTimeout, WriteTimeout and IdleTimeout are the standalone server timeouts
+	// (READ_TIMEOUT, WRITE_TIMEOUT, IDLE_TIMEOUT).
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+
+	// ShutdownTimeout is how long the standalone server waits for in-flight requests on shutdown (SHUTDOWN_TIMEOUT).
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// DefaultConfig returns the configuration with default values applied.
+func DefaultConfig() *Config {
+	return &Config{
+		Port:                    "8080",
+		KeyCandidates:           2,
+		PullRequestPolicy:       EventPolicyDeny,
+		PullRequestTargetPolicy: EventPolicyAllow,
+		PrivilegedScopes:        append([]string(nil), DefaultPrivilegedScopes...),
+		ApprovedWorkflowScopes:  append([]string(nil), DefaultApprovedWorkflowScopes...),
+		ApprovalTTL:             30 * time.Minute,
+		ReadTimeout:             10 * time.Second,
+		WriteTimeout:            40 * time.Second, // Longer than the 30 seconds token request timeout
+		IdleTimeout:             120 * time.Second,
+		ShutdownTimeout:         30 * time.Second,
+	}
+}
+
+// LoadConfig loads the configuration from the YAML file at path (if path is not empty)
+// and then from environment variables.
+// All errors are reported together.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+
+	envString("GITHUB_APP_ID", &config.GitHubAppID)
+	envString("GOOGLE_CLOUD_PROJECT", &config.ProjectID)
+	if os.Getenv("GOOGLE_CLOUD_PROJECT") == "" {
+		// Try alternative environment variable
+		envString("GCP_PROJECT", &config.ProjectID)
+	}
+	envString("GITHUB_APP_PRIVATE_KEY", &config.PrivateKey)
+	envInt("KEY_CANDIDATES", &config.KeyCandidates, &errs)
+	envString("KMS_KEY_VERSION", &config.KMSKeyVersion)
+	envString("PKCS11_MODULE", &config.PKCS11Module)
+	envString("PKCS11_TOKEN_LABEL", &config.PKCS11TokenLabel)
+	envString("PKCS11_PIN", &config.PKCS11PIN)
+	envString("PKCS11_KEY_LABEL", &config.PKCS11KeyLabel)
+	envString("PULL_REQUEST_POLICY", &config.PullRequestPolicy)
+	envString("PULL_REQUEST_TARGET_POLICY", &config.PullRequestTargetPolicy)
+	envStringList("PRIVILEGED_SCOPES", &config.PrivilegedScopes)
+	envStringList("PROTECTED_ENVIRONMENTS", &config.ProtectedEnvironments)
+	envBool("VERIFY_ENVIRONMENT_PROTECTION", &config.VerifyEnvironmentProtection, &errs)
+	envStringList("APPROVED_WORKFLOW_SCOPES", &config.ApprovedWorkflowScopes)
+	envStringList("APPROVAL_SCOPES", &config.ApprovalScopes)
+	envString("APPROVAL_SECRET", &config.ApprovalSecret)
+	envDuration("APPROVAL_TTL", &config.ApprovalTTL, &errs)
+	envString("APPROVAL_STORE_FILE", &config.ApprovalStoreFile)
+	envString("APPROVAL_WEBHOOK_URL", &config.ApprovalWebhookURL)
+	envString("APPROVAL_BASE_URL", &config.ApprovalBaseURL)
+	envDuration("MAX_TOKEN_LIFETIME", &config.MaxTokenLifetime, &errs)
+	envString("REVOCATION_STORE_FILE", &config.RevocationStoreFile)
+	envString("REVOCATION_STORE_KEY", &config.RevocationStoreKey)
+	envBool("REVOCATION_ENDPOINT", &config.RevocationEndpoint, &errs)
+	envStringList("ALLOWED_OWNERS", &config.AllowedOwners)
+	envInt64List("ALLOWED_INSTALLATIONS", &config.AllowedInstallations, &errs)
+	envString("GITHUB_WEBHOOK_SECRET", &config.GitHubWebhookSecret)
+	envBool("UNINSTALL_UNKNOWN_INSTALLATIONS", &config.UninstallUnknownInstallations, &errs)
+	envString("GITHUB_API_URL", &config.GitHubAPIURL)
+	envString("PORT", &config.Port)
+	envBool("READYZ_CHECK_GITHUB", &config.ReadyzCheckGitHub, &errs)
+	envString("LISTEN_ADDR", &config.ListenAddr)
+	envString("TLS_CERT_FILE", &config.TLSCertFile)
+	envString("TLS_KEY_FILE", &config.TLSKeyFile)
+	envDuration("READ_TIMEOUT", &config.ReadTimeout, &errs)
+	envDuration("WRITE_TIMEOUT", &config.WriteTimeout, &errs)
+	envDuration("IDLE_TIMEOUT", &config.IdleTimeout, &errs)
+	envDuration("SHUTDOWN_TIMEOUT", &config.ShutdownTimeout, &errs)
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return config, nil
+}
+
+// Validate checks that the configuration is complete and consistent.
+// All errors are reported together.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.GitHubAppID == "" {
+		errs = append(errs, fmt.Errorf("GITHUB_APP_ID not configured"))
+	}
+
+	keySources := 0
+	for _, value := range []string{c.PrivateKey, c.KMSKeyVersion, c.PKCS11Module} {
+		if value != "" {
+			keySources++
+		}
+	}
+	if keySources > 1 {
+		errs = append(errs, fmt.Errorf("only one of GITHUB_APP_PRIVATE_KEY, KMS_KEY_VERSION and PKCS11_MODULE can be configured"))
+	}
+
+	switch {
+	case c.PrivateKey != "":
+		if _, err := ParsePrivateKeys([]byte(c.PrivateKey)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid GITHUB_APP_PRIVATE_KEY: %w", err))
+		}
+	case c.KMSKeyVersion != "":
+		if !strings.HasPrefix(c.KMSKeyVersion, "projects/") || !strings.Contains(c.KMSKeyVersion, "/cryptoKeyVersions/") {
+			errs = append(errs, fmt.Errorf("invalid KMS_KEY_VERSION '%s': must be projects/*/locations/*/keyRings/*/cryptoKeys/*/cryptoKeyVersions/*", c.KMSKeyVersion))
+		}
+	case c.PKCS11Module != "":
+		if c.PKCS11TokenLabel == "" || c.PKCS11KeyLabel == "" {
+			errs = append(errs, fmt.Errorf("PKCS11_TOKEN_LABEL and PKCS11_KEY_LABEL are required with PKCS11_MODULE"))
+		}
+	case c.ProjectID == "":
+		errs = append(errs, fmt.Errorf("GCP project ID not configured (required to read the private key from Secret Manager)"))
+	}
+
+	if c.KeyCandidates < 1 {
+		errs = append(errs, fmt.Errorf("invalid KEY_CANDIDATES '%d': must be at least 1", c.KeyCandidates))
+	}
+
+	issuers := make(map[string]bool)
+	for i, provider := range c.OIDCProviders {
+		if err := provider.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid oidc_providers[%d]: %w", i, err))
+			continue
+		}
+		issuer := provider.Issuer
+		if issuer == "" {
+			issuer = GitHubActionsIssuer
+		}
+		issuer = strings.TrimSuffix(issuer, "/")
+		if issuers[issuer] {
+			errs = append(errs, fmt.Errorf("invalid oidc_providers[%d]: duplicate issuer '%s'", i, issuer))
+		}
+		issuers[issuer] = true
+	}
+	if c.Mode == ModeStandalone && !hasGitHubActionsProvider(c.OIDCProviders) {
+		errs = append(errs, fmt.Errorf("oidc_providers with a github-actions provider is required in %s mode (no GCP IAM validates OIDC tokens)", c.Mode))
+	} else if len(c.OIDCProviders) > 0 && !hasGitHubActionsProvider(c.OIDCProviders) {
+		errs = append(errs, fmt.Errorf("oidc_providers requires a github-actions provider (GitHub Actions tokens are not validated by GCP IAM when other providers are accepted)"))
+	}
+
+	for _, name := range profileNames(c) {
+		if !profileNamePattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("invalid profiles name '%s': must be lowercase letters, digits, '-' and '_'", name))
+			continue
+		}
+		if err := validateProfile(c.Profiles[name]); err != nil {
+			errs = append(errs, fmt.Errorf("invalid profiles[%s]: %w", name, err))
+		}
+	}
+
+	policies := []struct {
+		name  string
+		value string
+	}{
+		{"PULL_REQUEST_POLICY", c.PullRequestPolicy},
+		{"PULL_REQUEST_TARGET_POLICY", c.PullRequestTargetPolicy},
+	}
+	for _, policy := range policies {
+		switch policy.value {
+		case EventPolicyAllow, EventPolicyDeny, EventPolicyDowngrade:
+		default:
+			errs = append(errs, fmt.Errorf("invalid %s '%s': must be allow, deny or downgrade", policy.name, policy.value))
+		}
+	}
+
+	for _, entry := range c.PrivilegedScopes {
+		scopeID, level, _ := strings.Cut(entry, ":")
+		if err := ValidateScopes(map[string]string{scopeID: level}); err != nil {
+			errs = append(errs, fmt.Errorf("invalid PRIVILEGED_SCOPES entry '%s': %w", entry, err))
+		}
+	}
+
+	for i, rule := range c.ApprovedWorkflows {
+		if err := rule.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid approved_workflows[%d]: %w", i, err))
+		}
+	}
+	for _, entry := range c.ApprovedWorkflowScopes {
+		scopeID, level, _ := strings.Cut(entry, ":")
+		if scopeID == "*" && permissionRank[level] > 0 {
+			continue
+		}
+		if err := ValidateScopes(map[string]string{scopeID: level}); err != nil {
+			errs = append(errs, fmt.Errorf("invalid APPROVED_WORKFLOW_SCOPES entry '%s': %w", entry, err))
+		}
+	}
+
+	errs = append(errs, validateOrganizationPolicy(c)...)
+
+	for _, entry := range c.ApprovalScopes {
+		scopeID, level, _ := strings.Cut(entry, ":")
+		if scopeID == "*" && permissionRank[level] > 0 {
+			continue
+		}
+		if err := ValidateScopes(map[string]string{scopeID: level}); err != nil {
+			errs = append(errs, fmt.Errorf("invalid APPROVAL_SCOPES entry '%s': %w", entry, err))
+		}
+	}
+	if len(c.ApprovalScopes) > 0 && len(c.ApprovalSecret) < minApprovalSecretLength {
+		errs = append(errs, fmt.Errorf("APPROVAL_SECRET of at least %d characters is required with APPROVAL_SCOPES", minApprovalSecretLength))
+	}
+	if len(c.ApprovalScopes) > 0 && c.Mode == ModeFunction {
+		errs = append(errs, fmt.Errorf("APPROVAL_SCOPES is not supported in function mode (Cloud Run IAM rejects approval links opened in browsers), use the serve mode"))
+	}
+	if len(c.ApprovalScopes) > 0 && c.ApprovalStoreFile == "" && c.Mode != ModeDev {
+		errs = append(errs, fmt.Errorf("APPROVAL_STORE_FILE is required with APPROVAL_SCOPES (in-memory approval requests are lost across instances)"))
+	}
+	if strings.HasPrefix(c.ApprovalStoreFile, gcsLocationPrefix) {
+		if _, _, err := parseGCSLocation(c.ApprovalStoreFile); err != nil {
+			errs = append(errs, fmt.Errorf("invalid APPROVAL_STORE_FILE '%s': %w", c.ApprovalStoreFile, err))
+		}
+	}
+	if c.ApprovalWebhookURL != "" && c.ApprovalBaseURL == "" {
+		errs = append(errs, fmt.Errorf("APPROVAL_BASE_URL is required with APPROVAL_WEBHOOK_URL (approval links aren't built from request headers)"))
+	}
+	if c.ApprovalTTL <= 0 {
+		errs = append(errs, fmt.Errorf("invalid APPROVAL_TTL '%s': must be positive", c.ApprovalTTL))
+	}
+	if c.MaxTokenLifetime != 0 && (c.MaxTokenLifetime < minTokenLifetime || c.MaxTokenLifetime > maxTokenLifetime) {
+		errs = append(errs, fmt.Errorf("invalid MAX_TOKEN_LIFETIME '%s': must be between %s and %s", c.MaxTokenLifetime, minTokenLifetime, maxTokenLifetime))
+	}
+	if reason := c.revocationUnavailable(); c.MaxTokenLifetime != 0 && reason != "" {
+		errs = append(errs, fmt.Errorf("MAX_TOKEN_LIFETIME is not supported: %s", reason))
+	}
+	if c.RevocationEndpoint && c.Mode != ModeFunction {
+		errs = append(errs, fmt.Errorf("REVOCATION_ENDPOINT is only supported in function mode (%s mode revokes tokens in the background)", c.Mode))
+	}
+	if c.RevocationEndpoint && c.RevocationStoreFile == "" {
+		errs = append(errs, fmt.Errorf("REVOCATION_STORE_FILE is required with REVOCATION_ENDPOINT"))
+	}
+	if strings.HasPrefix(c.RevocationStoreFile, gcsLocationPrefix) {
+		if _, _, err := parseGCSLocation(c.RevocationStoreFile); err != nil {
+			errs = append(errs, fmt.Errorf("invalid REVOCATION_STORE_FILE '%s': %w", c.RevocationStoreFile, err))
+		}
+	}
+	if c.RevocationStoreFile != "" {
+		if key, err := base64.StdEncoding.DecodeString(c.RevocationStoreKey); err != nil || len(key) != revocationStoreKeySize {
+			errs = append(errs, fmt.Errorf("REVOCATION_STORE_KEY of %d base64 bytes is required with REVOCATION_STORE_FILE", revocationStoreKeySize))
+		}
+	}
+	for _, approvalURL := range []struct {
+		name  string
+		value string
+	}{
+		{"APPROVAL_WEBHOOK_URL", c.ApprovalWebhookURL},
+		{"APPROVAL_BASE_URL", c.ApprovalBaseURL},
+	} {
+		if approvalURL.value == "" {
+			continue
+		}
+		if parsed, err := url.Parse(approvalURL.value); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid %s '%s': must be an absolute URL", approvalURL.name, approvalURL.value))
+		}
+	}
+
+	for _, owner := range c.AllowedOwners {
+		if owner == "" || strings.ContainsAny(owner, "/ ") {
+			errs = append(errs, fmt.Errorf("invalid ALLOWED_OWNERS entry '%s': must be an account login or ID", owner))
+		}
+	}
+	for _, id := range c.AllowedInstallations {
+		if id <= 0 {
+			errs = append(errs, fmt.Errorf("invalid ALLOWED_INSTALLATIONS entry '%d': must be positive", id))
+		}
+	}
+	if c.GitHubWebhookSecret != "" && c.Mode == ModeFunction {
+		errs = append(errs, fmt.Errorf("GITHUB_WEBHOOK_SECRET is not supported in function mode (Cloud Run IAM rejects the unauthenticated webhook deliveries of GitHub), use the serve mode"))
+	}
+	if c.UninstallUnknownInstallations {
+		if c.GitHubWebhookSecret == "" {
+			errs = append(errs, fmt.Errorf("GITHUB_WEBHOOK_SECRET is required with UNINSTALL_UNKNOWN_INSTALLATIONS"))
+		}
+		if len(c.AllowedOwners) == 0 && len(c.AllowedInstallations) == 0 {
+			errs = append(errs, fmt.Errorf("ALLOWED_OWNERS or ALLOWED_INSTALLATIONS is required with UNINSTALL_UNKNOWN_INSTALLATIONS"))
+		}
+	}
+
+	if c.GitHubAPIURL != "" {
+		if parsed, err := url.Parse(c.GitHubAPIURL); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid GITHUB_API_URL '%s': must be an absolute URL", c.GitHubAPIURL))
+		}
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port '%s': must be a number between 1 and 65535", c.Port))
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be configured together"))
+	}
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"READ_TIMEOUT", c.ReadTimeout},
+		{"WRITE_TIMEOUT", c.WriteTimeout},
+		{"IDLE_TIMEOUT", c.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("invalid %s '%s': must be positive", timeout.name, timeout.value))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ListenAddress returns the address the standalone server listens on.
+func (c *Config) ListenAddress() string {
+	if c.ListenAddr != "" {
+		return c.ListenAddr
+	}
+	return ":" + c.Port
+}
+
+// Redacted returns a copy of the configuration with secret values replaced.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	if redacted.PrivateKey != "" {
+		redacted.PrivateKey = redactedValue
+	}
+	if redacted.PKCS11PIN != "" {
+		redacted.PKCS11PIN = redactedValue
+	}
+	if redacted.ApprovalSecret != "" {
+		redacted.ApprovalSecret = redactedValue
+	}
+	if redacted.GitHubWebhookSecret != "" {
+		redacted.GitHubWebhookSecret = redactedValue
+	}
+	if redacted.RevocationStoreKey != "" {
+		redacted.RevocationStoreKey = redactedValue
+	}
+	return &redacted
+}
+
+// String returns the redacted configuration in YAML format.
+func (c *Config) String() string {
+	data, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Sprintf("failed to encode config: %v", err)
+	}
+	return string(data)
+}
+
+// envString sets target to the value of the environment variable if it is not empty.
+func envString(name string, target *string) {
+	if value := os.Getenv(name); value != "" {
+		*target = value
+	}
+}
+
+// envStringList sets target to the comma-separated values of the environment variable if it is not empty.
+func envStringList(name string, target *[]string) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	*target = values
+}
+
+// envInt64List sets target to the comma-separated integer values of the environment variable if it is not empty.
+func envInt64List(name string, target *[]int64, errs *[]error) {
+	var items []string
+	envStringList(name, &items)
+	if items == nil {
+		return
+	}
+	values := make([]int64, 0, len(items))
+	for _, item := range items {
+		parsed, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("invalid %s value '%s': must be comma-separated integers", name, item))
+			return
+		}
+		values = append(values, parsed)
+	}
+	*target = values
+}
+
+// envBool sets target to the boolean value of the environment variable if it is not empty.
+func envBool(name string, target *bool, errs *[]error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("invalid %s value '%s': must be a boolean", name, value))
+		return
+	}
+	*target = parsed
+}
+
+// envInt sets target to the integer value of the environment variable if it is not empty.
+func envInt(name string, target *int, errs *[]error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("invalid %s value '%s': must be an integer", name, value))
+		return
+	}
+	*target = parsed
+}
+
+// envDuration sets target to the duration value of the environment variable if it is not empty.
+func envDuration(name string, target *time.Duration, errs *[]error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("invalid %s value '%s': must be a duration such as 30s", name, value))
+		return
+	}
+	*target = parsed
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// devInstallationID is the installation ID of the fake GitHub App installation in dev mode.
+const devInstallationID = 1
+
+// RunDev runs the service fully offline for local development.
+// It serves, on a single listener:
+// - /github/: a fake GitHub Apps API with the App installed on the configured repositories
+// - /oidc/: a fake OIDC issuer minting GitHub Actions-like ID tokens, verified by the service like GitHub's
+// - every other path: the service itself, configured with a throwaway App private key
+//
+// It blocks until ctx is cancelled.
+func RunDev(ctx context.Context, config *Config, args []string) error {
+	flags := flag.NewFlagSet("dev", flag.ContinueOnError)
+	permissionsFlag := flags.String("permissions", "",
+		"installation permissions as comma-separated scope_id=level pairs (default: every allowed scope at its highest level)")
+	repositoriesFlag := flags.String("repositories", "",
+		"comma-separated owner/repo names the App is installed on (default: every repository)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	installationPermissions, err := devInstallationPermissions(*permissionsFlag)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", config.ListenAddress())
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", config.ListenAddress(), err)
+	}
+	baseURL := "http://" + devHostPort(listener.Addr())
+
+	// Configure the service with a throwaway App key and the fake GitHub API
+	appKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("failed to generate App private key: %w", err)
+	}
+	config.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(appKey)}))
+	config.KMSKeyVersion, config.PKCS11Module = "", ""
+	if config.GitHubAppID == "" {
+		config.GitHubAppID = "1"
+	}
+	config.GitHubAPIURL = baseURL + "/github/"
+	config.OIDCProviders = devOIDCProviders(config.OIDCProviders, baseURL+"/oidc", baseURL)
+	config.TLSCertFile, config.TLSKeyFile = "", ""
+	config.Mode = ModeDev
+	if err := config.Validate(); err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	appID, _ := strconv.ParseInt(config.GitHubAppID, 10, 64)
+	fakeGitHub := NewFakeGitHub(appID)
+	var repositories []string
+	if *repositoriesFlag != "" {
+		repositories = strings.Split(*repositoriesFlag, ",")
+	}
+	fakeGitHub.AddInstallation(&FakeInstallation{
+		ID:           devInstallationID,
+		Repositories: repositories,
+		Permissions:  installationPermissions,
+	})
+
+	oidcKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("failed to generate OIDC issuer key: %w", err)
+	}
+	fakeOIDC := NewFakeOIDCIssuer(baseURL+"/oidc", oidcKey)
+	fakeOIDC.DefaultClaims = map[string]interface{}{"aud": baseURL}
+
+	server := NewServer(config)
+	go server.RunRevocations(ctx)
+	mux := http.NewServeMux()
+	mux.Handle("/github/", http.StripPrefix("/github", fakeGitHub))
+	mux.Handle("/oidc/", http.StripPrefix("/oidc", fakeOIDC))
+	mux.Handle("/", server.Routes())
+
+	log.Printf(`Development mode (offline, fake GitHub and OIDC issuer)
+
+  Fake GitHub API:  %[1]s/github/
+  Fake OIDC issuer: %[1]s/oidc
+
+Request a token:
+
+  OIDC_TOKEN=$(curl -s '%[1]s/oidc/token?repository=owner/repo' | jq -r .value)
+  curl -X POST -H "Authorization: Bearer ${OIDC_TOKEN}" '%[1]s/token?contents=write'
+`, baseURL)
+
+	return serveStandalone(ctx, listener, config, mux, server.startDraining)
+}
+
+// devOIDCProviders returns the providers with the github-actions provider replaced by the fake OIDC issuer,
+// so that dev mode tokens are verified with the issuer's JWKS like GitHub Actions tokens in production.
+func devOIDCProviders(providers []OIDCProviderConfig, issuer, audience string) []OIDCProviderConfig {
+	result := []OIDCProviderConfig{{Type: "github-actions", Issuer: issuer, Audience: audience}}
+	for _, provider := range providers {
+		if provider.Type != "github-actions" {
+			result = append(result, provider)
+		}
+	}
+	return result
+}
+
+// devInstallationPermissions parses scope_id=level pairs into GitHub API installation permissions.
+// An empty value grants every allowed scope at its highest level.
+func devInstallationPermissions(value string) (map[string]string, error) {
+	scopes := make(map[string]string)
+	if value == "" {
+		for scopeID, levels := range AllowedScopes {
+			scopes[scopeID] = levels[len(levels)-1]
+		}
+	} else {
+		for _, pair := range strings.Split(value, ",") {
+			scopeID, level, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || (level != "read" && level != "write") {
+				return nil, fmt.Errorf("invalid permission '%s' (must be scope_id=read or scope_id=write)", pair)
+			}
+			scopes[scopeID] = level
+		}
+	}
+
+	permissions, err := BuildInstallationPermissions(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert to GitHub API permission names through the API representation
+	data, err := json.Marshal(permissions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode permissions: %w", err)
+	}
+	var installationPermissions map[string]string
+	if err := json.Unmarshal(data, &installationPermissions); err != nil {
+		return nil, fmt.Errorf("failed to decode permissions: %w", err)
+	}
+
+	return installationPermissions, nil
+}
+
+// devHostPort returns the host:port to reach addr from the local machine.
+func devHostPort(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
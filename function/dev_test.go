@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestDevInstallationPermissions tests conversion of dev mode permissions to GitHub API names.
+//
+// Test steps:
+//  1. Call devInstallationPermissions with the flag value
+//  2. Verify scope IDs are mapped to GitHub API permission names
+//  3. Verify invalid values are rejected
+func TestDevInstallationPermissions(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		want        map[string]string
+		errContains string
+	}{
+		{
+			name:  "explicit permissions",
+			value: "contents=write, projects=read,secret_scanning=read",
+			want:  map[string]string{"contents": "write", "repository_projects": "read", "secret_scanning_alerts": "read"},
+		},
+		{
+			name:        "missing level",
+			value:       "contents",
+			errContains: "invalid permission",
+		},
+		{
+			name:        "unknown scope",
+			value:       "unknown=read",
+			errContains: "unknown scope ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Convert permissions
+			got, err := devInstallationPermissions(tt.value)
+
+			// Step 3: Verify errors
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("devInstallationPermissions() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+
+			// Step 2: Verify mapping
+			if err != nil {
+				t.Fatalf("devInstallationPermissions() unexpected error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("devInstallationPermissions() = %v, want %v", got, tt.want)
+			}
+			for name, level := range tt.want {
+				if got[name] != level {
+					t.Errorf("devInstallationPermissions()[%s] = %v, want %v", name, got[name], level)
+				}
+			}
+		})
+	}
+}
+
+// TestDevInstallationPermissions_Default tests that every allowed scope is granted by default.
+//
+// Test steps:
+//  1. Call devInstallationPermissions without a value
+//  2. Verify one permission is granted per allowed scope
+func TestDevInstallationPermissions_Default(t *testing.T) {
+	// Step 1: Convert default permissions
+	got, err := devInstallationPermissions("")
+	if err != nil {
+		t.Fatalf("devInstallationPermissions() error = %v", err)
+	}
+
+	// Step 2: Verify count
+	if len(got) != len(AllowedScopes) {
+		t.Errorf("devInstallationPermissions() granted %d permissions, want %d", len(got), len(AllowedScopes))
+	}
+}
+
+// TestDevOIDCProviders tests that dev mode verifies GitHub Actions tokens with the fake OIDC issuer.
+//
+// Test steps:
+//  1. Replace configured providers with the fake issuer
+//  2. Verify the github-actions provider is the fake issuer and other providers are kept
+//  3. Verify the fake issuer's tokens are verified, and tokens signed with another key are rejected
+func TestDevOIDCProviders(t *testing.T) {
+	// Step 1: Replace providers
+	fake := newTestOIDCIssuer(t)
+	gitlab := OIDCProviderConfig{Type: "gitlab", Issuer: "https://gitlab.com", Audience: "a", Repositories: map[string]string{"group/project": "owner/repo"}}
+	providers := devOIDCProviders([]OIDCProviderConfig{{Type: "github-actions", Audience: "a"}, gitlab}, fake.Issuer, "http://localhost:8080")
+
+	// Step 2: Verify providers
+	if len(providers) != 2 || providers[0].Type != "github-actions" || providers[0].Issuer != fake.Issuer || providers[1].Type != "gitlab" {
+		t.Fatalf("devOIDCProviders() = %+v, want the fake github-actions issuer and gitlab", providers)
+	}
+
+	// Step 3: Verify tokens
+	verifier := NewOIDCVerifier(providers)
+	token, err := fake.Mint(map[string]interface{}{"aud": "http://localhost:8080", "repository": "owner/repo"})
+	if err != nil {
+		t.Fatalf("Mint() error = %v", err)
+	}
+	if claims, err := verifier.Verify(context.Background(), token); err != nil || claims.RepositoryID != FakeRepositoryID("owner/repo") {
+		t.Errorf("Verify() = %+v, %v, want owner/repo", claims, err)
+	}
+	forged, err := NewFakeOIDCIssuer(fake.Issuer, generateTestRSAKey(t)).Mint(map[string]interface{}{"aud": "http://localhost:8080", "repository": "owner/repo"})
+	if err != nil {
+		t.Fatalf("Mint() error = %v", err)
+	}
+	if _, err := verifier.Verify(context.Background(), forged); err == nil || !strings.Contains(err.Error(), "token verification failed") {
+		t.Errorf("Verify() forged error = %v, want containing %q", err, "token verification failed")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Decisions of the effective scope policy.
+const (
+	ScopeDecisionAllow     = "allow"
+	ScopeDecisionDowngrade = "downgrade"
+	ScopeDecisionApproval  = "approval"
+	ScopeDecisionDeny      = "deny"
+)
+
+// ScopesResponse is the response format of the scope discovery endpoint.
+type ScopesResponse struct {
+	Scopes            map[string][]string `json:"scopes"`
+	BlacklistedScopes []string            `json:"blacklisted_scopes"`
+	Profiles          map[string][]string `json:"profiles,omitempty"`
+
+	// OrganizationScopes are the organization permissions, issued only under the organization policy.
+	OrganizationScopes map[string][]string `json:"organization_scopes"`
+
+	// Policy is the effective policy of the caller's repository, if the request has an OIDC token.
+	Policy *ScopePolicy `json:"policy,omitempty"`
+}
+
+// ScopePolicy is the effective policy of a caller: the decision for each scope ID and permission level.
+type ScopePolicy struct {
+	Repository string                              `json:"repository"`
+	Scopes     map[string]map[string]ScopeDecision `json:"scopes"`
+}
+
+// ScopeDecision is the decision of the token request policies for a scope at a permission level.
+// Policy and Reason name the denying policy and its error; Level and Policy are the issued level and the policy of a downgrade.
+type ScopeDecision struct {
+	Decision string `json:"decision"`
+	Level    string `json:"level,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// EffectiveScopePolicy evaluates the token request policies for each allowed scope, organization permission
+// and permission level requested alone by the caller. Only policies decided by the OIDC claims are evaluated; the installation
+// allowlist and the environment protection check require GitHub API calls and are applied at issuance.
+func EffectiveScopePolicy(config *Config, claims *OIDCClaims) *ScopePolicy {
+	policy := &ScopePolicy{
+		Repository: claims.Repository,
+		Scopes:     make(map[string]map[string]ScopeDecision, len(scopeDefinitionsByID)),
+	}
+	for _, definition := range scopeDefinitionsByID {
+		decisions := make(map[string]ScopeDecision, len(definition.Levels))
+		for _, level := range definition.Levels {
+			decisions[level] = scopeDecision(config, claims, definition.ID, level)
+		}
+		policy.Scopes[definition.ID] = decisions
+	}
+	return policy
+}
+
+// scopeDecision applies the token request policies, in the order of TokenHandler, to a single scope.
+func scopeDecision(config *Config, claims *OIDCClaims, scopeID, level string) ScopeDecision {
+	scopes := map[string]string{scopeID: level}
+	repositoryScopes, organizationScopes := SplitOrganizationScopes(scopes)
+	if err := ValidateScopes(repositoryScopes); err != nil {
+		return ScopeDecision{Decision: ScopeDecisionDeny, Policy: "scope", Reason: err.Error()}
+	}
+	if err := ValidateOrganizationScopes(organizationScopes); err != nil {
+		return ScopeDecision{Decision: ScopeDecisionDeny, Policy: "scope", Reason: err.Error()}
+	}
+	if err := ApplyOrganizationPolicy(config, claims, scopes); err != nil {
+		return ScopeDecision{Decision: ScopeDecisionDeny, Policy: "organization_policy", Reason: err.Error()}
+	}
+	scopes, downgrades, err := ApplyEventPolicy(config, claims, scopes)
+	if err != nil {
+		return ScopeDecision{Decision: ScopeDecisionDeny, Policy: "event_policy", Reason: err.Error()}
+	}
+	if err := ApplyWorkflowPolicy(config, claims, scopes); err != nil {
+		return ScopeDecision{Decision: ScopeDecisionDeny, Policy: "workflow_policy", Reason: err.Error()}
+	}
+	if _, err := ApplyEnvironmentPolicy(config, claims, scopes); err != nil {
+		return ScopeDecision{Decision: ScopeDecisionDeny, Policy: "environment_policy", Reason: err.Error()}
+	}
+	if len(restrictedScopes(config.ApprovalScopes, scopes)) > 0 {
+		return ScopeDecision{Decision: ScopeDecisionApproval}
+	}
+	if len(downgrades) > 0 {
+		return ScopeDecision{Decision: ScopeDecisionDowngrade, Level: downgrades[0].Granted, Policy: downgrades[0].Policy}
+	}
+	return ScopeDecision{Decision: ScopeDecisionAllow}
+}
+
+// ScopesHandler handles GET /scopes requests.
+// It returns the allowed and blacklisted scopes, the organization permissions and the profiles. With an OIDC token in the Authorization header,
+// the response also contains the effective policy of the caller's repository.
+func (s *Server) ScopesHandler(w http.ResponseWriter, r *http.Request) {
+	logger := NewRequestLogger(r)
+
+	response := ScopesResponse{
+		Scopes:            AllowedScopes,
+		BlacklistedScopes: make([]string, 0, len(BlacklistedScopes)),
+		Profiles:          s.config.Profiles,
+
+		OrganizationScopes: OrganizationScopes,
+	}
+	for scopeID, blacklisted := range BlacklistedScopes {
+		if blacklisted {
+			response.BlacklistedScopes = append(response.BlacklistedScopes, scopeID)
+		}
+	}
+	sort.Strings(response.BlacklistedScopes)
+
+	if r.Header.Get("Authorization") != "" {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		claims, ok := s.authenticate(ctx, w, r, logger)
+		if !ok {
+			return
+		}
+		response.Policy = EffectiveScopePolicy(s.config, claims)
+	}
+
+	logger.LogResponse(http.StatusOK, nil)
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestEffectiveScopePolicy tests the decisions of the effective policy of a caller.
+//
+// Test steps:
+//  1. Configure the downgrade pull request policy, privileged and approval scopes
+//  2. Evaluate the policy of a pull_request job and a job in a protected environment
+//  3. Verify the decision of each test case
+func TestEffectiveScopePolicy(t *testing.T) {
+	// Step 1: Configure policies
+	config := testConfig()
+	config.PullRequestPolicy = EventPolicyDowngrade
+	config.ApprovalScopes = []string{"contents:write"}
+
+	// Step 2: Evaluate policies
+	pullRequest := EffectiveScopePolicy(config, &OIDCClaims{
+		Repository: "owner/repo",
+		Provider:   "github-actions",
+		Claims:     map[string]interface{}{"event_name": "pull_request"},
+	})
+	deployment := EffectiveScopePolicy(config, &OIDCClaims{
+		Repository: "owner/repo",
+		Provider:   "github-actions",
+		Claims:     map[string]interface{}{"event_name": "push", "environment": "production"},
+	})
+
+	tests := []struct {
+		name         string
+		policy       *ScopePolicy
+		scope        string
+		level        string
+		wantDecision string
+		wantLevel    string
+		wantPolicy   string
+	}{
+		{name: "read is allowed", policy: pullRequest, scope: "issues", level: "read", wantDecision: ScopeDecisionAllow},
+		{name: "write is downgraded in pull requests", policy: pullRequest, scope: "issues", level: "write", wantDecision: ScopeDecisionDowngrade, wantLevel: "read", wantPolicy: "pull_request_policy"},
+		{name: "privileged scope outside environment", policy: pullRequest, scope: "administration", level: "read", wantDecision: ScopeDecisionDeny, wantPolicy: "environment_policy"},
+		{name: "privileged scope in environment", policy: deployment, scope: "secrets", level: "write", wantDecision: ScopeDecisionAllow},
+		{name: "approval scope", policy: deployment, scope: "contents", level: "write", wantDecision: ScopeDecisionApproval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 3: Verify decision
+			got := tt.policy.Scopes[tt.scope][tt.level]
+			if got.Decision != tt.wantDecision || got.Level != tt.wantLevel || got.Policy != tt.wantPolicy {
+				t.Errorf("Scopes[%s][%s] = %+v, want decision %q, level %q, policy %q",
+					tt.scope, tt.level, got, tt.wantDecision, tt.wantLevel, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+// TestE2E_Scopes tests the scope discovery endpoint with and without an OIDC token.
+//
+// Test steps:
+//  1. Start the service with a release profile
+//  2. Get the scopes without an OIDC token and verify the scopes and profiles, without policy
+//  3. Get the scopes with an OIDC token and verify the policy of the caller's repository
+//  4. Verify invalid OIDC tokens are rejected
+func TestE2E_Scopes(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironmentWithConfig(t, func(config *Config) {
+		config.Profiles = map[string][]string{"release": {"contents:write"}}
+	})
+	getScopes := func(authorization string) (int, ScopesResponse) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, env.service.URL+"/scopes", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		var body ScopesResponse
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	// Step 2: Get scopes without OIDC token
+	status, body := getScopes("")
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v", status, http.StatusOK)
+	}
+	if len(body.Scopes) != len(AllowedScopes) || strings.Join(body.Scopes["administration"], ",") != "read" {
+		t.Errorf("scopes = %v, want AllowedScopes", body.Scopes)
+	}
+	if body.BlacklistedScopes == nil || len(body.Profiles["release"]) != 1 || body.Policy != nil {
+		t.Errorf("body = %+v, want blacklist and profiles without policy", body)
+	}
+
+	// Step 3: Get scopes with OIDC token
+	oidcToken, err := env.oidc.Mint(map[string]interface{}{"repository": "owner/repo", "event_name": "pull_request"})
+	if err != nil {
+		t.Fatalf("failed to mint OIDC token: %v", err)
+	}
+	status, body = getScopes("Bearer " + oidcToken)
+	if status != http.StatusOK || body.Policy == nil {
+		t.Fatalf("status = %v, policy = %v, want %v with policy", status, body.Policy, http.StatusOK)
+	}
+	if body.Policy.Repository != "owner/repo" || body.Policy.Scopes["contents"]["write"].Policy != "event_policy" {
+		t.Errorf("policy = %+v, want event policy denying contents:write for owner/repo", body.Policy)
+	}
+
+	// Step 4: Verify invalid OIDC token
+	if status, _ := getScopes("Bearer invalid"); status != http.StatusUnauthorized {
+		t.Errorf("invalid OIDC token status = %v, want %v", status, http.StatusUnauthorized)
+	}
+}
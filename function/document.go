@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// gcsLocationPrefix is the prefix of store locations in Cloud Storage, e.g. gs://bucket/approvals.json.
+const gcsLocationPrefix = "gs://"
+
+// gcsUpdateAttempts is how many times an update of a Cloud Storage document is attempted
+// when other instances keep updating the document first.
+const gcsUpdateAttempts = 10
+
+// errDocumentUnchanged is returned by the modify function of jsonDocument.update to skip the write.
+var errDocumentUnchanged = errors.New("document unchanged")
+
+// errDocumentConflict is returned when another instance updated a Cloud Storage document first.
+var errDocumentConflict = errors.New("document was updated concurrently")
+
+// jsonDocument is the JSON document a store persists its state in.
+type jsonDocument interface {
+	// read decodes the document into v. A missing document leaves v unchanged.
+	read(ctx context.Context, v interface{}) error
+
+	// update decodes the document into the value returned by newValue, applies modify and writes the value back,
+	// unless modify returns an error; errDocumentUnchanged skips the write without failing the update.
+	// Updates are atomic: if another writer updated the document first, modify is applied again to a fresh value.
+	update(ctx context.Context, newValue func() interface{}, modify func(v interface{}) error) error
+}
+
+// newJSONDocument returns the document at location, named name in errors: a Cloud Storage object
+// (gs://bucket/object) shared by all instances, or a local file of a single instance.
+func newJSONDocument(location, name string) jsonDocument {
+	if strings.HasPrefix(location, gcsLocationPrefix) {
+		// An invalid location is reported by Validate, and fails every read and update
+		bucket, object, _ := parseGCSLocation(location)
+		return newGCSDocument(bucket, object, name)
+	}
+	return &fileDocument{path: location, name: name}
+}
+
+// parseGCSLocation parses a gs://bucket/object location.
+func parseGCSLocation(location string) (bucket, object string, err error) {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(location, gcsLocationPrefix), "/")
+	if !strings.HasPrefix(location, gcsLocationPrefix) || !ok || bucket == "" || object == "" {
+		return "", "", fmt.Errorf("must be gs://bucket/object")
+	}
+	return bucket, object, nil
+}
+
+// fileDocument is a JSON document in a local file. Updates are only atomic within the process,
+// so the file must not be shared by several instances.
+type fileDocument struct {
+	path string
+	name string
+	mu   sync.Mutex
+}
+
+// read implements jsonDocument.
+func (d *fileDocument) read(ctx context.Context, v interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return readJSONFile(d.path, d.name, v)
+}
+
+// update implements jsonDocument.
+func (d *fileDocument) update(ctx context.Context, newValue func() interface{}, modify func(v interface{}) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v := newValue()
+	if err := readJSONFile(d.path, d.name, v); err != nil {
+		return err
+	}
+	if err := modify(v); err != nil {
+		if errors.Is(err, errDocumentUnchanged) {
+			return nil
+		}
+		return err
+	}
+	return writeJSONFile(d.path, d.name, v)
+}
+
+// gcsDocument is a JSON document in a Cloud Storage object, shared by all instances.
+// Writes are conditioned on the generation of the object that was read, so that
+// an update never overwrites the update of another instance.
+type gcsDocument struct {
+	bucket  string
+	object  string
+	name    string
+	options []option.ClientOption
+
+	mu      sync.Mutex
+	service *storage.Service
+}
+
+// newGCSDocument creates the document of the object in bucket. The Cloud Storage client is created
+// on first use with options, using Application Default Credentials unless options override them.
+func newGCSDocument(bucket, object, name string, options ...option.ClientOption) *gcsDocument {
+	return &gcsDocument{bucket: bucket, object: object, name: name, options: options}
+}
+
+// read implements jsonDocument.
+func (d *gcsDocument) read(ctx context.Context, v interface{}) error {
+	_, err := d.load(ctx, v)
+	return err
+}
+
+// update implements jsonDocument.
+func (d *gcsDocument) update(ctx context.Context, newValue func() interface{}, modify func(v interface{}) error) error {
+	for attempt := 1; ; attempt++ {
+		v := newValue()
+		generation, err := d.load(ctx, v)
+		if err != nil {
+			return err
+		}
+		if err := modify(v); err != nil {
+			if errors.Is(err, errDocumentUnchanged) {
+				return nil
+			}
+			return err
+		}
+		err = d.store(ctx, v, generation)
+		if !errors.Is(err, errDocumentConflict) || attempt == gcsUpdateAttempts {
+			return err
+		}
+
+		// Back off randomly, so that instances updating together don't conflict again
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(rand.N(time.Duration(attempt) * 20 * time.Millisecond)):
+		}
+	}
+}
+
+// load decodes the object into v and returns its generation, or 0 if the object doesn't exist.
+func (d *gcsDocument) load(ctx context.Context, v interface{}) (int64, error) {
+	service, err := d.storage(ctx)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := service.Objects.Get(d.bucket, d.object).Context(ctx).Download()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", d.name, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	generation, err := strconv.ParseInt(resp.Header.Get("X-Goog-Generation"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: invalid object generation '%s'", d.name, resp.Header.Get("X-Goog-Generation"))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return 0, fmt.Errorf("failed to parse %s gs://%s/%s: %w", d.name, d.bucket, d.object, err)
+	}
+	return generation, nil
+}
+
+// store writes v to the object if it still has the generation (0: if it doesn't exist),
+// and returns errDocumentConflict otherwise.
+func (d *gcsDocument) store(ctx context.Context, v interface{}, generation int64) error {
+	service, err := d.storage(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	object := &storage.Object{Name: d.object, ContentType: "application/json", CacheControl: "no-store"}
+	_, err = service.Objects.Insert(d.bucket, object).Media(bytes.NewReader(data)).IfGenerationMatch(generation).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("failed to write %s: %w", d.name, errDocumentConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", d.name, err)
+	}
+	return nil
+}
+
+// storage returns the Cloud Storage client, creating it on first use.
+func (d *gcsDocument) storage(ctx context.Context) (*storage.Service, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.service == nil {
+		service, err := storage.NewService(context.WithoutCancel(ctx), d.options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Cloud Storage client for %s: %w", d.name, err)
+		}
+		d.service = service
+	}
+	return d.service, nil
+}
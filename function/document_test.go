@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+// fakeGCS is an in-memory stand-in for the Cloud Storage JSON API: media downloads of objects,
+// and multipart uploads with the ifGenerationMatch precondition.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string]fakeGCSObject
+	writes  int
+}
+
+// fakeGCSObject is an object stored by fakeGCS.
+type fakeGCSObject struct {
+	data       []byte
+	generation int64
+}
+
+// newFakeGCS starts a fake Cloud Storage server and returns it with the client options for it.
+func newFakeGCS(t *testing.T) (*fakeGCS, []option.ClientOption) {
+	t.Helper()
+	fake := &fakeGCS{objects: make(map[string]fakeGCSObject)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/o/{object...}", fake.handleDownload)
+	mux.HandleFunc("POST /upload/storage/v1/b/{bucket}/o", fake.handleUpload)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return fake, []option.ClientOption{option.WithEndpoint(server.URL + "/storage/v1/"), option.WithoutAuthentication()}
+}
+
+// handleDownload serves GET /storage/v1/b/{bucket}/o/{object}?alt=media.
+func (f *fakeGCS) handleDownload(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[r.PathValue("bucket")+"/"+r.PathValue("object")]
+	if !ok || r.URL.Query().Get("alt") != "media" {
+		writeFakeGCSError(w, http.StatusNotFound, "No such object")
+		return
+	}
+	w.Header().Set("X-Goog-Generation", strconv.FormatInt(object.generation, 10))
+	_, _ = w.Write(object.data)
+}
+
+// handleUpload serves multipart POST /upload/storage/v1/b/{bucket}/o uploads.
+func (f *fakeGCS) handleUpload(w http.ResponseWriter, r *http.Request) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" {
+		writeFakeGCSError(w, http.StatusBadRequest, "multipart upload expected")
+		return
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	var metadata struct {
+		Name string `json:"name"`
+	}
+	part, err := reader.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&metadata)
+	}
+	var data []byte
+	if err == nil {
+		if part, err = reader.NextPart(); err == nil {
+			data, err = io.ReadAll(part)
+		}
+	}
+	if err != nil {
+		writeFakeGCSError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := r.PathValue("bucket") + "/" + metadata.Name
+	current := f.objects[key]
+	if match := r.URL.Query().Get("ifGenerationMatch"); match != "" && match != strconv.FormatInt(current.generation, 10) {
+		writeFakeGCSError(w, http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold.")
+		return
+	}
+	f.objects[key] = fakeGCSObject{data: data, generation: current.generation + 1}
+	f.writes++
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"bucket":     r.PathValue("bucket"),
+		"name":       metadata.Name,
+		"generation": strconv.FormatInt(current.generation+1, 10),
+	})
+}
+
+// writeFakeGCSError writes a Cloud Storage JSON API error.
+func writeFakeGCSError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": status, "message": message}})
+}
+
+// TestJSONDocuments tests reads and updates of the file and Cloud Storage documents.
+//
+// Test steps:
+//  1. Create the document of the test case
+//  2. Verify reading the missing document leaves the value unchanged
+//  3. Update the document and verify the update is read back
+//  4. Verify failed and unchanged updates are not written
+func TestJSONDocuments(t *testing.T) {
+	tests := []struct {
+		name        string
+		newDocument func(t *testing.T) jsonDocument
+	}{
+		{
+			name: "file",
+			newDocument: func(t *testing.T) jsonDocument {
+				return newJSONDocument(filepath.Join(t.TempDir(), "document.json"), "test document")
+			},
+		},
+		{
+			name: "cloud storage",
+			newDocument: func(t *testing.T) jsonDocument {
+				_, options := newFakeGCS(t)
+				return newGCSDocument("bucket", "document.json", "test document", options...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// Step 1: Create document
+			document := tt.newDocument(t)
+			newValue := func() interface{} { return &map[string]int{} }
+
+			// Step 2: Read missing document
+			values := map[string]int{"unchanged": 1}
+			if err := document.read(ctx, &values); err != nil || values["unchanged"] != 1 {
+				t.Fatalf("read() = %v, %v, want unchanged value", values, err)
+			}
+
+			// Step 3: Update and read back
+			increment := func(v interface{}) error {
+				(*v.(*map[string]int))["count"]++
+				return nil
+			}
+			for range 2 {
+				if err := document.update(ctx, newValue, increment); err != nil {
+					t.Fatalf("update() error = %v", err)
+				}
+			}
+			values = map[string]int{}
+			if err := document.read(ctx, &values); err != nil || values["count"] != 2 {
+				t.Fatalf("read() = %v, %v, want count 2", values, err)
+			}
+
+			// Step 4: Failed and unchanged updates
+			errFailed := errors.New("failed")
+			failing := func(v interface{}) error {
+				(*v.(*map[string]int))["count"] = 0
+				return errFailed
+			}
+			if err := document.update(ctx, newValue, failing); !errors.Is(err, errFailed) {
+				t.Errorf("update() error = %v, want %v", err, errFailed)
+			}
+			unchanged := func(v interface{}) error {
+				(*v.(*map[string]int))["count"] = 0
+				return errDocumentUnchanged
+			}
+			if err := document.update(ctx, newValue, unchanged); err != nil {
+				t.Errorf("update() error = %v, want nil for unchanged document", err)
+			}
+			values = map[string]int{}
+			if err := document.read(ctx, &values); err != nil || values["count"] != 2 {
+				t.Errorf("read() = %v, %v, want count 2 after failed updates", values, err)
+			}
+		})
+	}
+}
+
+// TestGCSDocument_ConcurrentInstances tests that concurrent updates of several instances sharing
+// a Cloud Storage document are all applied, none overwriting another.
+//
+// Test steps:
+//  1. Create documents of the same object, one per instance
+//  2. Increment a counter concurrently from all instances
+//  3. Verify every increment is counted
+func TestGCSDocument_ConcurrentInstances(t *testing.T) {
+	ctx := context.Background()
+	const instances, increments = 4, 5
+
+	// Step 1: Create documents
+	fake, options := newFakeGCS(t)
+	documents := make([]jsonDocument, instances)
+	for i := range documents {
+		documents[i] = newGCSDocument("bucket", "counter.json", "test document", options...)
+	}
+
+	// Step 2: Increment concurrently
+	var wg sync.WaitGroup
+	errs := make(chan error, instances*increments)
+	for _, document := range documents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				errs <- document.update(ctx, func() interface{} { return new(int) }, func(v interface{}) error {
+					*v.(*int)++
+					return nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("update() error = %v", err)
+		}
+	}
+
+	// Step 3: Verify count
+	var count int
+	if err := documents[0].read(ctx, &count); err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	if count != instances*increments || fake.writes != instances*increments {
+		t.Errorf("count = %d, writes = %d, want %d", count, fake.writes, instances*increments)
+	}
+}
+
+// TestParseGCSLocation tests parsing of gs://bucket/object store locations.
+//
+// Test steps:
+//  1. Parse the location of the test case
+//  2. Verify the bucket and object, or the error
+func TestParseGCSLocation(t *testing.T) {
+	tests := []struct {
+		location   string
+		wantBucket string
+		wantObject string
+		wantErr    bool
+	}{
+		{location: "gs://bucket/approvals.json", wantBucket: "bucket", wantObject: "approvals.json"},
+		{location: "gs://bucket/stores/approvals.json", wantBucket: "bucket", wantObject: "stores/approvals.json"},
+		{location: "gs://bucket", wantErr: true},
+		{location: "gs://bucket/", wantErr: true},
+		{location: "gs:///approvals.json", wantErr: true},
+		{location: "/data/approvals.json", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			// Step 1: Parse
+			bucket, object, err := parseGCSLocation(tt.location)
+
+			// Step 2: Verify
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGCSLocation() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+			if bucket != tt.wantBucket || object != tt.wantObject {
+				t.Errorf("parseGCSLocation() = %q, %q, want %q, %q", bucket, object, tt.wantBucket, tt.wantObject)
+			}
+		})
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// e2eInstallationID is the installation ID of the fake GitHub App installation in end-to-end tests.
+const e2eInstallationID = 42
+
+// e2eEnvironment is the service running against a fake GitHub API over real HTTP.
+type e2eEnvironment struct {
+	github    *FakeGitHub
+	githubURL string
+	oidc      *FakeOIDCIssuer
+	server    *Server
+	service   *httptest.Server
+}
+
+// newE2EEnvironment starts the fake GitHub API and the service configured to use it.
+// The App is installed on all repositories of "owner" with contents:write and issues:read.
+func newE2EEnvironment(t *testing.T) *e2eEnvironment {
+	t.Helper()
+	return newE2EEnvironmentWithConfig(t, nil)
+}
+
+// newE2EEnvironmentWithConfig starts the environment with the service configuration modified by configure.
+func newE2EEnvironmentWithConfig(t *testing.T, configure func(config *Config)) *e2eEnvironment {
+	t.Helper()
+	appKey := generateTestRSAKey(t)
+	return newE2EEnvironmentWithKeys(t, []*rsa.PrivateKey{appKey}, &appKey.PublicKey, configure)
+}
+
+// newE2EEnvironmentWithKeys starts the environment with the candidate keys of the service
+// (preferred first), the App public key registered on GitHub, and the configuration modified by configure (if not nil).
+func newE2EEnvironmentWithKeys(t *testing.T, serviceKeys []*rsa.PrivateKey, githubKey *rsa.PublicKey, configure func(config *Config)) *e2eEnvironment {
+	t.Helper()
+
+	fakeGitHub := NewFakeGitHub(1)
+	fakeGitHub.AppPublicKey = githubKey
+	fakeGitHub.AddInstallation(&FakeInstallation{
+		ID:          e2eInstallationID,
+		Account:     "owner",
+		Permissions: map[string]string{"contents": "write", "issues": "read"},
+	})
+	githubServer := httptest.NewServer(fakeGitHub)
+	t.Cleanup(githubServer.Close)
+
+	config := testConfig()
+	for _, key := range serviceKeys {
+		config.PrivateKey += string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	}
+	config.GitHubAPIURL = githubServer.URL
+	config.ReadyzCheckGitHub = true
+	if configure != nil {
+		configure(config)
+	}
+	server := NewServer(config)
+	service := httptest.NewServer(server.Routes())
+	t.Cleanup(service.Close)
+
+	return &e2eEnvironment{
+		github:    fakeGitHub,
+		githubURL: githubServer.URL,
+		oidc:      NewFakeOIDCIssuer("https://token.actions.githubusercontent.com", generateTestRSAKey(t)),
+		server:    server,
+		service:   service,
+	}
+}
+
+// requestToken requests a token from the service with an OIDC token for repository.
+// It returns the response status and the decoded response body.
+func (e *e2eEnvironment) requestToken(t *testing.T, repository string, query string) (int, map[string]interface{}) {
+	t.Helper()
+	return e.requestTokenWithClaims(t, map[string]interface{}{"repository": repository}, query)
+}
+
+// requestTokenWithClaims requests a token from the service with an OIDC token with the claims.
+// It returns the response status and the decoded response body.
+func (e *e2eEnvironment) requestTokenWithClaims(t *testing.T, claims map[string]interface{}, query string) (int, map[string]interface{}) {
+	t.Helper()
+	oidcToken, err := e.oidc.Mint(claims)
+	if err != nil {
+		t.Fatalf("failed to mint OIDC token: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.service.URL+"/token?"+query, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+oidcToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp.StatusCode, body
+}
+
+// TestE2E_TokenIssued tests issuing a token through the service and the GitHub REST client.
+//
+// Test steps:
+//  1. Start the fake GitHub API validating the App JWT, and the service
+//  2. Request a token for an installed repository
+//  3. Verify response status is 200 OK
+//  4. Verify token, expiration and scopes are decoded from the GitHub response
+func TestE2E_TokenIssued(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironment(t)
+
+	// Step 2: Request token
+	status, body := env.requestToken(t, "owner/repo", "contents=write&issues=read")
+
+	// Step 3: Verify status
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+
+	// Step 4: Verify response
+	token, _ := body["token"].(string)
+	if !strings.HasPrefix(token, "ghs_") {
+		t.Errorf("token = %v, want ghs_ prefix", body["token"])
+	}
+	expiresAt, err := time.Parse(time.RFC3339, body["expires_at"].(string))
+	if err != nil || time.Until(expiresAt) <= 0 {
+		t.Errorf("expires_at = %v, want future RFC3339 time", body["expires_at"])
+	}
+	scopes, _ := body["scopes"].(map[string]interface{})
+	if scopes["contents"] != "write" || scopes["issues"] != "read" {
+		t.Errorf("scopes = %v, want contents:write and issues:read", body["scopes"])
+	}
+}
+
+// TestE2E_TokenPinnedToRepository tests that issued tokens only work on the calling repository,
+// even when the App is installed on every repository of the account.
+//
+// Test steps:
+//  1. Start the environment with environments in two repositories of the installation
+//  2. Request a token for one repository
+//  3. Verify the token reads the environment of its repository, but not of the other
+//  4. Verify a repository ID outside the installation is rejected
+func TestE2E_TokenPinnedToRepository(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironment(t)
+	env.github.AddEnvironment("owner/repo", "production", &FakeEnvironment{})
+	env.github.AddEnvironment("owner/other", "production", &FakeEnvironment{})
+
+	// Step 2: Request token
+	status, body := env.requestToken(t, "owner/repo", "contents=read")
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	token, _ := body["token"].(string)
+
+	// Step 3: Verify repository access
+	for repository, wantStatus := range map[string]int{"owner/repo": http.StatusOK, "owner/other": http.StatusNotFound} {
+		req, err := http.NewRequest(http.MethodGet, env.githubURL+"/repos/"+repository+"/environments/production", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Errorf("GET environment of %s status = %v, want %v", repository, resp.StatusCode, wantStatus)
+		}
+	}
+
+	// Step 4: Verify repository ID outside the installation is rejected
+	claims := map[string]interface{}{"repository": "owner/repo", "repository_id": strconv.FormatInt(FakeRepositoryID("other/repo"), 10)}
+	if status, body := env.requestTokenWithClaims(t, claims, "contents=read"); status != http.StatusForbidden {
+		t.Errorf("foreign repository ID status = %v, want %v (body: %v)", status, http.StatusForbidden, body)
+	}
+}
+
+// TestE2E_RepositoryIdentity tests that tokens are only issued when GitHub resolves the repository
+// to the repository and owner IDs of the OIDC token, e.g., not for a renamed or transferred repository.
+//
+// Test steps:
+//  1. Start the environment and request a token for owner/repo
+//  2. Request tokens with names and IDs GitHub resolves differently
+//  3. Verify the requests are rejected with 403
+func TestE2E_RepositoryIdentity(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironment(t)
+	if status, body := env.requestToken(t, "owner/repo", "contents=read"); status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	repositoryID := strconv.FormatInt(FakeRepositoryID("owner/repo"), 10)
+
+	tests := []struct {
+		name        string
+		claims      map[string]interface{}
+		errContains string
+	}{
+		{
+			name:        "owner ID of other account",
+			claims:      map[string]interface{}{"repository": "owner/repo", "repository_owner_id": strconv.FormatInt(FakeOwnerID("other"), 10)},
+			errContains: "owner/repo is owned by account ID",
+		},
+		{
+			name:        "repository renamed",
+			claims:      map[string]interface{}{"repository": "owner/old-name", "repository_id": repositoryID},
+			errContains: "is owner/repo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Request token
+			status, body := env.requestTokenWithClaims(t, tt.claims, "contents=read")
+
+			// Step 3: Verify rejection
+			if status != http.StatusForbidden {
+				t.Errorf("status = %v, want %v (body: %v)", status, http.StatusForbidden, body)
+			}
+			if errMsg, _ := body["error"].(string); !strings.Contains(errMsg, tt.errContains) {
+				t.Errorf("error = %v, want containing %q", body["error"], tt.errContains)
+			}
+		})
+	}
+}
+
+// TestE2E_GitHubErrors tests mapping of GitHub API responses to service responses.
+//
+// Test steps:
+//  1. Start the fake GitHub API and the service
+//  2. Script the GitHub failure for the test case
+//  3. Request a token
+//  4. Verify response status and error message
+func TestE2E_GitHubErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		repository  string
+		query       string
+		endpoint    string
+		failure     FakeGitHubFailure
+		wantStatus  int
+		errContains string
+	}{
+		{
+			name:        "repository not installed",
+			repository:  "other/repo",
+			query:       "contents=read",
+			wantStatus:  http.StatusForbidden,
+			errContains: "not installed",
+		},
+		{
+			name:        "installation lookup 404",
+			query:       "contents=read",
+			endpoint:    FakeGitHubFindRepositoryInstallation,
+			failure:     FakeGitHubFailure{StatusCode: http.StatusNotFound},
+			wantStatus:  http.StatusForbidden,
+			errContains: "not installed",
+		},
+		{
+			name:        "installation lookup 5xx",
+			query:       "contents=read",
+			endpoint:    FakeGitHubFindRepositoryInstallation,
+			failure:     FakeGitHubFailure{StatusCode: http.StatusBadGateway},
+			wantStatus:  http.StatusServiceUnavailable,
+			errContains: "GitHub API error",
+		},
+		{
+			name:        "installation lookup rate limit",
+			query:       "contents=read",
+			endpoint:    FakeGitHubFindRepositoryInstallation,
+			failure:     FakeGitHubFailure{RateLimited: true},
+			wantStatus:  http.StatusServiceUnavailable,
+			errContains: "rate limit exceeded",
+		},
+		{
+			name:        "access token 403",
+			query:       "contents=read",
+			endpoint:    FakeGitHubCreateInstallationToken,
+			failure:     FakeGitHubFailure{StatusCode: http.StatusForbidden},
+			wantStatus:  http.StatusForbidden,
+			errContains: "insufficient permissions",
+		},
+		{
+			name:        "access token 422",
+			query:       "contents=read",
+			endpoint:    FakeGitHubCreateInstallationToken,
+			failure:     FakeGitHubFailure{StatusCode: http.StatusUnprocessableEntity},
+			wantStatus:  http.StatusForbidden,
+			errContains: "suspended",
+		},
+		{
+			name:        "access token 5xx",
+			query:       "contents=read",
+			endpoint:    FakeGitHubCreateInstallationToken,
+			failure:     FakeGitHubFailure{StatusCode: http.StatusInternalServerError},
+			wantStatus:  http.StatusServiceUnavailable,
+			errContains: "GitHub API error",
+		},
+		{
+			name:        "access token rate limit",
+			query:       "contents=read",
+			endpoint:    FakeGitHubCreateInstallationToken,
+			failure:     FakeGitHubFailure{RateLimited: true},
+			wantStatus:  http.StatusServiceUnavailable,
+			errContains: "rate limit exceeded",
+		},
+		{
+			name:        "access token secondary rate limit",
+			query:       "contents=read",
+			endpoint:    FakeGitHubCreateInstallationToken,
+			failure:     FakeGitHubFailure{RetryAfter: time.Minute},
+			wantStatus:  http.StatusServiceUnavailable,
+			errContains: "rate limit exceeded",
+		},
+		{
+			name:        "permission not granted to installation",
+			query:       "actions=read",
+			wantStatus:  http.StatusForbidden,
+			errContains: "insufficient permissions",
+		},
+		{
+			name:        "permission downgraded by installation",
+			query:       "issues=write",
+			wantStatus:  http.StatusForbidden,
+			errContains: "fewer scopes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Start environment
+			env := newE2EEnvironment(t)
+
+			// Step 2: Script failure
+			if tt.endpoint != "" {
+				env.github.FailNext(tt.endpoint, tt.failure)
+			}
+
+			// Step 3: Request token
+			repository := tt.repository
+			if repository == "" {
+				repository = "owner/repo"
+			}
+			status, body := env.requestToken(t, repository, tt.query)
+
+			// Step 4: Verify response
+			if status != tt.wantStatus {
+				t.Errorf("status = %v, want %v (body: %v)", status, tt.wantStatus, body)
+			}
+			if errMessage, _ := body["error"].(string); !strings.Contains(errMessage, tt.errContains) {
+				t.Errorf("error = %v, want containing %q", body["error"], tt.errContains)
+			}
+		})
+	}
+}
+
+// TestE2E_InvalidAppKey tests that GitHub rejects App JWTs signed with another key.
+//
+// Test steps:
+//  1. Start the fake GitHub API and the service
+//  2. Replace the App public key known to GitHub
+//  3. Request a token
+//  4. Verify the request fails with the GitHub authentication error
+func TestE2E_InvalidAppKey(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironment(t)
+
+	// Step 2: Replace public key
+	env.github.AppPublicKey = &generateTestRSAKey(t).PublicKey
+
+	// Step 3: Request token
+	status, body := env.requestToken(t, "owner/repo", "contents=read")
+
+	// Step 4: Verify error
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("status = %v, want %v (body: %v)", status, http.StatusServiceUnavailable, body)
+	}
+	if errMessage, _ := body["error"].(string); !strings.Contains(errMessage, "401") {
+		t.Errorf("error = %v, want containing GitHub 401 response", body["error"])
+	}
+}
+
+// TestE2E_KeyRotationFallback tests falling back to an older App key when GitHub rejects the newest key.
+// During rotation, the new key may be uploaded before it is registered on GitHub (or the old key
+// may be removed from GitHub first); tokens must be issued in both cases.
+//
+// Test steps:
+//  1. Start the service with a new and an old key, and GitHub knowing only the test case key
+//  2. Request a token
+//  3. Verify the token is issued
+//  4. Verify the key GitHub accepted is active, and readiness doesn't expose the keys
+func TestE2E_KeyRotationFallback(t *testing.T) {
+	newKey := generateTestRSAKey(t)
+	oldKey := generateTestRSAKey(t)
+
+	tests := []struct {
+		name          string
+		githubKey     *rsa.PublicKey
+		wantActiveKey string
+	}{
+		{
+			name:          "GitHub knows the new key",
+			githubKey:     &newKey.PublicKey,
+			wantActiveKey: "inline/1",
+		},
+		{
+			name:          "GitHub knows only the old key",
+			githubKey:     &oldKey.PublicKey,
+			wantActiveKey: "inline/2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Start environment
+			env := newE2EEnvironmentWithKeys(t, []*rsa.PrivateKey{newKey, oldKey}, tt.githubKey, nil)
+
+			// Step 2 & 3: Request token
+			status, body := env.requestToken(t, "owner/repo", "contents=read")
+			if status != http.StatusOK {
+				t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+			}
+
+			// Step 4: Verify active key
+			resp, err := http.Get(env.service.URL + "/readyz")
+			if err != nil {
+				t.Fatalf("readiness request failed: %v", err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+			var health HealthResponse
+			if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+				t.Fatalf("failed to decode readiness response: %v", err)
+			}
+			if health.Status != "ready" || len(health.Checks) != 4 {
+				t.Errorf("readiness = %+v, want ready with 4 checks", health)
+			}
+			if activeKey := env.server.activeKey.Load(); activeKey == nil || activeKey.ID != tt.wantActiveKey {
+				t.Errorf("active key = %+v, want %s", activeKey, tt.wantActiveKey)
+			}
+		})
+	}
+}
+
+// TestE2E_KeyCache tests that loaded App keys are reused, and reloaded once GitHub rejects them.
+//
+// Test steps:
+//  1. Start the service with an old key while GitHub knows a new key, and request a token
+//  2. Rotate the configured key and verify the next request picks it up right away
+//  3. Replace the configured key and verify the cached key is still used
+func TestE2E_KeyCache(t *testing.T) {
+	newKey := generateTestRSAKey(t)
+	newKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(newKey)}))
+
+	// Step 1: Request with the old key
+	env := newE2EEnvironmentWithKeys(t, []*rsa.PrivateKey{generateTestRSAKey(t)}, &newKey.PublicKey, nil)
+	if status, body := env.requestToken(t, "owner/repo", "contents=read"); status != http.StatusServiceUnavailable {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusServiceUnavailable, body)
+	}
+
+	// Step 2: Rotate the key
+	env.server.config.PrivateKey = newKeyPEM
+	if status, body := env.requestToken(t, "owner/repo", "contents=read"); status != http.StatusOK {
+		t.Fatalf("status after rotation = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+
+	// Step 3: Replace the configured key
+	env.server.config.PrivateKey = "invalid"
+	if status, body := env.requestToken(t, "owner/repo", "contents=read"); status != http.StatusOK {
+		t.Errorf("status with cached key = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+}
+
+// TestE2E_PullRequestPolicy tests the default event policies for pull request workflows.
+//
+// Test steps:
+//  1. Start the fake GitHub API and the service with the default configuration
+//  2. Request write and read tokens from pull_request and pull_request_target workflows
+//  3. Verify write scopes are denied for pull_request with the decision in the error details
+//  4. Verify read scopes and pull_request_target workflows are allowed
+func TestE2E_PullRequestPolicy(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironment(t)
+	pullRequest := map[string]interface{}{
+		"repository": "owner/repo",
+		"event_name": "pull_request",
+		"head_ref":   "feature",
+		"base_ref":   "main",
+		"ref":        "refs/pull/1/merge",
+	}
+
+	// Step 2: pull_request with write scope
+	status, body := env.requestTokenWithClaims(t, pullRequest, "contents=write&issues=read")
+
+	// Step 3: Verify denied
+	if status != http.StatusForbidden {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusForbidden, body)
+	}
+	if errMsg, _ := body["error"].(string); !strings.Contains(errMsg, "write scopes (contents) are not allowed for pull_request workflows") {
+		t.Errorf("error = %v, want write scopes denial", body["error"])
+	}
+	details, _ := body["details"].(map[string]interface{})
+	if details["policy"] != "deny" || details["event_name"] != "pull_request" || details["head_ref"] != "feature" || details["base_ref"] != "main" {
+		t.Errorf("details = %v, want deny decision with event and refs", body["details"])
+	}
+
+	// Step 4: Verify allowed
+	if status, body := env.requestTokenWithClaims(t, pullRequest, "contents=read"); status != http.StatusOK {
+		t.Errorf("read scopes status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	pullRequest["event_name"] = "pull_request_target"
+	if status, body := env.requestTokenWithClaims(t, pullRequest, "contents=write"); status != http.StatusOK {
+		t.Errorf("pull_request_target status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+}
+
+// TestE2E_PullRequestPolicyDowngrade tests that downgraded write scopes are reported in the token response.
+//
+// Test steps:
+//  1. Start the service with the downgrade pull request policy
+//  2. Request write scopes from a pull_request workflow
+//  3. Verify the scopes are issued as read and reported with the downgrading policy
+func TestE2E_PullRequestPolicyDowngrade(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironmentWithConfig(t, func(config *Config) { config.PullRequestPolicy = EventPolicyDowngrade })
+
+	// Step 2: Request write scopes
+	status, body := env.requestTokenWithClaims(t, map[string]interface{}{
+		"repository": "owner/repo",
+		"event_name": "pull_request",
+		"head_ref":   "feature",
+	}, "contents=write&issues=read")
+
+	// Step 3: Verify downgrade
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	if scopes, _ := body["scopes"].(map[string]interface{}); scopes["contents"] != "read" {
+		t.Errorf("scopes = %v, want contents:read", body["scopes"])
+	}
+	want := []interface{}{map[string]interface{}{
+		"scope":      "contents",
+		"requested":  "write",
+		"granted":    "read",
+		"policy":     "pull_request_policy",
+		"event_name": "pull_request",
+	}}
+	if !reflect.DeepEqual(body["downgraded_scopes"], want) {
+		t.Errorf("downgraded_scopes = %v, want %v", body["downgraded_scopes"], want)
+	}
+}
+
+// TestE2E_ProtectedEnvironment tests privileged scopes with the live environment protection check.
+//
+// Test steps:
+//  1. Start the fake GitHub API with environments with and without required reviewers, and the service
+//  2. Request privileged scopes from jobs in each environment and without an environment
+//  3. Verify only the environment with required reviewers gets the token
+//  4. Verify the environment check token is revoked
+func TestE2E_ProtectedEnvironment(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironmentWithConfig(t, func(config *Config) { config.VerifyEnvironmentProtection = true })
+	env.github.AddInstallation(&FakeInstallation{
+		ID:          43,
+		Account:     "platform",
+		Permissions: map[string]string{"secrets": "write", "actions": "read"},
+	})
+	env.github.AddEnvironment("platform/infra", "production", &FakeEnvironment{ProtectionRules: []string{"wait_timer", "required_reviewers"}})
+	env.github.AddEnvironment("platform/infra", "staging", &FakeEnvironment{ProtectionRules: []string{"wait_timer"}})
+
+	tests := []struct {
+		name        string
+		environment string
+		wantStatus  int
+		errContains string
+	}{
+		{name: "required reviewers", environment: "production", wantStatus: http.StatusOK},
+		{name: "no required reviewers", environment: "staging", wantStatus: http.StatusForbidden, errContains: "environment 'staging' has no required reviewers"},
+		{name: "unknown environment", environment: "qa", wantStatus: http.StatusForbidden, errContains: "environment 'qa' not found"},
+		{name: "no environment", wantStatus: http.StatusForbidden, errContains: "require a GitHub Actions job running in a protected environment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Request privileged scope
+			claims := map[string]interface{}{"repository": "platform/infra"}
+			if tt.environment != "" {
+				claims["environment"] = tt.environment
+			}
+			status, body := env.requestTokenWithClaims(t, claims, "secrets=write")
+
+			// Step 3: Verify response
+			if status != tt.wantStatus {
+				t.Fatalf("status = %v, want %v (body: %v)", status, tt.wantStatus, body)
+			}
+			if errMsg, _ := body["error"].(string); !strings.Contains(errMsg, tt.errContains) {
+				t.Errorf("error = %v, want containing %q", body["error"], tt.errContains)
+			}
+		})
+	}
+
+	// Step 4: Verify only the issued privileged token is left
+	env.github.mu.Lock()
+	defer env.github.mu.Unlock()
+	if len(env.github.tokens) != 1 {
+		t.Errorf("active tokens = %d, want 1 (check tokens revoked)", len(env.github.tokens))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// DefaultPrivilegedScopes are the scopes only issued to jobs running in a protected deployment environment.
+var DefaultPrivilegedScopes = []string{"secrets:write", "workflows:write", "administration:read"}
+
+// permissionRank orders permission levels, so that a privileged read scope also covers write.
+var permissionRank = map[string]int{"read": 1, "write": 2}
+
+// requiredReviewersRule is the protection rule type of environments with required reviewers.
+const requiredReviewersRule = "required_reviewers"
+
+// ApplyEnvironmentPolicy checks that privileged scopes are requested by a GitHub Actions job running
+// in a deployment environment (the "environment" claim), which must be one of ProtectedEnvironments if configured.
+// Returns the environment when privileged scopes are requested, or a *PolicyError.
+func ApplyEnvironmentPolicy(config *Config, claims *OIDCClaims, scopes map[string]string) (string, error) {
+	requested := restrictedScopes(config.PrivilegedScopes, scopes)
+	if len(requested) == 0 {
+		return "", nil
+	}
+
+	environment := claims.Claim("environment")
+	details := map[string]interface{}{
+		"privileged_scopes":    requested,
+		"environment":          environment,
+		"allowed_environments": config.ProtectedEnvironments,
+	}
+
+	if claims.Provider != "github-actions" || environment == "" {
+		return "", &PolicyError{
+			Message: fmt.Sprintf("privileged scopes (%s) require a GitHub Actions job running in a protected environment", strings.Join(requested, ", ")),
+			Details: details,
+		}
+	}
+
+	if len(config.ProtectedEnvironments) > 0 {
+		allowed := false
+		for _, name := range config.ProtectedEnvironments {
+			if strings.EqualFold(name, environment) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", &PolicyError{
+				Message: fmt.Sprintf("privileged scopes (%s) are not allowed in environment '%s'", strings.Join(requested, ", "), environment),
+				Details: details,
+			}
+		}
+	}
+
+	return environment, nil
+}
+
+// verifyEnvironmentProtection checks that the deployment environment of the repository has required reviewers.
+// The environment is read with a short-lived installation token limited to actions:read on the repository,
+// revoked afterwards. Returns a *PolicyError if the environment doesn't exist or has no required reviewers.
+func (s *Server) verifyEnvironmentProtection(ctx context.Context, appClient *github.Client, installationID int64, tokenRepository TokenRepository, environment string) error {
+	repository := tokenRepository.Name
+	token, err := CreateInstallationToken(ctx, appClient.Apps, s.config.GitHubAPIURL, installationID, tokenRepository, map[string]string{"actions": "read"}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create environment check token: %w", err)
+	}
+	client, err := NewGitHubClientWithJWTForURL(token.GetToken(), s.config.GitHubAPIURL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = client.Apps.RevokeInstallationToken(context.WithoutCancel(ctx))
+	}()
+
+	owner, repo, _ := strings.Cut(repository, "/")
+	env, resp, err := client.Repositories.GetEnvironment(ctx, owner, repo, environment)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return &PolicyError{
+			Message: fmt.Sprintf("environment '%s' not found in repository %s", environment, repository),
+			Details: map[string]interface{}{"environment": environment},
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get environment '%s': %w", environment, err)
+	}
+
+	for _, rule := range env.ProtectionRules {
+		if rule.GetType() == requiredReviewersRule {
+			return nil
+		}
+	}
+	return &PolicyError{
+		Message: fmt.Sprintf("environment '%s' has no required reviewers", environment),
+		Details: map[string]interface{}{"environment": environment},
+	}
+}
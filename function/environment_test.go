@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestApplyEnvironmentPolicy tests the protected environment conditions of privileged scopes.
+//
+// Test steps:
+//  1. Configure the privileged scopes and allowed environments of the test case
+//  2. Apply the policy to the requested scopes and claims
+//  3. Verify the returned environment or the denial
+func TestApplyEnvironmentPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		environments []string
+		provider     string
+		scopes       map[string]string
+		claims       map[string]interface{}
+		want         string
+		wantErr      bool
+		errContains  string
+	}{
+		{
+			name:   "unprivileged scopes",
+			scopes: map[string]string{"contents": "write", "secrets": "read"},
+			claims: map[string]interface{}{},
+		},
+		{
+			name:        "privileged scope without environment",
+			scopes:      map[string]string{"contents": "write", "secrets": "write"},
+			claims:      map[string]interface{}{},
+			wantErr:     true,
+			errContains: "privileged scopes (secrets:write) require a GitHub Actions job running in a protected environment",
+		},
+		{
+			name:   "privileged scope in environment",
+			scopes: map[string]string{"workflows": "write", "administration": "read"},
+			claims: map[string]interface{}{"environment": "production"},
+			want:   "production",
+		},
+		{
+			name:         "privileged scope in allowed environment",
+			environments: []string{"Production"},
+			scopes:       map[string]string{"administration": "read"},
+			claims:       map[string]interface{}{"environment": "production"},
+			want:         "production",
+		},
+		{
+			name:         "privileged scope in other environment",
+			environments: []string{"production"},
+			scopes:       map[string]string{"administration": "read", "workflows": "write"},
+			claims:       map[string]interface{}{"environment": "staging"},
+			wantErr:      true,
+			errContains:  "privileged scopes (administration:read, workflows:write) are not allowed in environment 'staging'",
+		},
+		{
+			name:        "other CI provider",
+			provider:    "gitlab",
+			scopes:      map[string]string{"secrets": "write"},
+			claims:      map[string]interface{}{"environment": "production"},
+			wantErr:     true,
+			errContains: "require a GitHub Actions job",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Configure policy
+			config := testConfig()
+			config.ProtectedEnvironments = tt.environments
+			provider := tt.provider
+			if provider == "" {
+				provider = "github-actions"
+			}
+
+			// Step 2: Apply policy
+			got, err := ApplyEnvironmentPolicy(config, &OIDCClaims{Repository: "owner/repo", Provider: provider, Claims: tt.claims}, tt.scopes)
+
+			// Step 3: Verify
+			if tt.wantErr {
+				var policyErr *PolicyError
+				if !errors.As(err, &policyErr) || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("ApplyEnvironmentPolicy() error = %v, want *PolicyError containing %q", err, tt.errContains)
+				}
+				if policyErr.Details["privileged_scopes"] == nil {
+					t.Errorf("Details = %v, want privileged_scopes", policyErr.Details)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyEnvironmentPolicy() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ApplyEnvironmentPolicy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
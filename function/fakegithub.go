@@ -0,0 +1,528 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// FakeGitHub endpoints, usable with FailNext.
+const (
+	FakeGitHubGetApp                     = "GET /app"
+	FakeGitHubFindRepositoryInstallation = "GET /repos/{owner}/{repo}/installation"
+	FakeGitHubDeleteInstallation         = "DELETE /app/installations/{id}"
+	FakeGitHubCreateInstallationToken    = "POST /app/installations/{id}/access_tokens"
+	FakeGitHubRevokeInstallationToken    = "DELETE /installation/token"
+	FakeGitHubGetEnvironment             = "GET /repos/{owner}/{repo}/environments/{name}"
+)
+
+// fakeGitHubMaxJWTLifetime is the maximum App JWT lifetime accepted by GitHub.
+const fakeGitHubMaxJWTLifetime = 10 * time.Minute
+
+// fakeGitHubClockSkew is the clock drift tolerated when validating App JWT timestamps.
+const fakeGitHubClockSkew = time.Minute
+
+// FakeRepositoryID returns the ID of the "owner/repo" repository in FakeGitHub and FakeOIDCIssuer tokens.
+func FakeRepositoryID(repository string) int64 {
+	return fakeID(repository)
+}
+
+// FakeOwnerID returns the ID of the owner account in FakeGitHub and FakeOIDCIssuer tokens.
+func FakeOwnerID(owner string) int64 {
+	return fakeID(owner)
+}
+
+// fakeID derives a stable positive ID from a case-insensitive name.
+func fakeID(name string) int64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(strings.ToLower(name)))
+	return int64(hash.Sum64()>>34) + 1
+}
+
+// FakeInstallation is a GitHub App installation served by FakeGitHub.
+type FakeInstallation struct {
+	ID int64
+
+	// Account is the owner login the App is installed on.
+	// An empty account matches every owner.
+	Account string
+
+	// Repositories lists the "owner/repo" names the installation has access to.
+	// An empty list grants access to every repository of Account.
+	Repositories []string
+
+	// Permissions are the installation permissions keyed by GitHub API permission name
+	// (e.g., "contents", "repository_projects") with "read" or "write" values.
+	Permissions map[string]string
+
+	Suspended bool
+}
+
+// FakeEnvironment is a deployment environment of a repository served by FakeGitHub.
+type FakeEnvironment struct {
+	// ProtectionRules are the protection rule types, e.g. "required_reviewers" or "wait_timer".
+	ProtectionRules []string
+}
+
+// FakeGitHubFailure is a scripted error response of FakeGitHub.
+type FakeGitHubFailure struct {
+	// StatusCode is the HTTP status of the response (e.g., 403, 404, 422, 502).
+	StatusCode int
+
+	// Message is the GitHub error message. Defaults to the HTTP status text.
+	Message string
+
+	// RateLimited makes the response a primary rate limit response (403 with X-RateLimit-Remaining: 0).
+	RateLimited bool
+
+	// RetryAfter makes the response a secondary rate limit response (403 with Retry-After).
+	RetryAfter time.Duration
+}
+
+// FakeGitHub is an in-memory stand-in for the GitHub Apps REST API.
+// It implements the endpoints used by the service with GitHub's semantics:
+// installation lookup by repository, uninstallation, and installation token creation where
+// requested permissions are downgraded to the installation's level and
+// permissions the installation doesn't have are rejected.
+type FakeGitHub struct {
+	AppID int64
+
+	// AppPublicKey enables validation of the App JWT on App endpoints.
+	// Requests must then be signed with the matching private key, issued by AppID,
+	// and valid for no more than 10 minutes, otherwise they are rejected with 401.
+	AppPublicKey *rsa.PublicKey
+
+	mux           *http.ServeMux
+	mu            sync.Mutex
+	installations []*FakeInstallation
+	environments  map[string]*FakeEnvironment
+	tokens        map[string]*fakeToken
+	failures      map[string][]FakeGitHubFailure
+
+	// repositories maps the IDs of repositories the fake has seen to their "owner/repo" names:
+	// repositories of installations, environments and installation lookups.
+	repositories map[int64]string
+}
+
+// fakeToken is an installation token issued by FakeGitHub.
+type fakeToken struct {
+	installation *FakeInstallation
+
+	// repositories are the "owner/repo" names the token is restricted to; all repositories if empty.
+	repositories []string
+}
+
+// NewFakeGitHub creates a fake GitHub API for the given App ID without installations.
+func NewFakeGitHub(appID int64) *FakeGitHub {
+	f := &FakeGitHub{
+		AppID:        appID,
+		mux:          http.NewServeMux(),
+		environments: make(map[string]*FakeEnvironment),
+		tokens:       make(map[string]*fakeToken),
+		failures:     make(map[string][]FakeGitHubFailure),
+		repositories: make(map[int64]string),
+	}
+	f.handle(FakeGitHubGetApp, true, f.handleGetApp)
+	f.handle(FakeGitHubFindRepositoryInstallation, true, f.handleFindRepositoryInstallation)
+	f.handle(FakeGitHubDeleteInstallation, true, f.handleDeleteInstallation)
+	f.handle(FakeGitHubCreateInstallationToken, true, f.handleCreateInstallationToken)
+	f.handle(FakeGitHubRevokeInstallationToken, false, f.handleRevokeInstallationToken)
+	f.handle(FakeGitHubGetEnvironment, false, f.handleGetEnvironment)
+	return f
+}
+
+// FailNext makes the next request to endpoint fail with failure.
+// Failures scripted for the same endpoint are served in order.
+func (f *FakeGitHub) FailNext(endpoint string, failure FakeGitHubFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[endpoint] = append(f.failures[endpoint], failure)
+}
+
+// AddInstallation installs the fake App.
+func (f *FakeGitHub) AddInstallation(installation *FakeInstallation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.installations = append(f.installations, installation)
+	for _, repository := range installation.Repositories {
+		f.repositories[FakeRepositoryID(repository)] = repository
+	}
+}
+
+// AddEnvironment adds a deployment environment to the "owner/repo" repository.
+func (f *FakeGitHub) AddEnvironment(repository, name string, environment *FakeEnvironment) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.environments[strings.ToLower(repository+"/"+name)] = environment
+	f.repositories[FakeRepositoryID(repository)] = repository
+}
+
+// ServeHTTP serves the fake GitHub API.
+func (f *FakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.ServeHTTP(w, r)
+}
+
+// handle registers handler for endpoint, serving scripted failures first.
+// App endpoints (appAuth) require a valid App JWT when AppPublicKey is set.
+func (f *FakeGitHub) handle(endpoint string, appAuth bool, handler http.HandlerFunc) {
+	f.mux.HandleFunc(endpoint, func(w http.ResponseWriter, r *http.Request) {
+		if failure, ok := f.nextFailure(endpoint); ok {
+			writeFakeGitHubFailure(w, failure)
+			return
+		}
+		if appAuth && f.AppPublicKey != nil {
+			if message := f.validateAppJWT(r); message != "" {
+				writeFakeGitHubError(w, http.StatusUnauthorized, message)
+				return
+			}
+		}
+		handler(w, r)
+	})
+}
+
+// nextFailure removes and returns the next scripted failure of endpoint.
+func (f *FakeGitHub) nextFailure(endpoint string) (FakeGitHubFailure, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	failures := f.failures[endpoint]
+	if len(failures) == 0 {
+		return FakeGitHubFailure{}, false
+	}
+	f.failures[endpoint] = failures[1:]
+	return failures[0], true
+}
+
+// validateAppJWT validates the App JWT of the request the way GitHub does.
+// It returns the GitHub error message if the JWT is rejected, or an empty string.
+func (f *FakeGitHub) validateAppJWT(r *http.Request) string {
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "A JSON web token could not be decoded"
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return f.AppPublicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithLeeway(fakeGitHubClockSkew))
+	if err != nil {
+		return fmt.Sprintf("A JSON web token could not be decoded: %v", err)
+	}
+
+	issuer, err := claims.GetIssuer()
+	if err != nil || issuer != strconv.FormatInt(f.AppID, 10) {
+		return fmt.Sprintf("'Issuer' claim ('iss') must be the App ID %d", f.AppID)
+	}
+
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return "'Issued at' claim ('iat') is required"
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return "'Expiration time' claim ('exp') is required"
+	}
+	if expiresAt.Sub(issuedAt.Time) > fakeGitHubMaxJWTLifetime {
+		return "'Expiration time' claim ('exp') is too far in the future"
+	}
+
+	return ""
+}
+
+// handleGetApp serves GET /app.
+func (f *FakeGitHub) handleGetApp(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":   f.AppID,
+		"slug": "fake-token-issuer",
+		"name": "Fake Token Issuer",
+	})
+}
+
+// handleFindRepositoryInstallation serves GET /repos/{owner}/{repo}/installation.
+func (f *FakeGitHub) handleFindRepositoryInstallation(w http.ResponseWriter, r *http.Request) {
+	owner := r.PathValue("owner")
+	installation := f.findInstallation(owner, r.PathValue("repo"))
+	if installation == nil {
+		writeFakeGitHubError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, fakeInstallationJSON(installation, owner))
+}
+
+// handleDeleteInstallation serves DELETE /app/installations/{id}, uninstalling the App.
+func (f *FakeGitHub) handleDeleteInstallation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeFakeGitHubError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, installation := range f.installations {
+		if installation.ID == id {
+			f.installations = append(f.installations[:i], f.installations[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeFakeGitHubError(w, http.StatusNotFound, "Not Found")
+}
+
+// handleCreateInstallationToken serves POST /app/installations/{id}/access_tokens.
+func (f *FakeGitHub) handleCreateInstallationToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeFakeGitHubError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	var request struct {
+		Permissions   map[string]string `json:"permissions"`
+		RepositoryIDs []int64           `json:"repository_ids"`
+		Repositories  []string          `json:"repositories"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeFakeGitHubError(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var installation *FakeInstallation
+	for _, candidate := range f.installations {
+		if candidate.ID == id {
+			installation = candidate
+		}
+	}
+	if installation == nil {
+		writeFakeGitHubError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if installation.Suspended {
+		writeFakeGitHubError(w, http.StatusForbidden, "This installation has been suspended")
+		return
+	}
+
+	// Grant requested permissions, downgraded to the installation's level
+	granted := make(map[string]string)
+	for name, level := range request.Permissions {
+		installed, ok := installation.Permissions[name]
+		if !ok {
+			writeFakeGitHubError(w, http.StatusUnprocessableEntity, "The permissions requested are not granted to this installation.")
+			return
+		}
+		if level == "write" && installed == "read" {
+			level = "read"
+		}
+		granted[name] = level
+	}
+	if len(request.Permissions) == 0 {
+		granted = installation.Permissions
+	}
+
+	// Restrict the token to the requested repositories of the installation, by ID or by name within the account
+	repositories := make([]string, 0, len(request.RepositoryIDs)+len(request.Repositories))
+	for _, id := range request.RepositoryIDs {
+		repositories = append(repositories, f.repositories[id])
+	}
+	for _, name := range request.Repositories {
+		repositories = append(repositories, installation.Account+"/"+name)
+	}
+	repositoriesJSON := make([]map[string]interface{}, 0, len(repositories))
+	for _, repository := range repositories {
+		if !installationHasRepository(installation, repository) {
+			writeFakeGitHubError(w, http.StatusUnprocessableEntity, "There is at least one repository that does not exist or is not accessible to the parent installation.")
+			return
+		}
+		owner, name, _ := strings.Cut(repository, "/")
+		repositoriesJSON = append(repositoriesJSON, map[string]interface{}{
+			"id":        FakeRepositoryID(repository),
+			"name":      name,
+			"full_name": repository,
+			"owner":     map[string]interface{}{"login": owner, "id": FakeOwnerID(owner)},
+		})
+	}
+
+	token := "ghs_" + randomHex(18)
+	f.tokens[token] = &fakeToken{installation: installation, repositories: repositories}
+
+	response := map[string]interface{}{
+		"token":       token,
+		"expires_at":  time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		"permissions": granted,
+	}
+	if len(repositories) > 0 {
+		response["repository_selection"] = "selected"
+		response["repositories"] = repositoriesJSON
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// handleRevokeInstallationToken serves DELETE /installation/token.
+func (f *FakeGitHub) handleRevokeInstallationToken(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.tokens[token]; !ok {
+		writeFakeGitHubError(w, http.StatusUnauthorized, "Bad credentials")
+		return
+	}
+	delete(f.tokens, token)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetEnvironment serves GET /repos/{owner}/{repo}/environments/{name}, authenticated with an installation token.
+func (f *FakeGitHub) handleGetEnvironment(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	owner, repo, name := r.PathValue("owner"), r.PathValue("repo"), r.PathValue("name")
+
+	f.mu.Lock()
+	issued, validToken := f.tokens[token]
+	environment := f.environments[strings.ToLower(owner+"/"+repo+"/"+name)]
+	f.mu.Unlock()
+
+	if !validToken {
+		writeFakeGitHubError(w, http.StatusUnauthorized, "Bad credentials")
+		return
+	}
+	if environment == nil || !issued.hasRepository(owner+"/"+repo) {
+		writeFakeGitHubError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	rules := make([]map[string]interface{}, 0, len(environment.ProtectionRules))
+	for i, ruleType := range environment.ProtectionRules {
+		rules = append(rules, map[string]interface{}{"id": i + 1, "type": ruleType})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":             name,
+		"protection_rules": rules,
+	})
+}
+
+// findInstallation returns the installation with access to the repository, or nil.
+func (f *FakeGitHub) findInstallation(owner, repo string) *FakeInstallation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fullName := owner + "/" + repo
+	f.repositories[FakeRepositoryID(fullName)] = fullName
+	for _, installation := range f.installations {
+		if installationHasRepository(installation, fullName) {
+			return installation
+		}
+	}
+	return nil
+}
+
+// installationHasRepository reports whether the installation has access to the "owner/repo" repository.
+func installationHasRepository(installation *FakeInstallation, fullName string) bool {
+	owner, _, _ := strings.Cut(fullName, "/")
+	if fullName == "" || installation.Account != "" && !strings.EqualFold(installation.Account, owner) {
+		return false
+	}
+	if len(installation.Repositories) == 0 {
+		return true
+	}
+	for _, repository := range installation.Repositories {
+		if strings.EqualFold(repository, fullName) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRepository reports whether the token has access to the "owner/repo" repository.
+func (t *fakeToken) hasRepository(fullName string) bool {
+	if len(t.repositories) == 0 {
+		return installationHasRepository(t.installation, fullName)
+	}
+	for _, repository := range t.repositories {
+		if strings.EqualFold(repository, fullName) {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeInstallationJSON returns the GitHub API representation of an installation found for a repository of owner.
+// Installations without an account are represented as installed on owner.
+func fakeInstallationJSON(installation *FakeInstallation, owner string) map[string]interface{} {
+	account := installation.Account
+	if account == "" {
+		account = owner
+	}
+	repositorySelection := "all"
+	if len(installation.Repositories) > 0 {
+		repositorySelection = "selected"
+	}
+	return map[string]interface{}{
+		"id":                   installation.ID,
+		"account":              map[string]interface{}{"login": account, "id": FakeOwnerID(account)},
+		"repository_selection": repositorySelection,
+		"permissions":          installation.Permissions,
+	}
+}
+
+// writeFakeGitHubError writes an error in GitHub API format.
+func writeFakeGitHubError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{
+		"message":           message,
+		"documentation_url": "https://docs.github.com/rest",
+	})
+}
+
+// writeFakeGitHubFailure writes a scripted failure in GitHub API format.
+func writeFakeGitHubFailure(w http.ResponseWriter, failure FakeGitHubFailure) {
+	message := failure.Message
+	switch {
+	case failure.RateLimited:
+		if message == "" {
+			message = "API rate limit exceeded for installation."
+		}
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		writeFakeGitHubError(w, http.StatusForbidden, message)
+	case failure.RetryAfter > 0:
+		if message == "" {
+			message = "You have exceeded a secondary rate limit."
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(failure.RetryAfter.Seconds())))
+		writeJSON(w, http.StatusForbidden, map[string]string{
+			"message":           message,
+			"documentation_url": "https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits",
+		})
+	default:
+		if message == "" {
+			message = http.StatusText(failure.StatusCode)
+		}
+		writeFakeGitHubError(w, failure.StatusCode, message)
+	}
+}
+
+// randomHex returns n random bytes encoded as hex.
+func randomHex(n int) string {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(data)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestFakeGitHubClient starts fake as an httptest server and returns a GitHub client for it and its URL.
+func newTestFakeGitHubClient(t *testing.T, fake *FakeGitHub) (GitHubAppsService, string) {
+	t.Helper()
+	httpServer := httptest.NewServer(fake)
+	t.Cleanup(httpServer.Close)
+
+	client, err := NewGitHubClientWithJWTForURL("test-jwt", httpServer.URL)
+	if err != nil {
+		t.Fatalf("NewGitHubClientWithJWTForURL() error = %v", err)
+	}
+	return client.Apps, httpServer.URL
+}
+
+// TestFakeGitHub_InstallationLookup tests installation lookup by repository.
+//
+// Test steps:
+//  1. Create fake GitHub with an installation on selected repositories
+//  2. Call GetInstallationID for an installed and a not installed repository
+//  3. Verify installed repository returns the installation ID
+//  4. Verify not installed repository returns "not installed" error
+func TestFakeGitHub_InstallationLookup(t *testing.T) {
+	// Step 1: Create fake with installation
+	fake := NewFakeGitHub(1)
+	fake.AddInstallation(&FakeInstallation{ID: 42, Account: "owner", Repositories: []string{"owner/repo"}})
+	apps, _ := newTestFakeGitHubClient(t, fake)
+
+	// Step 2 & 3: Installed repository
+	id, err := GetInstallationID(context.Background(), apps, TokenRepository{OwnerID: FakeOwnerID("owner"), Name: "owner/repo"})
+	if err != nil {
+		t.Fatalf("GetInstallationID() error = %v", err)
+	}
+	if id != 42 {
+		t.Errorf("GetInstallationID() = %v, want 42", id)
+	}
+
+	// Step 2 & 4: Not installed repository
+	_, err = GetInstallationID(context.Background(), apps, TokenRepository{Name: "owner/other"})
+	if err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Errorf("GetInstallationID() error = %v, want containing 'not installed'", err)
+	}
+}
+
+// TestFakeGitHub_CreateInstallationToken tests token creation semantics of the fake.
+// Requested permissions are downgraded to the installation's level,
+// and permissions the installation doesn't have are rejected.
+// Tokens CreateInstallationToken rejects after GitHub created them are revoked.
+//
+// Test steps:
+//  1. Create fake GitHub with an installation having contents:read and issues:write
+//  2. Call CreateInstallationToken with the test scopes and repository
+//  3. Verify token is issued when scopes are within the installation permissions
+//  4. Verify downgraded and missing permissions and other repositories produce errors
+//  5. Verify rejected tokens are revoked
+func TestFakeGitHub_CreateInstallationToken(t *testing.T) {
+	// Step 1: Create fake with installation
+	fake := NewFakeGitHub(1)
+	fake.AddInstallation(&FakeInstallation{
+		ID:           42,
+		Account:      "owner",
+		Repositories: []string{"owner/repo", "owner/other"},
+		Permissions:  map[string]string{"contents": "read", "issues": "write", "repository_projects": "write"},
+	})
+	apps, apiURL := newTestFakeGitHubClient(t, fake)
+
+	tests := []struct {
+		name        string
+		scopes      map[string]string
+		repository  TokenRepository
+		wantErr     bool
+		errContains string
+		wantRevoked bool
+	}{
+		{
+			name:   "scopes within installation permissions",
+			scopes: map[string]string{"contents": "read", "issues": "write", "projects": "write"},
+		},
+		{
+			name:        "write downgraded to read",
+			scopes:      map[string]string{"contents": "write"},
+			wantErr:     true,
+			errContains: "fewer scopes",
+			wantRevoked: true,
+		},
+		{
+			name:        "permission not granted to installation",
+			scopes:      map[string]string{"actions": "read"},
+			wantErr:     true,
+			errContains: "insufficient permissions",
+		},
+		{
+			name:        "token for another repository",
+			scopes:      map[string]string{"contents": "read"},
+			repository:  TokenRepository{ID: FakeRepositoryID("owner/other"), Name: "owner/repo"},
+			wantErr:     true,
+			errContains: "repository does not match the OIDC token",
+			wantRevoked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Create token
+			repository := tt.repository
+			if repository.Name == "" {
+				repository = TokenRepository{Name: "owner/repo"}
+			}
+			fake.mu.Lock()
+			tokens := len(fake.tokens)
+			fake.mu.Unlock()
+			token, err := CreateInstallationToken(context.Background(), apps, apiURL, 42, repository, tt.scopes, nil)
+
+			// Step 3 & 4: Verify results
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("CreateInstallationToken() error = %v, want containing %q", err, tt.errContains)
+				}
+
+				// Step 5: Verify the rejected token was revoked
+				if tt.wantRevoked {
+					fake.mu.Lock()
+					defer fake.mu.Unlock()
+					if len(fake.tokens) != tokens {
+						t.Errorf("FakeGitHub has %d tokens, want %d (rejected token revoked)", len(fake.tokens), tokens)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateInstallationToken() unexpected error = %v", err)
+			}
+			if !strings.HasPrefix(token.GetToken(), "ghs_") {
+				t.Errorf("CreateInstallationToken() token = %v, want ghs_ prefix", token.GetToken())
+			}
+		})
+	}
+}
+
+// TestFakeGitHub_AppJWTValidation tests that App endpoints validate the App JWT like GitHub.
+//
+// Test steps:
+//  1. Create fake GitHub with the App public key
+//  2. Call GET /app with a JWT built from the test case claims
+//  3. Verify valid JWTs are accepted
+//  4. Verify invalid JWTs are rejected with 401 and the expected message
+func TestFakeGitHub_AppJWTValidation(t *testing.T) {
+	// Step 1: Create fake with public key
+	key := generateTestRSAKey(t)
+	fake := NewFakeGitHub(123)
+	fake.AppPublicKey = &key.PublicKey
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		claims      jwt.MapClaims
+		signingKey  *rsa.PrivateKey
+		errContains string
+	}{
+		{
+			name:   "valid",
+			claims: jwt.MapClaims{"iss": "123", "iat": now.Unix(), "exp": now.Add(10 * time.Minute).Unix()},
+		},
+		{
+			name:        "wrong key",
+			claims:      jwt.MapClaims{"iss": "123", "iat": now.Unix(), "exp": now.Add(10 * time.Minute).Unix()},
+			signingKey:  generateTestRSAKey(t),
+			errContains: "could not be decoded",
+		},
+		{
+			name:        "expired",
+			claims:      jwt.MapClaims{"iss": "123", "iat": now.Add(-20 * time.Minute).Unix(), "exp": now.Add(-10 * time.Minute).Unix()},
+			errContains: "could not be decoded",
+		},
+		{
+			name:        "wrong issuer",
+			claims:      jwt.MapClaims{"iss": "456", "iat": now.Unix(), "exp": now.Add(10 * time.Minute).Unix()},
+			errContains: "'Issuer' claim",
+		},
+		{
+			name:        "lifetime too long",
+			claims:      jwt.MapClaims{"iss": "123", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()},
+			errContains: "too far in the future",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Call GET /app
+			signingKey := key
+			if tt.signingKey != nil {
+				signingKey = tt.signingKey
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, tt.claims).SignedString(signingKey)
+			if err != nil {
+				t.Fatalf("failed to sign JWT: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/app", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			fake.ServeHTTP(w, req)
+
+			// Step 3: Verify valid JWT
+			if tt.errContains == "" {
+				if w.Code != http.StatusOK {
+					t.Errorf("GET /app status = %v, want %v (body: %s)", w.Code, http.StatusOK, w.Body.String())
+				}
+				return
+			}
+
+			// Step 4: Verify rejection
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("GET /app status = %v, want %v", w.Code, http.StatusUnauthorized)
+			}
+			if !strings.Contains(w.Body.String(), tt.errContains) {
+				t.Errorf("GET /app body = %s, want containing %q", w.Body.String(), tt.errContains)
+			}
+		})
+	}
+}
+
+// TestFakeGitHub_FailNext tests that scripted failures are served once, in order.
+//
+// Test steps:
+//  1. Create fake GitHub and script two failures for GET /app
+//  2. Call GET /app three times
+//  3. Verify the failures are served in order, then the regular response
+func TestFakeGitHub_FailNext(t *testing.T) {
+	// Step 1: Script failures
+	fake := NewFakeGitHub(1)
+	fake.FailNext(FakeGitHubGetApp, FakeGitHubFailure{StatusCode: http.StatusBadGateway})
+	fake.FailNext(FakeGitHubGetApp, FakeGitHubFailure{RateLimited: true})
+
+	// Step 2 & 3: Call and verify
+	for i, want := range []int{http.StatusBadGateway, http.StatusForbidden, http.StatusOK} {
+		w := httptest.NewRecorder()
+		fake.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app", nil))
+		if w.Code != want {
+			t.Errorf("request %d status = %v, want %v", i+1, w.Code, want)
+		}
+		if want == http.StatusForbidden && w.Header().Get("X-RateLimit-Remaining") != "0" {
+			t.Errorf("request %d X-RateLimit-Remaining = %q, want 0", i+1, w.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeOIDCKeyID is the key ID of the FakeOIDCIssuer signing key.
+const fakeOIDCKeyID = "fake-oidc-key"
+
+// FakeOIDCIssuer is an in-memory OIDC issuer minting GitHub Actions-like ID tokens.
+// It serves the discovery document, the JWKS and a token endpoint compatible with
+// the ACTIONS_ID_TOKEN_REQUEST_URL response format.
+type FakeOIDCIssuer struct {
+	// Issuer is the issuer URL set as the "iss" claim and in the discovery document.
+	Issuer string
+
+	// DefaultClaims are added to every minted token unless overridden by the request.
+	DefaultClaims map[string]interface{}
+
+	key *rsa.PrivateKey
+	mux *http.ServeMux
+}
+
+// NewFakeOIDCIssuer creates a fake OIDC issuer signing tokens with the given key.
+func NewFakeOIDCIssuer(issuer string, key *rsa.PrivateKey) *FakeOIDCIssuer {
+	f := &FakeOIDCIssuer{
+		Issuer: issuer,
+		DefaultClaims: map[string]interface{}{
+			"ref":        "refs/heads/main",
+			"ref_type":   "branch",
+			"event_name": "push",
+		},
+		key: key,
+		mux: http.NewServeMux(),
+	}
+	f.mux.HandleFunc("GET /.well-known/openid-configuration", f.handleDiscovery)
+	f.mux.HandleFunc("GET /.well-known/jwks", f.handleJWKS)
+	f.mux.HandleFunc("GET /token", f.handleToken)
+	return f
+}
+
+// ServeHTTP serves the fake OIDC issuer endpoints.
+func (f *FakeOIDCIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.ServeHTTP(w, r)
+}
+
+// Mint creates a signed ID token with the default claims overridden by claims.
+// The "sub" claim is derived from the "repository" and "ref" claims when not set,
+// and the "repository_id" and "repository_owner_id" claims from the "repository" claim
+// (see FakeRepositoryID and FakeOwnerID).
+func (f *FakeOIDCIssuer) Mint(claims map[string]interface{}) (string, error) {
+	now := time.Now()
+	mapClaims := jwt.MapClaims{
+		"iss": f.Issuer,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+	for name, value := range f.DefaultClaims {
+		mapClaims[name] = value
+	}
+	for name, value := range claims {
+		mapClaims[name] = value
+	}
+	if _, ok := mapClaims["sub"]; !ok {
+		mapClaims["sub"] = fmt.Sprintf("repo:%v:ref:%v", mapClaims["repository"], mapClaims["ref"])
+	}
+	if repository, ok := mapClaims["repository"].(string); ok {
+		if _, ok := mapClaims["repository_id"]; !ok {
+			mapClaims["repository_id"] = strconv.FormatInt(FakeRepositoryID(repository), 10)
+		}
+		if _, ok := mapClaims["repository_owner_id"]; !ok {
+			owner, _, _ := strings.Cut(repository, "/")
+			mapClaims["repository_owner_id"] = strconv.FormatInt(FakeOwnerID(owner), 10)
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, mapClaims)
+	token.Header["kid"] = fakeOIDCKeyID
+	signedToken, err := token.SignedString(f.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign ID token: %w", err)
+	}
+	return signedToken, nil
+}
+
+// handleDiscovery serves GET /.well-known/openid-configuration.
+func (f *FakeOIDCIssuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                f.Issuer,
+		"jwks_uri":                              f.Issuer + "/.well-known/jwks",
+		"response_types_supported":              []string{"id_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+// handleJWKS serves GET /.well-known/jwks.
+func (f *FakeOIDCIssuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	publicKey := f.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": fakeOIDCKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}},
+	})
+}
+
+// handleToken serves GET /token?audience=...&<claim>=<value>.
+// Every query parameter except "audience" becomes a string claim of the minted token.
+// The response format matches ACTIONS_ID_TOKEN_REQUEST_URL: {"value": "<token>"}.
+func (f *FakeOIDCIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	claims := make(map[string]interface{})
+	for name, values := range r.URL.Query() {
+		if name == "audience" {
+			claims["aud"] = values[0]
+			continue
+		}
+		claims[name] = values[0]
+	}
+
+	token, err := f.Mint(claims)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"value": token})
+}
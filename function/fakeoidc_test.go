@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TestFakeOIDCIssuer_TokenEndpoint tests minting tokens through the Actions-compatible token endpoint.
+// Minted tokens must verify against the published JWKS and carry the requested claims.
+//
+// Test steps:
+//  1. Create fake OIDC issuer
+//  2. Request a token with repository claim and audience
+//  3. Fetch the JWKS and build the public key
+//  4. Verify token signature, issuer, audience and claims
+//  5. Verify the minted token is accepted by ExtractRepositoryFromOIDC
+func TestFakeOIDCIssuer_TokenEndpoint(t *testing.T) {
+	// Step 1: Create issuer
+	issuer := NewFakeOIDCIssuer("https://issuer.example", generateTestRSAKey(t))
+
+	// Step 2: Request token
+	req := httptest.NewRequest(http.MethodGet, "/token?repository=owner/repo&audience=my-audience", nil)
+	w := httptest.NewRecorder()
+	issuer.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("token endpoint status = %v, want %v", w.Code, http.StatusOK)
+	}
+	var tokenResp struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &tokenResp); err != nil {
+		t.Fatalf("failed to unmarshal token response: %v", err)
+	}
+
+	// Step 3: Fetch JWKS
+	req = httptest.NewRequest(http.MethodGet, "/.well-known/jwks", nil)
+	w = httptest.NewRecorder()
+	issuer.ServeHTTP(w, req)
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil || len(jwks.Keys) != 1 {
+		t.Fatalf("invalid JWKS response: %s", w.Body.String())
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	// Step 4: Verify token
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenResp.Value, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != jwks.Keys[0].Kid {
+			t.Errorf("token kid = %v, want %v", token.Header["kid"], jwks.Keys[0].Kid)
+		}
+		return publicKey, nil
+	}, jwt.WithIssuer("https://issuer.example"), jwt.WithAudience("my-audience"))
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+	if claims["repository"] != "owner/repo" {
+		t.Errorf("repository claim = %v, want owner/repo", claims["repository"])
+	}
+	if claims["sub"] != "repo:owner/repo:ref:refs/heads/main" {
+		t.Errorf("sub claim = %v, want repo:owner/repo:ref:refs/heads/main", claims["sub"])
+	}
+
+	// Step 5: Verify token is accepted by the service
+	repository, err := ExtractRepositoryFromOIDC(tokenResp.Value)
+	if err != nil || repository != "owner/repo" {
+		t.Errorf("ExtractRepositoryFromOIDC() = %v, %v, want owner/repo", repository, err)
+	}
+}
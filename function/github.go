@@ -2,49 +2,33 @@ package main
 
 import (
 	"context"
+	"crypto"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 
-	secretmanager "cloud.google.com/go/secretmanager/apiv1"
-	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/go-github/v81/github"
 )
 
-// GetPrivateKey fetches the GitHub App private key from GCP Secret Manager.
-func GetPrivateKey(ctx context.Context, projectID string) (privateKey *rsa.PrivateKey, err error) {
-	client, err := secretmanager.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Secret Manager client: %w", err)
-	}
-	defer func() {
-		if closeErr := client.Close(); closeErr != nil && err == nil {
-			err = fmt.Errorf("failed to close Secret Manager client: %w", closeErr)
-		}
-	}()
-
-	req := &secretmanagerpb.AccessSecretVersionRequest{
-		Name: fmt.Sprintf("projects/%s/secrets/github-app-private-key/versions/latest", projectID),
-	}
-
-	result, err := client.AccessSecretVersion(ctx, req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve private key from Secret Manager: %w", err)
-	}
-
+// ParsePrivateKey parses a PEM-encoded RSA private key in PKCS1 or PKCS8 format.
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
 	// Parse PEM-encoded private key
-	block, _ := pem.Decode(result.Payload.Data)
+	block, _ := pem.Decode(data)
 	if block == nil {
 		return nil, fmt.Errorf("failed to decode PEM block from private key")
 	}
 
 	// Try PKCS1 format first (RSA PRIVATE KEY)
-	privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
 		// Try PKCS8 format (PRIVATE KEY)
 		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
@@ -62,11 +46,18 @@ func GetPrivateKey(ctx context.Context, projectID string) (privateKey *rsa.Priva
 }
 
 // CreateJWT creates a JWT for authenticating as the GitHub App.
+// The JWT is signed (RS256) by signer, which may keep the private key in a key management system.
 // JWT expires in 10 minutes (GitHub's maximum allowed).
-func CreateJWT(privateKey *rsa.PrivateKey, appID string) (string, error) {
-	if privateKey == nil {
+func CreateJWT(signer crypto.Signer, appID string) (string, error) {
+	if signer == nil {
 		return "", fmt.Errorf("private key is nil")
 	}
+	if privateKey, ok := signer.(*rsa.PrivateKey); ok && privateKey == nil {
+		return "", fmt.Errorf("private key is nil")
+	}
+	if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+		return "", fmt.Errorf("private key is not an RSA key (GitHub requires RS256)")
+	}
 
 	now := time.Now()
 
@@ -76,8 +67,8 @@ func CreateJWT(privateKey *rsa.PrivateKey, appID string) (string, error) {
 		"iss": appID,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	signedToken, err := token.SignedString(privateKey)
+	token := jwt.NewWithClaims(signingMethodRS256Signer, claims)
+	signedToken, err := token.SignedString(signer)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign JWT: %w", err)
 	}
@@ -85,6 +76,10 @@ func CreateJWT(privateKey *rsa.PrivateKey, appID string) (string, error) {
 	return signedToken, nil
 }
 
+// errRepositoryMismatch is returned when GitHub resolves the repository of an OIDC token
+// to another repository or owner ID than the token claims, e.g., after a rename, transfer or name reuse.
+var errRepositoryMismatch = errors.New("repository does not match the OIDC token")
+
 // GitHubAppsService defines the GitHub Apps API methods used by this package.
 type GitHubAppsService interface {
 	FindRepositoryInstallation(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error)
@@ -92,88 +87,93 @@ type GitHubAppsService interface {
 }
 
 // GetInstallationID finds the GitHub App installation ID for the given repository.
-func GetInstallationID(ctx context.Context, apps GitHubAppsService, repository string) (int64, error) {
-	parts := strings.Split(repository, "/")
+// When the owner ID is known, the installation account must be that owner.
+func GetInstallationID(ctx context.Context, apps GitHubAppsService, repository TokenRepository) (int64, error) {
+	installation, err := GetInstallation(ctx, apps, repository)
+	if err != nil {
+		return 0, err
+	}
+	return installation.GetID(), nil
+}
+
+// GetInstallation finds the GitHub App installation of the given repository, with its account.
+// When the owner ID is known, the installation account must be that owner.
+func GetInstallation(ctx context.Context, apps GitHubAppsService, repository TokenRepository) (*github.Installation, error) {
+	parts := strings.Split(repository.Name, "/")
 	if len(parts) != 2 {
-		return 0, fmt.Errorf("invalid repository format: %s", repository)
+		return nil, fmt.Errorf("invalid repository format: %s", repository.Name)
 	}
 
 	owner, repo := parts[0], parts[1]
 
 	installation, resp, err := apps.FindRepositoryInstallation(ctx, owner, repo)
 	if err != nil {
+		if isRateLimitError(err, resp) {
+			return nil, fmt.Errorf("GitHub API rate limit exceeded: %w", err)
+		}
 		if resp != nil && resp.StatusCode == http.StatusNotFound {
-			return 0, fmt.Errorf("GitHub App is not installed on repository %s", repository)
+			return nil, fmt.Errorf("GitHub App is not installed on repository %s", repository.Name)
 		}
-		return 0, fmt.Errorf("failed to find installation: %w", err)
+		return nil, fmt.Errorf("failed to find installation: %w", err)
 	}
 
 	if installation.ID == nil {
-		return 0, fmt.Errorf("installation ID is nil for repository %s", repository)
+		return nil, fmt.Errorf("installation ID is nil for repository %s", repository.Name)
 	}
 
-	return *installation.ID, nil
+	// The installation account is the repository owner
+	if repository.OwnerID != 0 && installation.GetAccount().GetID() != repository.OwnerID {
+		return nil, fmt.Errorf("%w: %s is owned by account ID %d, not %d",
+			errRepositoryMismatch, repository.Name, installation.GetAccount().GetID(), repository.OwnerID)
+	}
+
+	return installation, nil
 }
 
-// CreateInstallationToken requests an installation access token from GitHub with the specified permissions.
-func CreateInstallationToken(ctx context.Context, apps GitHubAppsService, installationID int64, scopes map[string]string) (*github.InstallationToken, error) {
-	// Build permissions map
-	permissions := &github.InstallationPermissions{}
+// TokenRepository is the repository an installation token is restricted to.
+type TokenRepository struct {
+	// ID is the repository ID (repository_id claim of GitHub Actions tokens).
+	// When zero, the token is restricted by Name instead.
+	ID int64
 
-	// Use reflection-free approach: map scope IDs to struct fields
-	for scopeID, permission := range scopes {
-		permValue := github.Ptr(permission)
-
-		switch scopeID {
-		case "actions":
-			permissions.Actions = permValue
-		case "administration":
-			permissions.Administration = permValue
-		case "attestations":
-			permissions.Attestations = permValue
-		case "checks":
-			permissions.Checks = permValue
-		case "contents":
-			permissions.Contents = permValue
-		case "dependabot_secrets":
-			permissions.DependabotSecrets = permValue
-		case "deployments":
-			permissions.Deployments = permValue
-		case "discussions":
-			permissions.Discussions = permValue
-		case "environments":
-			permissions.Environments = permValue
-		case "issues":
-			permissions.Issues = permValue
-		case "merge_queues":
-			permissions.MergeQueues = permValue
-		case "packages":
-			permissions.Packages = permValue
-		case "pages":
-			permissions.Pages = permValue
-		case "projects":
-			permissions.RepositoryProjects = permValue
-		case "pull_requests":
-			permissions.PullRequests = permValue
-		case "secret_scanning":
-			permissions.SecretScanningAlerts = permValue
-		case "secrets":
-			permissions.Secrets = permValue
-		case "statuses":
-			permissions.Statuses = permValue
-		case "workflows":
-			permissions.Workflows = permValue
-		default:
-			return nil, fmt.Errorf("unknown scope ID: %s", scopeID)
-		}
+	// OwnerID is the ID of the repository owner (repository_owner_id claim of GitHub Actions tokens).
+	// When set, it must match the owner GitHub resolves the repository to.
+	OwnerID int64
+
+	// Name is the repository in "owner/repo" format.
+	Name string
+}
+
+// CreateInstallationToken requests an installation access token from GitHub with the specified permissions,
+// restricted to the repository. Tokens GitHub returns for other repositories are rejected.
+// Optional scopes may be missing from the granted permissions; all other scopes must be granted as requested.
+// A rejected token is revoked with the GitHub REST API at apiURL (api.github.com if empty).
+func CreateInstallationToken(ctx context.Context, apps GitHubAppsService, apiURL string, installationID int64, repository TokenRepository,
+	scopes map[string]string, optional []string) (*github.InstallationToken, error) {
+	permissions, err := BuildInstallationPermissions(scopes)
+	if err != nil {
+		return nil, err
 	}
 
 	opts := &github.InstallationTokenOptions{
 		Permissions: permissions,
 	}
+	if repository.ID != 0 {
+		opts.RepositoryIDs = []int64{repository.ID}
+	} else {
+		_, repo, ok := strings.Cut(repository.Name, "/")
+		if !ok || repo == "" {
+			return nil, fmt.Errorf("invalid repository format: %s", repository.Name)
+		}
+		opts.Repositories = []string{repo}
+	}
 
 	token, resp, err := apps.CreateInstallationToken(ctx, installationID, opts)
 	if err != nil {
+		// Rate limits are also reported with 403, check them first
+		if isRateLimitError(err, resp) {
+			return nil, fmt.Errorf("GitHub API rate limit exceeded: %w", err)
+		}
 		if resp != nil && resp.StatusCode == http.StatusForbidden {
 			return nil, fmt.Errorf("insufficient permissions for requested scopes")
 		}
@@ -183,81 +183,128 @@ func CreateInstallationToken(ctx context.Context, apps GitHubAppsService, instal
 		return nil, fmt.Errorf("failed to create installation token: %w", err)
 	}
 
-	// Verify that GitHub granted all requested scopes
-	if err := VerifyRequestedScopes(scopes, token.GetPermissions()); err != nil {
+	// Verify that GitHub granted all required scopes
+	required := make(map[string]string, len(scopes))
+	for scopeID, permission := range scopes {
+		if !slices.Contains(optional, scopeID) {
+			required[scopeID] = permission
+		}
+	}
+	if err := VerifyRequestedScopes(required, token.GetPermissions()); err != nil {
+		revokeRejectedToken(ctx, apiURL, token)
+		return nil, err
+	}
+
+	// Verify that the token is restricted to the repository
+	if err := VerifyTokenRepository(repository, token.Repositories); err != nil {
+		revokeRejectedToken(ctx, apiURL, token)
 		return nil, err
 	}
 
 	return token, nil
 }
 
-// VerifyRequestedScopes verifies that GitHub granted all requested scopes.
-func VerifyRequestedScopes(requested map[string]string, granted *github.InstallationPermissions) error {
-	if granted == nil {
-		return fmt.Errorf("GitHub API returned no permissions")
+// revokeRejectedToken revokes a token CreateInstallationToken rejected, so that it isn't left valid until it expires.
+func revokeRejectedToken(ctx context.Context, apiURL string, token *github.InstallationToken) {
+	if err := RevokeInstallationToken(context.WithoutCancel(ctx), apiURL, token.GetToken()); err != nil {
+		log.Printf("Failed to revoke rejected installation token: %v", err)
 	}
+}
 
-	grantedMap := make(map[string]string)
-
-	// Convert granted permissions to map
-	if granted.Actions != nil {
-		grantedMap["actions"] = *granted.Actions
-	}
-	if granted.Administration != nil {
-		grantedMap["administration"] = *granted.Administration
-	}
-	if granted.Attestations != nil {
-		grantedMap["attestations"] = *granted.Attestations
-	}
-	if granted.Checks != nil {
-		grantedMap["checks"] = *granted.Checks
-	}
-	if granted.Contents != nil {
-		grantedMap["contents"] = *granted.Contents
-	}
-	if granted.DependabotSecrets != nil {
-		grantedMap["dependabot_secrets"] = *granted.DependabotSecrets
-	}
-	if granted.Deployments != nil {
-		grantedMap["deployments"] = *granted.Deployments
-	}
-	if granted.Discussions != nil {
-		grantedMap["discussions"] = *granted.Discussions
-	}
-	if granted.Environments != nil {
-		grantedMap["environments"] = *granted.Environments
-	}
-	if granted.Issues != nil {
-		grantedMap["issues"] = *granted.Issues
-	}
-	if granted.MergeQueues != nil {
-		grantedMap["merge_queues"] = *granted.MergeQueues
+// RevokeInstallationToken revokes an installation token with the GitHub REST API at apiURL (api.github.com if empty).
+// Tokens GitHub no longer accepts (401) are already revoked or expired.
+func RevokeInstallationToken(ctx context.Context, apiURL, token string) error {
+	client, err := NewGitHubClientWithJWTForURL(token, apiURL)
+	if err != nil {
+		return err
 	}
-	if granted.Packages != nil {
-		grantedMap["packages"] = *granted.Packages
+	resp, err := client.Apps.RevokeInstallationToken(ctx)
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		return nil
 	}
-	if granted.Pages != nil {
-		grantedMap["pages"] = *granted.Pages
+	return err
+}
+
+// VerifyTokenRepository verifies that GitHub restricted the token to exactly the requested repository.
+// A repository restricted by ID must still have the requested name and owner ID.
+func VerifyTokenRepository(requested TokenRepository, granted []*github.Repository) error {
+	if len(granted) == 1 {
+		repository := granted[0]
+		if requested.ID != 0 && repository.GetID() == requested.ID {
+			if !strings.EqualFold(repository.GetFullName(), requested.Name) ||
+				requested.OwnerID != 0 && repository.GetOwner().GetID() != requested.OwnerID {
+				return fmt.Errorf("%w: repository ID %d is %s (owner ID %d), not %s (owner ID %d)", errRepositoryMismatch,
+					requested.ID, repository.GetFullName(), repository.GetOwner().GetID(), requested.Name, requested.OwnerID)
+			}
+			return nil
+		}
+		if requested.ID == 0 && strings.EqualFold(repository.GetFullName(), requested.Name) {
+			return nil
+		}
 	}
-	if granted.RepositoryProjects != nil {
-		grantedMap["projects"] = *granted.RepositoryProjects
+
+	names := make([]string, 0, len(granted))
+	for _, repository := range granted {
+		names = append(names, fmt.Sprintf("%s (%d)", repository.GetFullName(), repository.GetID()))
 	}
-	if granted.PullRequests != nil {
-		grantedMap["pull_requests"] = *granted.PullRequests
+	return fmt.Errorf("GitHub API returned a token for other repositories than %s: %v", requested.Name, names)
+}
+
+// isBadCredentialsError reports whether GitHub rejected the App JWT (401 Bad credentials),
+// e.g., because the signing key is not, or no longer, registered for the App.
+func isBadCredentialsError(err error) bool {
+	var errorResponse *github.ErrorResponse
+	return errors.As(err, &errorResponse) &&
+		errorResponse.Response != nil && errorResponse.Response.StatusCode == http.StatusUnauthorized
+}
+
+// isRateLimitError reports whether a GitHub API call failed due to a primary or secondary rate limit.
+func isRateLimitError(err error, resp *github.Response) bool {
+	var rateLimitErr *github.RateLimitError
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseRateLimitErr) {
+		return true
 	}
-	if granted.SecretScanningAlerts != nil {
-		grantedMap["secret_scanning"] = *granted.SecretScanningAlerts
+	return resp != nil && resp.StatusCode == http.StatusTooManyRequests
+}
+
+// BuildInstallationPermissions maps scope IDs and permission levels to GitHub installation permissions.
+func BuildInstallationPermissions(scopes map[string]string) (*github.InstallationPermissions, error) {
+	permissions := &github.InstallationPermissions{}
+	for scopeID, permission := range scopes {
+		definition, ok := scopeDefinitionsByID[scopeID]
+		if !ok {
+			return nil, fmt.Errorf("unknown scope ID: %s", scopeID)
+		}
+		*definition.Permission(permissions) = github.Ptr(permission)
 	}
-	if granted.Secrets != nil {
-		grantedMap["secrets"] = *granted.Secrets
+
+	return permissions, nil
+}
+
+// ScopesOfPermissions maps GitHub installation permissions to scope IDs and permission levels.
+// Permissions without a scope definition are ignored.
+func ScopesOfPermissions(permissions *github.InstallationPermissions) map[string]string {
+	scopes := make(map[string]string)
+	if permissions == nil {
+		return scopes
 	}
-	if granted.Statuses != nil {
-		grantedMap["statuses"] = *granted.Statuses
+	for _, definition := range scopeDefinitionsByID {
+		if permission := *definition.Permission(permissions); permission != nil {
+			scopes[definition.ID] = *permission
+		}
 	}
-	if granted.Workflows != nil {
-		grantedMap["workflows"] = *granted.Workflows
+	return scopes
+}
+
+// VerifyRequestedScopes verifies that GitHub granted all requested scopes.
+func VerifyRequestedScopes(requested map[string]string, granted *github.InstallationPermissions) error {
+	if granted == nil {
+		return fmt.Errorf("GitHub API returned no permissions")
 	}
 
+	grantedMap := ScopesOfPermissions(granted)
+
 	// Check if all requested scopes were granted
 	var missing []string
 	for scopeID, requestedPerm := range requested {
@@ -278,3 +325,23 @@ func VerifyRequestedScopes(requested map[string]string, granted *github.Installa
 func NewGitHubClientWithJWT(jwtToken string) *github.Client {
 	return github.NewClient(nil).WithAuthToken(jwtToken)
 }
+
+// NewGitHubClientWithJWTForURL creates a GitHub client authenticated with a JWT
+// for the GitHub REST API at baseURL (api.github.com if empty).
+func NewGitHubClientWithJWTForURL(jwtToken string, baseURL string) (*github.Client, error) {
+	client := NewGitHubClientWithJWT(jwtToken)
+	if baseURL == "" {
+		return client, nil
+	}
+
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API URL: %w", err)
+	}
+	client.BaseURL = parsedURL
+
+	return client, nil
+}
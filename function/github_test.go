@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -255,6 +260,16 @@ func TestVerifyRequestedScopes(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:      "security and metadata scopes granted",
+			requested: map[string]string{"security_events": "write", "vulnerability_alerts": "read", "metadata": "read"},
+			granted: &github.InstallationPermissions{
+				SecurityEvents:      github.Ptr("write"),
+				VulnerabilityAlerts: github.Ptr("read"),
+				Metadata:            github.Ptr("read"),
+			},
+			wantErr: false,
+		},
 		{
 			name:        "missing scope - contents requested but not granted",
 			requested:   map[string]string{"contents": "write"},
@@ -527,6 +542,7 @@ func TestGetInstallationID(t *testing.T) {
 	tests := []struct {
 		name         string
 		repository   string
+		ownerID      int64
 		mockResponse *github.Installation
 		mockResp     *github.Response
 		mockErr      error
@@ -588,6 +604,23 @@ func TestGetInstallationID(t *testing.T) {
 			wantErr:      true,
 			errContains:  "failed to find installation",
 		},
+		{
+			name:         "owner ID matches installation account",
+			repository:   "owner/repo",
+			ownerID:      7,
+			mockResponse: &github.Installation{ID: github.Ptr(int64(12345)), Account: &github.User{ID: github.Ptr(int64(7))}},
+			mockResp:     &github.Response{Response: &http.Response{StatusCode: http.StatusOK}},
+			wantID:       12345,
+		},
+		{
+			name:         "owner ID of other account",
+			repository:   "owner/repo",
+			ownerID:      7,
+			mockResponse: &github.Installation{ID: github.Ptr(int64(12345)), Account: &github.User{ID: github.Ptr(int64(8))}},
+			mockResp:     &github.Response{Response: &http.Response{StatusCode: http.StatusOK}},
+			wantErr:      true,
+			errContains:  "repository does not match the OIDC token: owner/repo is owned by account ID 8, not 7",
+		},
 		{
 			name:         "installation ID is nil",
 			repository:   "owner/repo",
@@ -609,7 +642,7 @@ func TestGetInstallationID(t *testing.T) {
 			}
 
 			// Step 2: Call GetInstallationID
-			gotID, err := GetInstallationID(ctx, mock, tt.repository)
+			gotID, err := GetInstallationID(ctx, mock, TokenRepository{OwnerID: tt.ownerID, Name: tt.repository})
 
 			// Step 3 & 4: Verify results
 			if tt.wantErr {
@@ -635,11 +668,14 @@ func TestGetInstallationID(t *testing.T) {
 	}
 }
 
+// testTokenRepositories is the repository list of installation tokens restricted to owner/repo (ID 1, owner ID 7).
+var testTokenRepositories = []*github.Repository{{ID: github.Ptr(int64(1)), FullName: github.Ptr("owner/repo"), Owner: &github.User{ID: github.Ptr(int64(7))}}}
+
 // TestCreateInstallationToken tests requesting an installation access token from GitHub.
-// It verifies correct permission mapping, error handling, and scope verification.
+// It verifies correct permission mapping, repository restriction, error handling, and scope verification.
 //
 // Test steps:
-//  1. Create mock GitHubAppsService with configured response
+//  1. Create mock GitHubAppsService with configured response, checking the repository restriction
 //  2. Call CreateInstallationToken with test scopes
 //  3. Verify returned token matches expected value
 //  4. Verify error handling for various failure scenarios
@@ -651,9 +687,11 @@ func TestCreateInstallationToken(t *testing.T) {
 		name        string
 		installID   int64
 		scopes      map[string]string
+		optional    []string
 		mockToken   *github.InstallationToken
 		mockResp    *github.Response
 		mockErr     error
+		repository  TokenRepository
 		wantErr     bool
 		errContains string
 	}{
@@ -662,9 +700,10 @@ func TestCreateInstallationToken(t *testing.T) {
 			installID: 12345,
 			scopes:    map[string]string{"contents": "write"},
 			mockToken: &github.InstallationToken{
-				Token:       github.Ptr("ghs_test123"),
-				ExpiresAt:   &github.Timestamp{Time: testTime},
-				Permissions: &github.InstallationPermissions{Contents: github.Ptr("write")},
+				Token:        github.Ptr("ghs_test123"),
+				Repositories: testTokenRepositories,
+				ExpiresAt:    &github.Timestamp{Time: testTime},
+				Permissions:  &github.InstallationPermissions{Contents: github.Ptr("write")},
 			},
 			mockResp: &github.Response{Response: &http.Response{StatusCode: http.StatusCreated}},
 			mockErr:  nil,
@@ -675,8 +714,9 @@ func TestCreateInstallationToken(t *testing.T) {
 			installID: 12345,
 			scopes:    map[string]string{"contents": "read", "issues": "write", "pull_requests": "read"},
 			mockToken: &github.InstallationToken{
-				Token:     github.Ptr("ghs_multi"),
-				ExpiresAt: &github.Timestamp{Time: testTime},
+				Token:        github.Ptr("ghs_multi"),
+				Repositories: testTokenRepositories,
+				ExpiresAt:    &github.Timestamp{Time: testTime},
 				Permissions: &github.InstallationPermissions{
 					Contents:     github.Ptr("read"),
 					Issues:       github.Ptr("write"),
@@ -697,6 +737,16 @@ func TestCreateInstallationToken(t *testing.T) {
 			wantErr:     true,
 			errContains: "insufficient permissions",
 		},
+		{
+			name:        "forbidden - rate limit exceeded",
+			installID:   12345,
+			scopes:      map[string]string{"contents": "write"},
+			mockToken:   nil,
+			mockResp:    &github.Response{Response: &http.Response{StatusCode: http.StatusForbidden}},
+			mockErr:     &github.RateLimitError{Message: "API rate limit exceeded"},
+			wantErr:     true,
+			errContains: "rate limit exceeded",
+		},
 		{
 			name:        "unprocessable entity - suspended installation",
 			installID:   12345,
@@ -729,28 +779,133 @@ func TestCreateInstallationToken(t *testing.T) {
 			installID: 12345,
 			scopes:    map[string]string{"contents": "write", "issues": "write"},
 			mockToken: &github.InstallationToken{
-				Token:       github.Ptr("ghs_partial"),
-				ExpiresAt:   &github.Timestamp{Time: testTime},
-				Permissions: &github.InstallationPermissions{Contents: github.Ptr("write")},
+				Token:        github.Ptr("ghs_partial"),
+				Repositories: testTokenRepositories,
+				ExpiresAt:    &github.Timestamp{Time: testTime},
+				Permissions:  &github.InstallationPermissions{Contents: github.Ptr("write")},
 			},
 			mockResp:    &github.Response{Response: &http.Response{StatusCode: http.StatusCreated}},
 			mockErr:     nil,
 			wantErr:     true,
 			errContains: "fewer scopes",
 		},
+		{
+			name:      "GitHub returns fewer scopes than requested - optional scope missing",
+			installID: 12345,
+			scopes:    map[string]string{"contents": "write", "issues": "write"},
+			optional:  []string{"issues"},
+			mockToken: &github.InstallationToken{
+				Token:        github.Ptr("ghs_partial"),
+				Repositories: testTokenRepositories,
+				ExpiresAt:    &github.Timestamp{Time: testTime},
+				Permissions:  &github.InstallationPermissions{Contents: github.Ptr("write"), Issues: github.Ptr("read")},
+			},
+			mockResp: &github.Response{Response: &http.Response{StatusCode: http.StatusCreated}},
+			mockErr:  nil,
+			wantErr:  false,
+		},
+		{
+			name:      "GitHub returns fewer scopes than requested - required scope missing",
+			installID: 12345,
+			scopes:    map[string]string{"contents": "write", "issues": "write"},
+			optional:  []string{"issues"},
+			mockToken: &github.InstallationToken{
+				Token:        github.Ptr("ghs_partial"),
+				Repositories: testTokenRepositories,
+				ExpiresAt:    &github.Timestamp{Time: testTime},
+				Permissions:  &github.InstallationPermissions{Issues: github.Ptr("write")},
+			},
+			mockResp:    &github.Response{Response: &http.Response{StatusCode: http.StatusCreated}},
+			mockErr:     nil,
+			wantErr:     true,
+			errContains: "fewer scopes",
+		},
+		{
+			name:       "restricted by repository name",
+			installID:  12345,
+			scopes:     map[string]string{"contents": "read"},
+			repository: TokenRepository{Name: "Owner/Repo"},
+			mockToken: &github.InstallationToken{
+				Token:        github.Ptr("ghs_name"),
+				Repositories: testTokenRepositories,
+				Permissions:  &github.InstallationPermissions{Contents: github.Ptr("read")},
+			},
+		},
+		{
+			name:      "token for other repository",
+			installID: 12345,
+			scopes:    map[string]string{"contents": "read"},
+			mockToken: &github.InstallationToken{
+				Token:        github.Ptr("ghs_other"),
+				Repositories: []*github.Repository{{ID: github.Ptr(int64(2)), FullName: github.Ptr("owner/other")}},
+				Permissions:  &github.InstallationPermissions{Contents: github.Ptr("read")},
+			},
+			wantErr:     true,
+			errContains: "GitHub API returned a token for other repositories than owner/repo: [owner/other (2)]",
+		},
+		{
+			name:      "renamed repository",
+			installID: 12345,
+			scopes:    map[string]string{"contents": "read"},
+			mockToken: &github.InstallationToken{
+				Token:        github.Ptr("ghs_renamed"),
+				Repositories: []*github.Repository{{ID: github.Ptr(int64(1)), FullName: github.Ptr("owner/renamed"), Owner: &github.User{ID: github.Ptr(int64(7))}}},
+				Permissions:  &github.InstallationPermissions{Contents: github.Ptr("read")},
+			},
+			wantErr:     true,
+			errContains: "repository does not match the OIDC token: repository ID 1 is owner/renamed (owner ID 7), not owner/repo (owner ID 7)",
+		},
+		{
+			name:      "transferred repository",
+			installID: 12345,
+			scopes:    map[string]string{"contents": "read"},
+			mockToken: &github.InstallationToken{
+				Token:        github.Ptr("ghs_transferred"),
+				Repositories: []*github.Repository{{ID: github.Ptr(int64(1)), FullName: github.Ptr("owner/repo"), Owner: &github.User{ID: github.Ptr(int64(8))}}},
+				Permissions:  &github.InstallationPermissions{Contents: github.Ptr("read")},
+			},
+			wantErr:     true,
+			errContains: "repository ID 1 is owner/repo (owner ID 8), not owner/repo (owner ID 7)",
+		},
+		{
+			name:      "token for all repositories",
+			installID: 12345,
+			scopes:    map[string]string{"contents": "read"},
+			mockToken: &github.InstallationToken{
+				Token:       github.Ptr("ghs_all"),
+				Permissions: &github.InstallationPermissions{Contents: github.Ptr("read")},
+			},
+			wantErr:     true,
+			errContains: "GitHub API returned a token for other repositories than owner/repo",
+		},
 	}
 
+	// Rejected tokens are revoked with a fake GitHub that doesn't know them
+	revocationServer := httptest.NewServer(NewFakeGitHub(1))
+	t.Cleanup(revocationServer.Close)
+	revocationURL := revocationServer.URL
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Step 1: Create mock service
+			repository := tt.repository
+			if repository.Name == "" {
+				repository = TokenRepository{ID: 1, OwnerID: 7, Name: "owner/repo"}
+			}
 			mock := &mockAppsService{
 				createInstallationToken: func(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error) {
+					if repository.ID != 0 && (len(opts.RepositoryIDs) != 1 || opts.RepositoryIDs[0] != repository.ID || len(opts.Repositories) != 0) {
+						t.Errorf("CreateInstallationToken() RepositoryIDs = %v, Repositories = %v, want [%d]", opts.RepositoryIDs, opts.Repositories, repository.ID)
+					}
+					if repository.ID == 0 && (len(opts.Repositories) != 1 || !strings.EqualFold(opts.Repositories[0], "repo") || len(opts.RepositoryIDs) != 0) {
+						t.Errorf("CreateInstallationToken() RepositoryIDs = %v, Repositories = %v, want [repo]", opts.RepositoryIDs, opts.Repositories)
+					}
 					return tt.mockToken, tt.mockResp, tt.mockErr
 				},
 			}
 
 			// Step 2: Call CreateInstallationToken
-			token, err := CreateInstallationToken(ctx, mock, tt.installID, tt.scopes)
+			token, err := CreateInstallationToken(ctx, mock, revocationURL, tt.installID, repository, tt.scopes, tt.optional)
 
 			// Step 3 & 4: Verify results
 			if tt.wantErr {
@@ -780,3 +935,88 @@ func TestCreateInstallationToken(t *testing.T) {
 		})
 	}
 }
+
+// TestParsePrivateKey tests parsing of PEM-encoded GitHub App private keys.
+// Both PKCS1 (RSA PRIVATE KEY) and PKCS8 (PRIVATE KEY) formats must be accepted.
+//
+// Test steps:
+//  1. Encode a test key in the given format
+//  2. Call ParsePrivateKey with the PEM data
+//  3. Verify the parsed key matches the original for valid input
+//  4. Verify error is returned for invalid input
+func TestParsePrivateKey(t *testing.T) {
+	// Step 1: Encode test keys
+	key := generateTestRSAKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal PKCS8 key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("failed to marshal EC key: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name: "PKCS1 key",
+			data: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		},
+		{
+			name: "PKCS8 key",
+			data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		},
+		{
+			name:        "not PEM",
+			data:        []byte("not a key"),
+			wantErr:     true,
+			errContains: "failed to decode PEM block",
+		},
+		{
+			name:        "invalid key bytes",
+			data:        pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}),
+			wantErr:     true,
+			errContains: "failed to parse private key",
+		},
+		{
+			name:        "non-RSA key",
+			data:        pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecPKCS8}),
+			wantErr:     true,
+			errContains: "not an RSA private key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Parse key
+			got, err := ParsePrivateKey(tt.data)
+
+			// Step 3 & 4: Verify results
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParsePrivateKey() error = nil, wantErr = true")
+					return
+				}
+				if !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParsePrivateKey() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParsePrivateKey() unexpected error = %v", err)
+			}
+			if !got.Equal(key) {
+				t.Error("ParsePrivateKey() returned a different key")
+			}
+		})
+	}
+}
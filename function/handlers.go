@@ -3,11 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/google/go-github/v81/github"
 )
 
 // TokenResponse is the successful response format.
@@ -15,6 +19,13 @@ type TokenResponse struct {
 	Token     string            `json:"token"`
 	ExpiresAt string            `json:"expires_at"`
 	Scopes    map[string]string `json:"scopes"`
+	Profile   string            `json:"profile,omitempty"`
+
+	// DroppedScopes are the optional scopes left out of the token, as "scope:level" entries.
+	DroppedScopes []string `json:"dropped_scopes,omitempty"`
+
+	// DowngradedScopes are the scopes issued at a lower level than requested, with the downgrading policy.
+	DowngradedScopes []ScopeDowngrade `json:"downgraded_scopes,omitempty"`
 }
 
 // ErrorResponse is the error response format.
@@ -24,7 +35,7 @@ type ErrorResponse struct {
 }
 
 // TokenHandler handles POST /token requests.
-func TokenHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) TokenHandler(w http.ResponseWriter, r *http.Request) {
 	// Create logger (only logs if invoked via tag URL)
 	logger := NewRequestLogger(r)
 
@@ -39,37 +50,18 @@ func TokenHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	// Extract OIDC token from Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		logger.LogValidationError("auth", "missing header")
-		logger.LogResponse(http.StatusUnauthorized, nil)
-		writeError(w, http.StatusUnauthorized, "missing Authorization header", nil)
-		return
-	}
-
-	parts := strings.SplitN(authHeader, " ", 2)
-	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		logger.LogValidationError("auth", "invalid format")
-		logger.LogResponse(http.StatusUnauthorized, nil)
-		writeError(w, http.StatusUnauthorized, "invalid Authorization header format", nil)
-		return
-	}
-
-	oidcToken := parts[1]
-
-	// Extract repository from OIDC token
-	repository, err := ExtractRepositoryFromOIDC(oidcToken)
-	if err != nil {
-		logger.LogValidationError("oidc", "invalid token")
-		logger.LogResponse(http.StatusUnauthorized, nil)
-		writeError(w, http.StatusUnauthorized, fmt.Sprintf("invalid OIDC token: %v", err), nil)
+	// Verify the OIDC token of the Authorization header and extract the repository
+	claims, ok := s.authenticate(ctx, w, r, logger)
+	if !ok {
 		return
 	}
-	logger.SetRepository(repository)
 
-	// Parse scopes from query parameters
+	// Parse scopes, the profile, the shorthand, the optional scopes and the lifetime from query parameters.
+	// Scopes can be named like in workflow permissions blocks, e.g. pull-requests=write.
 	scopes := make(map[string]string)
+	excluded := make(map[string]bool)
+	var profile, shorthand, optionalValue string
+	var lifetime time.Duration
 	for param, values := range r.URL.Query() {
 		if len(values) > 1 {
 			logger.LogValidationError("scope", fmt.Sprintf("duplicate: %s", param))
@@ -79,18 +71,95 @@ func TokenHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		permission := values[0]
 
+		switch param {
+		case profileParam:
+			profile = permission
+			continue
+		case permissionsParam:
+			if _, ok := shorthandLevels[permission]; !ok {
+				logger.LogValidationError("scope", fmt.Sprintf("invalid shorthand: %s", permission))
+				logger.LogResponse(http.StatusBadRequest, nil)
+				writeError(w,
+					http.StatusBadRequest,
+					fmt.Sprintf("invalid permissions '%s' (must be '%s' or '%s')", permission, ShorthandReadAll, ShorthandWriteAll),
+					nil)
+				return
+			}
+			shorthand = permission
+			continue
+		case optionalParam:
+			optionalValue = permission
+			continue
+		case lifetimeParam:
+			parsed, err := ParseTokenLifetime(permission)
+			if reason := s.config.revocationUnavailable(); err == nil && reason != "" {
+				err = fmt.Errorf("lifetime is not supported by this service: %s", reason)
+			}
+			if err != nil {
+				logger.LogValidationError("lifetime", err.Error())
+				logger.LogResponse(http.StatusBadRequest, nil)
+				writeError(w, http.StatusBadRequest, err.Error(), nil)
+				return
+			}
+			lifetime = parsed
+			continue
+		}
+
+		scopeID := normalizeScopeID(param)
+		if workflowOnlyPermissions[scopeID] {
+			continue
+		}
+		if _, exists := scopes[scopeID]; exists || excluded[scopeID] {
+			logger.LogValidationError("scope", fmt.Sprintf("duplicate: %s", scopeID))
+			logger.LogResponse(http.StatusBadRequest, nil)
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("duplicate scope '%s' in request", scopeID), nil)
+			return
+		}
+
 		// Validate permission value
+		if permission == permissionNone {
+			excluded[scopeID] = true
+			continue
+		}
 		if permission != "read" && permission != "write" {
 			logger.LogValidationError("scope", fmt.Sprintf("invalid permission: %s=%s", param, permission))
 			logger.LogResponse(http.StatusBadRequest, nil)
 			writeError(w,
 				http.StatusBadRequest,
-				fmt.Sprintf("invalid permission '%s' for scope '%s' (must be 'read' or 'write')", permission, param),
+				fmt.Sprintf("invalid permission '%s' for scope '%s' (must be 'read', 'write' or 'none')", permission, param),
 				nil)
 			return
 		}
 
-		scopes[param] = permission
+		scopes[scopeID] = permission
+	}
+
+	// Expand the profile
+	if profile != "" {
+		expanded, err := ExpandProfile(s.config, profile, scopes)
+		if err != nil {
+			logger.LogValidationError("profile", err.Error())
+			logger.LogResponse(http.StatusBadRequest, nil)
+			writeError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		scopes = expanded
+	}
+
+	// Expand the shorthand to the scopes allowed by the policies and the installation
+	tokenRepository := claims.TokenRepository()
+	var githubClient *github.Client
+	var installation *github.Installation
+	if shorthand != "" {
+		githubClient, installation, ok = s.lookupInstallation(ctx, w, logger, tokenRepository)
+		if !ok {
+			return
+		}
+		for scopeID, level := range ExpandShorthand(s.config, claims, shorthand, installation.GetPermissions()) {
+			if _, exists := scopes[scopeID]; !exists && !excluded[scopeID] {
+				scopes[scopeID] = level
+			}
+		}
 	}
 
 	// Require at least one scope
@@ -104,100 +173,277 @@ func TokenHandler(w http.ResponseWriter, r *http.Request) {
 	// Log incoming request
 	logger.LogRequest(scopes)
 
-	// Validate scopes
-	if err := ValidateScopes(scopes); err != nil {
+	// Optional scopes must be requested
+	optional, err := ParseOptionalScopes(optionalValue, scopes)
+	if err != nil {
 		logger.LogValidationError("scope", err.Error())
 		logger.LogResponse(http.StatusBadRequest, nil)
 		writeError(w, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
 
-	// Get GitHub App ID from environment
-	appID := os.Getenv("GITHUB_APP_ID")
-	if appID == "" {
-		logger.LogValidationError("config", "GITHUB_APP_ID not set")
-		logger.LogResponse(http.StatusInternalServerError, nil)
-		writeError(w, http.StatusInternalServerError, "GITHUB_APP_ID not configured", nil)
+	// Validate scopes; organization permissions are validated separately
+	repositoryScopes, organizationScopes := SplitOrganizationScopes(scopes)
+	if err := ValidateScopes(repositoryScopes); err != nil {
+		logger.LogValidationError("scope", err.Error())
+		logger.LogResponse(http.StatusBadRequest, nil)
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
-
-	// Get GCP project ID from environment
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID == "" {
-		// Try alternative environment variable
-		projectID = os.Getenv("GCP_PROJECT")
+	if err := ValidateOrganizationScopes(organizationScopes); err != nil {
+		logger.LogValidationError("scope", err.Error())
+		logger.LogResponse(http.StatusBadRequest, nil)
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
 	}
-	if projectID == "" {
-		logger.LogValidationError("config", "GCP project ID not set")
-		logger.LogResponse(http.StatusInternalServerError, nil)
-		writeError(w, http.StatusInternalServerError, "GCP project ID not configured", nil)
+
+	// Issue organization permissions only to the repositories and workflows of the organization policy
+	if err := ApplyOrganizationPolicy(s.config, claims, organizationScopes); err != nil {
+		logger.LogAudit(organizationAuditCategory, "denied", organizationScopeEntries(organizationScopes), err.Error())
+		writePolicyError(w, logger, "organization_policy", err)
 		return
 	}
 
-	// Fetch private key from Secret Manager
-	privateKey, err := GetPrivateKey(ctx, projectID)
+	// Apply the pull request event policies
+	scopes, downgrades, err := ApplyEventPolicy(s.config, claims, scopes)
 	if err != nil {
-		logger.LogGitHubAPICall("get_private_key", false, err.Error())
-		logger.LogResponse(http.StatusInternalServerError, nil)
-		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		writePolicyError(w, logger, "event_policy", err)
+		return
+	}
+	for _, downgrade := range downgrades {
+		logger.LogAudit("event_policy", "downgraded", []string{downgrade.Scope + ":" + downgrade.Requested},
+			fmt.Sprintf("issued as %s by %s for %s workflows", downgrade.Granted, downgrade.Policy, downgrade.EventName))
+	}
+
+	// Require an approved reusable workflow for restricted scopes
+	if err := ApplyWorkflowPolicy(s.config, claims, scopes); err != nil {
+		writePolicyError(w, logger, "workflow_policy", err)
 		return
 	}
-	logger.LogGitHubAPICall("get_private_key", true, "")
 
-	// Create JWT for GitHub App authentication
-	jwtToken, err := CreateJWT(privateKey, appID)
+	// Require a protected environment for privileged scopes
+	environment, err := ApplyEnvironmentPolicy(s.config, claims, scopes)
 	if err != nil {
-		logger.LogGitHubAPICall("create_jwt", false, err.Error())
-		logger.LogResponse(http.StatusInternalServerError, nil)
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create JWT: %v", err), nil)
+		writePolicyError(w, logger, "environment_policy", err)
+		return
+	}
+
+	// Get the installation of the repository
+	if installation == nil {
+		githubClient, installation, ok = s.lookupInstallation(ctx, w, logger, tokenRepository)
+		if !ok {
+			return
+		}
+	}
+	installationID := installation.GetID()
+
+	// Verify that the environment of privileged scopes has required reviewers
+	if environment != "" && s.config.VerifyEnvironmentProtection {
+		if err := s.verifyEnvironmentProtection(ctx, githubClient, installationID, tokenRepository, environment); err != nil {
+			var policyErr *PolicyError
+			if errors.As(err, &policyErr) {
+				writePolicyError(w, logger, "environment_policy", err)
+				return
+			}
+			logger.LogGitHubAPICall("get_environment", false, err.Error())
+			logger.LogResponse(http.StatusServiceUnavailable, nil)
+			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("GitHub API error: %v", err), nil)
+			return
+		}
+		logger.LogGitHubAPICall("get_environment", true, "")
+	}
+
+	// Hold scopes requiring a human approval until an approver decides.
+	// Optional scopes the installation lacks would be dropped, so they don't require approval.
+	issuable, _ := DropOptionalScopes(scopes, optional, installation.GetPermissions())
+	if requested := restrictedScopes(s.config.ApprovalScopes, issuable); len(requested) > 0 {
+		s.requestApproval(ctx, w, r, logger, claims, scopes, optional, downgrades, profile, lifetime, requested)
 		return
 	}
-	logger.LogGitHubAPICall("create_jwt", true, "")
 
-	// Create GitHub client with JWT
-	githubClient := NewGitHubClientWithJWT(jwtToken)
+	s.writeInstallationToken(ctx, w, logger, githubClient, installation, tokenRepository, scopes, optional, downgrades, profile, lifetime)
+}
+
+// authenticate verifies the OIDC token of the Authorization header and returns its claims.
+// On failure, the error response is written and ok is false.
+func (s *Server) authenticate(ctx context.Context, w http.ResponseWriter, r *http.Request, logger *RequestLogger) (*OIDCClaims, bool) {
+	// Extract OIDC token from Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		logger.LogValidationError("auth", "missing header")
+		logger.LogResponse(http.StatusUnauthorized, nil)
+		writeError(w, http.StatusUnauthorized, "missing Authorization header", nil)
+		return nil, false
+	}
+
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		logger.LogValidationError("auth", "invalid format")
+		logger.LogResponse(http.StatusUnauthorized, nil)
+		writeError(w, http.StatusUnauthorized, "invalid Authorization header format", nil)
+		return nil, false
+	}
+
+	oidcToken := parts[1]
+
+	// Verify OIDC token and extract the repository
+	claims, err := s.oidc.Verify(ctx, oidcToken)
+	if errors.Is(err, errRepositoryNotMapped) {
+		logger.LogValidationError("oidc", "repository not mapped")
+		logger.LogResponse(http.StatusForbidden, nil)
+		writeError(w, http.StatusForbidden, err.Error(), nil)
+		return nil, false
+	}
+	if err != nil {
+		logger.LogValidationError("oidc", "invalid token")
+		logger.LogResponse(http.StatusUnauthorized, nil)
+		writeError(w, http.StatusUnauthorized, fmt.Sprintf("invalid OIDC token: %v", err), nil)
+		return nil, false
+	}
+	logger.SetRepository(claims.Repository)
+	logger.SetRepositoryIDs(claims.RepositoryID, claims.RepositoryOwnerID)
+	return claims, true
+}
+
+// lookupInstallation loads the App keys and returns the App client and the installation of the repository,
+// authenticated with the first key GitHub accepts. On failure, the error response is written and ok is false.
+func (s *Server) lookupInstallation(ctx context.Context, w http.ResponseWriter, logger *RequestLogger, repository TokenRepository) (*github.Client, *github.Installation, bool) {
+	// Load the candidate App keys
+	keys, err := s.loadKeys(ctx)
+	if err != nil {
+		logger.LogGitHubAPICall("get_private_key", false, err.Error())
+		logger.LogResponse(http.StatusInternalServerError, nil)
+		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		return nil, nil, false
+	}
+	logger.LogGitHubAPICall("get_private_key", true, "")
 
-	// Get installation ID for repository
-	installationID, err := GetInstallationID(ctx, githubClient.Apps, repository)
+	// Get installation of the repository
+	var installation *github.Installation
+	githubClient, err := s.authenticateApp(keys, func(client *github.Client) error {
+		var err error
+		installation, err = GetInstallation(ctx, client.Apps, repository)
+		return err
+	})
 	if err != nil {
+		if errors.Is(err, errAppAuthentication) {
+			logger.LogGitHubAPICall("create_jwt", false, err.Error())
+			logger.LogResponse(http.StatusInternalServerError, nil)
+			writeError(w, http.StatusInternalServerError, err.Error(), nil)
+			return nil, nil, false
+		}
 		logger.LogGitHubAPICall("get_installation_id", false, err.Error())
-		if strings.Contains(err.Error(), "not installed") {
+		if strings.Contains(err.Error(), "not installed") || errors.Is(err, errRepositoryMismatch) {
 			logger.LogResponse(http.StatusForbidden, nil)
 			writeError(w, http.StatusForbidden, err.Error(), nil)
 		} else {
 			logger.LogResponse(http.StatusServiceUnavailable, nil)
 			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("GitHub API error: %v", err), nil)
 		}
-		return
+		return nil, nil, false
 	}
 	logger.LogGitHubAPICall("get_installation_id", true, "")
 
-	// Create installation token with requested scopes
-	token, err := CreateInstallationToken(ctx, githubClient.Apps, installationID, scopes)
+	// Only allowed owners and installations can get tokens
+	if err := ApplyInstallationPolicy(s.config, installation); err != nil {
+		writePolicyError(w, logger, "installation_policy", err)
+		return nil, nil, false
+	}
+
+	return githubClient, installation, true
+}
+
+// writeInstallationToken creates an installation token with the scopes, restricted to the repository,
+// and writes the token response with the granted scopes, the dropped optional scopes,
+// and the profile the scopes were expanded from, if any. Tokens with a lifetime, requested or capped
+// by MaxTokenLifetime, are scheduled for revocation, and expires_at reports the end of the lifetime.
+// Returns false if the token couldn't be created, after writing the error response.
+func (s *Server) writeInstallationToken(ctx context.Context, w http.ResponseWriter, logger *RequestLogger, githubClient *github.Client,
+	installation *github.Installation, repository TokenRepository, scopes map[string]string, optional []string, downgrades []ScopeDowngrade,
+	profile string, lifetime time.Duration) bool {
+	// Leave out optional scopes the installation lacks; an empty permission set would grant all of them
+	scopes, dropped := DropOptionalScopes(scopes, optional, installation.GetPermissions())
+	if len(scopes) == 0 {
+		logger.LogValidationError("scope", fmt.Sprintf("none granted (dropped: %v)", dropped))
+		logger.LogResponse(http.StatusForbidden, nil)
+		writeError(w, http.StatusForbidden, "none of the requested scopes are granted to the GitHub App installation", nil)
+		return false
+	}
+
+	// Create installation token with requested scopes, restricted to the repository
+	token, err := CreateInstallationToken(ctx, githubClient.Apps, s.config.GitHubAPIURL, installation.GetID(), repository, scopes, optional)
 	if err != nil {
 		logger.LogGitHubAPICall("create_installation_token", false, err.Error())
 		if strings.Contains(err.Error(), "insufficient permissions") ||
 			strings.Contains(err.Error(), "fewer scopes") ||
-			strings.Contains(err.Error(), "suspended") {
+			strings.Contains(err.Error(), "suspended") ||
+			errors.Is(err, errRepositoryMismatch) {
 			logger.LogResponse(http.StatusForbidden, nil)
 			writeError(w, http.StatusForbidden, err.Error(), nil)
 		} else {
 			logger.LogResponse(http.StatusServiceUnavailable, nil)
 			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("GitHub API error: %v", err), nil)
 		}
-		return
+		return false
 	}
 	logger.LogGitHubAPICall("create_installation_token", true, "")
 
+	// Schedule the revocation at the end of the lifetime; a token that can't be revoked in time isn't handed out
+	expiresAt, err := s.scheduleRevocation(ctx, token, repository.Name, effectiveTokenLifetime(s.config, lifetime))
+	if err != nil {
+		if revokeErr := s.revokeToken(context.WithoutCancel(ctx), token.GetToken()); revokeErr != nil {
+			log.Printf("Failed to revoke token of %s after failing to schedule its revocation: %v", repository.Name, revokeErr)
+		}
+		logger.LogValidationError("lifetime", err.Error())
+		logger.LogResponse(http.StatusInternalServerError, nil)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to schedule token revocation: %v", err), nil)
+		return false
+	}
+
+	// Report optional scopes GitHub didn't grant at the requested level as dropped
+	granted := ScopesOfPermissions(token.GetPermissions())
+	if ungranted := ungrantedScopes(scopes, granted); len(ungranted) > 0 {
+		dropped = append(dropped, ungranted...)
+		sort.Strings(dropped)
+	}
+
+	if organization := organizationScopeEntries(granted); len(organization) > 0 {
+		logger.LogAudit(organizationAuditCategory, "granted", organization, "")
+	}
+
+	// Report downgrades of scopes in the token; dropped scopes aren't downgraded
+	var downgraded []ScopeDowngrade
+	for _, downgrade := range downgrades {
+		if _, ok := granted[downgrade.Scope]; ok {
+			downgraded = append(downgraded, downgrade)
+		}
+	}
+
 	// Build response
 	response := TokenResponse{
-		Token:     token.GetToken(),
-		ExpiresAt: token.GetExpiresAt().Format(time.RFC3339),
-		Scopes:    scopes,
+		Token:            token.GetToken(),
+		ExpiresAt:        expiresAt.Format(time.RFC3339),
+		Scopes:           granted,
+		Profile:          profile,
+		DroppedScopes:    dropped,
+		DowngradedScopes: downgraded,
 	}
 
-	logger.LogResponse(http.StatusOK, scopes)
+	logger.LogResponse(http.StatusOK, granted)
 	writeJSON(w, http.StatusOK, response)
+	return true
+}
+
+// writePolicyError writes a 403 response for a policy denial, with the decision details of a *PolicyError.
+func writePolicyError(w http.ResponseWriter, logger *RequestLogger, policy string, err error) {
+	var details map[string]interface{}
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		details = policyErr.Details
+	}
+	logger.LogValidationError(policy, err.Error())
+	logger.LogResponse(http.StatusForbidden, nil)
+	writeError(w, http.StatusForbidden, err.Error(), details)
 }
 
 // writeJSON writes a JSON response.
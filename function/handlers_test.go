@@ -4,11 +4,17 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
 	"testing"
 )
 
+// testConfig returns a valid configuration for handler tests.
+func testConfig() *Config {
+	config := DefaultConfig()
+	config.GitHubAppID = "1"
+	return config
+}
+
 // TestTokenHandler_MethodNotAllowed tests that non-POST methods are rejected.
 // The token endpoint only accepts POST requests.
 //
@@ -27,7 +33,7 @@ func TestTokenHandler_MethodNotAllowed(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// Step 2: Call handler
-			TokenHandler(w, req)
+			NewServer(testConfig()).TokenHandler(w, req)
 
 			// Step 3: Verify 405 status
 			if w.Code != http.StatusMethodNotAllowed {
@@ -61,7 +67,7 @@ func TestTokenHandler_MissingAuthorizationHeader(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	// Step 2: Call handler
-	TokenHandler(w, req)
+	NewServer(testConfig()).TokenHandler(w, req)
 
 	// Step 3: Verify 401 status
 	if w.Code != http.StatusUnauthorized {
@@ -107,7 +113,7 @@ func TestTokenHandler_InvalidAuthorizationFormat(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// Step 2: Call handler
-			TokenHandler(w, req)
+			NewServer(testConfig()).TokenHandler(w, req)
 
 			// Step 3: Verify 401 status
 			if w.Code != http.StatusUnauthorized {
@@ -153,7 +159,7 @@ func TestTokenHandler_InvalidOIDCToken(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// Step 2: Call handler
-			TokenHandler(w, req)
+			NewServer(testConfig()).TokenHandler(w, req)
 
 			// Step 3: Verify 401 status
 			if w.Code != http.StatusUnauthorized {
@@ -184,7 +190,7 @@ func TestTokenHandler_InvalidOIDCToken(t *testing.T) {
 //  5. Verify response body contains "duplicate scope" error
 func TestTokenHandler_DuplicateScope(t *testing.T) {
 	// Step 1: Create valid JWT for test
-	token := createTestJWT(map[string]interface{}{"repository": "owner/repo"})
+	token := createTestJWT(map[string]interface{}{"repository": "owner/repo", "repository_id": "1", "repository_owner_id": "1"})
 
 	// Step 2: Create request with duplicate scope
 	req := httptest.NewRequest(http.MethodPost, "/token?contents=read&contents=write", nil)
@@ -192,7 +198,7 @@ func TestTokenHandler_DuplicateScope(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	// Step 3: Call handler
-	TokenHandler(w, req)
+	NewServer(testConfig()).TokenHandler(w, req)
 
 	// Step 4: Verify 400 status
 	if w.Code != http.StatusBadRequest {
@@ -221,7 +227,7 @@ func TestTokenHandler_DuplicateScope(t *testing.T) {
 //  5. Verify response body contains "invalid permission" error
 func TestTokenHandler_InvalidPermissionValue(t *testing.T) {
 	// Step 1: Create valid JWT for test
-	token := createTestJWT(map[string]interface{}{"repository": "owner/repo"})
+	token := createTestJWT(map[string]interface{}{"repository": "owner/repo", "repository_id": "1", "repository_owner_id": "1"})
 
 	tests := []struct {
 		name  string
@@ -243,7 +249,7 @@ func TestTokenHandler_InvalidPermissionValue(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			// Step 3: Call handler
-			TokenHandler(w, req)
+			NewServer(testConfig()).TokenHandler(w, req)
 
 			// Step 4: Verify 400 status
 			if w.Code != http.StatusBadRequest {
@@ -274,7 +280,7 @@ func TestTokenHandler_InvalidPermissionValue(t *testing.T) {
 //  5. Verify response body contains "at least one scope is required" error
 func TestTokenHandler_NoScopes(t *testing.T) {
 	// Step 1: Create valid JWT for test
-	token := createTestJWT(map[string]interface{}{"repository": "owner/repo"})
+	token := createTestJWT(map[string]interface{}{"repository": "owner/repo", "repository_id": "1", "repository_owner_id": "1"})
 
 	// Step 2: Create request without scopes
 	req := httptest.NewRequest(http.MethodPost, "/token", nil)
@@ -282,7 +288,7 @@ func TestTokenHandler_NoScopes(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	// Step 3: Call handler
-	TokenHandler(w, req)
+	NewServer(testConfig()).TokenHandler(w, req)
 
 	// Step 4: Verify 400 status
 	if w.Code != http.StatusBadRequest {
@@ -311,7 +317,7 @@ func TestTokenHandler_NoScopes(t *testing.T) {
 //  5. Verify response body contains "not in allowlist" error
 func TestTokenHandler_UnknownScope(t *testing.T) {
 	// Step 1: Create valid JWT for test
-	token := createTestJWT(map[string]interface{}{"repository": "owner/repo"})
+	token := createTestJWT(map[string]interface{}{"repository": "owner/repo", "repository_id": "1", "repository_owner_id": "1"})
 
 	// Step 2: Create request with unknown scope
 	req := httptest.NewRequest(http.MethodPost, "/token?unknown_scope=read", nil)
@@ -319,7 +325,7 @@ func TestTokenHandler_UnknownScope(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	// Step 3: Call handler
-	TokenHandler(w, req)
+	NewServer(testConfig()).TokenHandler(w, req)
 
 	// Step 4: Verify 400 status
 	if w.Code != http.StatusBadRequest {
@@ -348,7 +354,7 @@ func TestTokenHandler_UnknownScope(t *testing.T) {
 //  5. Verify response body contains "permission 'write' not allowed" error
 func TestTokenHandler_ReadOnlyScopeWithWrite(t *testing.T) {
 	// Step 1: Create valid JWT for test
-	token := createTestJWT(map[string]interface{}{"repository": "owner/repo"})
+	token := createTestJWT(map[string]interface{}{"repository": "owner/repo", "repository_id": "1", "repository_owner_id": "1"})
 
 	// Step 2: Create request with write on read-only scope
 	req := httptest.NewRequest(http.MethodPost, "/token?administration=write", nil)
@@ -356,7 +362,7 @@ func TestTokenHandler_ReadOnlyScopeWithWrite(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	// Step 3: Call handler
-	TokenHandler(w, req)
+	NewServer(testConfig()).TokenHandler(w, req)
 
 	// Step 4: Verify 400 status
 	if w.Code != http.StatusBadRequest {
@@ -567,7 +573,7 @@ func TestTokenHandler_ContentTypeHeader(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	// Step 2: Call handler
-	TokenHandler(w, req)
+	NewServer(testConfig()).TokenHandler(w, req)
 
 	// Step 3: Verify Content-Type header
 	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
@@ -585,7 +591,7 @@ func TestTokenHandler_ContentTypeHeader(t *testing.T) {
 //  4. Verify request passes auth parsing (may fail later due to missing config)
 func TestTokenHandler_BearerCaseInsensitive(t *testing.T) {
 	// Step 1: Create valid JWT for test
-	token := createTestJWT(map[string]interface{}{"repository": "owner/repo"})
+	token := createTestJWT(map[string]interface{}{"repository": "owner/repo", "repository_id": "1", "repository_owner_id": "1"})
 
 	// Step 2: Create request with lowercase "bearer"
 	req := httptest.NewRequest(http.MethodPost, "/token?contents=read", nil)
@@ -593,10 +599,10 @@ func TestTokenHandler_BearerCaseInsensitive(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	// Step 3: Call handler
-	TokenHandler(w, req)
+	NewServer(testConfig()).TokenHandler(w, req)
 
 	// Step 4: Verify request passes auth parsing
-	// (will fail later due to missing GITHUB_APP_ID, but that's OK)
+	// (will fail later due to missing GCP project ID, but that's OK)
 	if w.Code == http.StatusUnauthorized {
 		var resp ErrorResponse
 		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
@@ -608,31 +614,29 @@ func TestTokenHandler_BearerCaseInsensitive(t *testing.T) {
 	}
 }
 
-// TestTokenHandler_MissingGitHubAppID tests error when GITHUB_APP_ID is not configured.
-// The function should return 500 when required environment variable is missing.
+// TestTokenHandler_MissingProjectID tests error when no private key source is configured.
+// The function should return 500 instead of calling Secret Manager without a project.
 //
 // Test steps:
-//  1. Save and unset GITHUB_APP_ID environment variable
+//  1. Create configuration without GCP project ID and private key
 //  2. Create valid JWT token and POST request
 //  3. Call TokenHandler with the request
 //  4. Verify response status is 500 Internal Server Error
-//  5. Verify response contains "GITHUB_APP_ID not configured" error
-//  6. Restore original GITHUB_APP_ID value
-func TestTokenHandler_MissingGitHubAppID(t *testing.T) {
-	// Step 1: Unset GITHUB_APP_ID (t.Setenv restores original value on cleanup)
-	t.Setenv("GITHUB_APP_ID", "")
-	if err := os.Unsetenv("GITHUB_APP_ID"); err != nil {
-		t.Fatalf("failed to unset GITHUB_APP_ID: %v", err)
-	}
+//  5. Verify response contains "GCP project ID not configured" error
+func TestTokenHandler_MissingProjectID(t *testing.T) {
+	// Step 1: Create configuration without key source
+	config := testConfig()
+	config.ProjectID = ""
+	config.PrivateKey = ""
 
 	// Step 2: Create valid JWT and request
-	token := createTestJWT(map[string]interface{}{"repository": "owner/repo"})
+	token := createTestJWT(map[string]interface{}{"repository": "owner/repo", "repository_id": "1", "repository_owner_id": "1"})
 	req := httptest.NewRequest(http.MethodPost, "/token?contents=read", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
 
 	// Step 3: Call handler
-	TokenHandler(w, req)
+	NewServer(config).TokenHandler(w, req)
 
 	// Step 4: Verify 500 status
 	if w.Code != http.StatusInternalServerError {
@@ -645,7 +649,44 @@ func TestTokenHandler_MissingGitHubAppID(t *testing.T) {
 		t.Fatalf("failed to unmarshal response: %v", err)
 	}
 
-	if !strings.Contains(resp.Error, "GITHUB_APP_ID not configured") {
-		t.Errorf("TokenHandler() error = %v, want containing 'GITHUB_APP_ID not configured'", resp.Error)
+	if !strings.Contains(resp.Error, "GCP project ID not configured") {
+		t.Errorf("TokenHandler() error = %v, want containing 'GCP project ID not configured'", resp.Error)
+	}
+}
+
+// TestTokenHandler_UnmappedCIProject tests that tokens of CI projects without a mapped repository are rejected.
+//
+// Test steps:
+//  1. Configure a GitLab provider with a fake issuer and mint a token for an unmapped project
+//  2. Call TokenHandler with the token
+//  3. Verify response status is 403 Forbidden with the project in the error
+func TestTokenHandler_UnmappedCIProject(t *testing.T) {
+	// Step 1: Configure provider and mint token
+	gitlab := newTestOIDCIssuer(t)
+	config := testConfig()
+	config.OIDCProviders = []OIDCProviderConfig{
+		{Type: "gitlab", Issuer: gitlab.Issuer, Audience: "issuer", Repositories: map[string]string{"group/project": "owner/repo"}},
+	}
+	token, err := gitlab.Mint(map[string]interface{}{"aud": "issuer", "project_path": "group/other"})
+	if err != nil {
+		t.Fatalf("Mint() error = %v", err)
+	}
+
+	// Step 2: Call handler
+	req := httptest.NewRequest(http.MethodPost, "/token?contents=read", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	NewServer(config).TokenHandler(w, req)
+
+	// Step 3: Verify 403 status
+	if w.Code != http.StatusForbidden {
+		t.Errorf("TokenHandler() status = %v, want %v", w.Code, http.StatusForbidden)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !strings.Contains(resp.Error, "GitLab project 'group/other'") {
+		t.Errorf("TokenHandler() error = %v, want containing %q", resp.Error, "GitLab project 'group/other'")
 	}
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// HealthResponse is the response format of the health and readiness endpoints.
+// Readiness checks only report their status; failure details are logged, since the endpoint is unauthenticated.
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// VersionResponse is the response format of the version endpoint.
+type VersionResponse struct {
+	Module    string `json:"module"`
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// Statuses of readiness checks.
+const (
+	readinessCheckStatusOK      = "ok"
+	readinessCheckStatusFailed  = "failed"
+	readinessCheckStatusSkipped = "skipped"
+)
+
+// HealthzHandler handles GET /healthz requests.
+// It only reports that the process is alive and serving requests.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// ReadyzHandler handles GET /readyz requests.
+// It verifies that the service is able to issue tokens:
+// - Configuration is valid
+// - The candidate GitHub App private keys can be loaded
+// - A GitHub App JWT can be signed with every candidate key
+// - Optionally (ReadyzCheckGitHub), GitHub accepts a JWT of one of the keys for the /app endpoint
+//
+// Like token requests, the GitHub check falls back to the next candidate key on 401.
+// The response reports the status of each check; the errors of failed checks are only logged.
+// Checks run in order and stop at the first failure, since every check depends on the previous one.
+// The service is reported as not ready once a graceful shutdown has started.
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	// Report not ready while draining in-flight requests on shutdown
+	if s.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	checks := map[string]string{
+		"config":      readinessCheckStatusSkipped,
+		"private_key": readinessCheckStatusSkipped,
+		"jwt":         readinessCheckStatusSkipped,
+		"github":      readinessCheckStatusSkipped,
+	}
+	notReady := func(check string, err error) {
+		log.Printf("Readiness check %s failed: %v", check, err)
+		checks[check] = readinessCheckStatusFailed
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "not ready", Checks: checks})
+	}
+
+	// Check configuration
+	if err := s.config.Validate(); err != nil {
+		notReady("config", err)
+		return
+	}
+	checks["config"] = readinessCheckStatusOK
+
+	// Check that the private keys (or their KMS/PKCS#11 signer) can be loaded
+	keys, err := s.loadKeys(ctx)
+	if err != nil {
+		notReady("private_key", err)
+		return
+	}
+	checks["private_key"] = readinessCheckStatusOK
+
+	// Check that a JWT can be signed with every candidate key
+	for _, key := range keys {
+		if _, err := CreateJWT(key.Signer, s.config.GitHubAppID); err != nil {
+			notReady("jwt", fmt.Errorf("key %s: %w", key.ID, err))
+			return
+		}
+	}
+	checks["jwt"] = readinessCheckStatusOK
+
+	// Optionally check that GitHub accepts one of the keys
+	if s.config.ReadyzCheckGitHub {
+		_, err := s.authenticateApp(keys, func(client *github.Client) error {
+			_, _, err := client.Apps.Get(ctx, "")
+			return err
+		})
+		if err != nil {
+			notReady("github", fmt.Errorf("GitHub API error: %w", err))
+			return
+		}
+		checks["github"] = readinessCheckStatusOK
+	}
+
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ready", Checks: checks})
+}
+
+// VersionHandler handles GET /version requests.
+// It reports build information embedded into the binary by the Go toolchain.
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, GetVersion())
+}
+
+// GetVersion returns build information of the running binary.
+func GetVersion() VersionResponse {
+	version := VersionResponse{
+		Version:   "(devel)",
+		GoVersion: runtime.Version(),
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+
+	version.Module = info.Main.Path
+	if info.Main.Version != "" {
+		version.Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version.Revision = setting.Value
+		case "vcs.time":
+			version.Time = setting.Value
+		case "vcs.modified":
+			version.Modified = setting.Value == "true"
+		}
+	}
+
+	return version
+}
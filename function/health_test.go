@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestHealthzHandler tests that the liveness endpoint always reports ok.
+//
+// Test steps:
+//  1. Create GET request to /healthz
+//  2. Call HealthzHandler with the request
+//  3. Verify response status is 200 OK
+//  4. Verify response body contains status "ok"
+func TestHealthzHandler(t *testing.T) {
+	// Step 1: Create request
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	w := httptest.NewRecorder()
+
+	// Step 2: Call handler
+	HealthzHandler(w, req)
+
+	// Step 3: Verify 200 status
+	if w.Code != http.StatusOK {
+		t.Errorf("HealthzHandler() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	// Step 4: Verify status
+	var resp HealthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Status != "ok" {
+		t.Errorf("HealthzHandler() status = %v, want ok", resp.Status)
+	}
+}
+
+// TestHealthEndpoints_MethodNotAllowed tests that health endpoints reject non-GET methods.
+//
+// Test steps:
+//  1. Create POST request for each health endpoint
+//  2. Call the handler with the request
+//  3. Verify response status is 405 Method Not Allowed
+func TestHealthEndpoints_MethodNotAllowed(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"/healthz": HealthzHandler,
+		"/readyz":  NewServer(testConfig()).ReadyzHandler,
+		"/version": VersionHandler,
+	}
+
+	for path, handler := range handlers {
+		t.Run(path, func(t *testing.T) {
+			// Step 1: Create POST request
+			req := httptest.NewRequest(http.MethodPost, path, nil)
+			w := httptest.NewRecorder()
+
+			// Step 2: Call handler
+			handler(w, req)
+
+			// Step 3: Verify 405 status
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s status = %v, want %v", path, w.Code, http.StatusMethodNotAllowed)
+			}
+		})
+	}
+}
+
+// TestReadyzHandler_InvalidConfig tests that readiness fails when configuration is invalid.
+// Later checks must be skipped once a check fails.
+//
+// Test steps:
+//  1. Create configuration without GitHub App ID
+//  2. Call ReadyzHandler
+//  3. Verify response status is 503 Service Unavailable
+//  4. Verify config check reports the failure without its details and later checks are skipped
+func TestReadyzHandler_InvalidConfig(t *testing.T) {
+	// Step 1: Create invalid configuration
+	config := testConfig()
+	config.GitHubAppID = ""
+
+	// Step 2: Call handler
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	w := httptest.NewRecorder()
+	NewServer(config).ReadyzHandler(w, req)
+
+	// Step 3: Verify 503 status
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ReadyzHandler() status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+
+	// Step 4: Verify checks
+	var resp HealthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Checks["config"] != readinessCheckStatusFailed || strings.Contains(w.Body.String(), "GITHUB_APP_ID") {
+		t.Errorf("ReadyzHandler() config check = %v, want %v without details", resp.Checks["config"], readinessCheckStatusFailed)
+	}
+	for _, check := range []string{"private_key", "jwt", "github"} {
+		if resp.Checks[check] != readinessCheckStatusSkipped {
+			t.Errorf("ReadyzHandler() %s check = %v, want %v", check, resp.Checks[check], readinessCheckStatusSkipped)
+		}
+	}
+}
+
+// TestVersionHandler tests that the version endpoint reports build information.
+//
+// Test steps:
+//  1. Create GET request to /version
+//  2. Call VersionHandler with the request
+//  3. Verify response status is 200 OK
+//  4. Verify Go version matches the running toolchain
+func TestVersionHandler(t *testing.T) {
+	// Step 1: Create request
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	w := httptest.NewRecorder()
+
+	// Step 2: Call handler
+	VersionHandler(w, req)
+
+	// Step 3: Verify 200 status
+	if w.Code != http.StatusOK {
+		t.Errorf("VersionHandler() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	// Step 4: Verify Go version
+	var resp VersionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.GoVersion != runtime.Version() {
+		t.Errorf("VersionHandler() go_version = %v, want %v", resp.GoVersion, runtime.Version())
+	}
+	if resp.Version == "" {
+		t.Error("VersionHandler() version is empty")
+	}
+}
+
+// TestServer_Routes tests that the router dispatches requests to the right handler.
+//
+// Test steps:
+//  1. Create router
+//  2. Send requests to health, version and token paths
+//  3. Verify each response comes from the expected handler
+func TestServer_Routes(t *testing.T) {
+	// Step 1: Create router
+	router := NewServer(testConfig()).Routes()
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{method: http.MethodGet, path: "/healthz", wantStatus: http.StatusOK},
+		{method: http.MethodGet, path: "/version", wantStatus: http.StatusOK},
+		// Token endpoint only accepts POST, on any other path
+		{method: http.MethodGet, path: "/token", wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, path: "/token", wantStatus: http.StatusUnauthorized},
+		{method: http.MethodPost, path: "/", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			// Step 2: Send request
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// Step 3: Verify status
+			if w.Code != tt.wantStatus {
+				t.Errorf("router %s %s status = %v, want %v", tt.method, tt.path, w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
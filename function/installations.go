@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// Webhook outcomes of installation events.
+const (
+	webhookIgnored     = "ignored"
+	webhookAllowed     = "allowed"
+	webhookDenied      = "denied"
+	webhookUninstalled = "uninstalled"
+)
+
+// installationWebhookActions are the installation event actions after which an installation can get tokens.
+var installationWebhookActions = map[string]bool{
+	"created":                  true,
+	"new_permissions_accepted": true,
+	"unsuspend":                true,
+}
+
+// WebhookResponse is the response of App webhooks.
+type WebhookResponse struct {
+	Event          string `json:"event"`
+	Result         string `json:"result"`
+	InstallationID int64  `json:"installation_id,omitempty"`
+}
+
+// installationAllowed reports whether the installation can get tokens: its ID is in AllowedInstallations,
+// or its account login (case-insensitive) or account ID is in AllowedOwners.
+// Every installation is allowed if no allowlist is configured.
+func installationAllowed(config *Config, installation *github.Installation) bool {
+	if len(config.AllowedOwners) == 0 && len(config.AllowedInstallations) == 0 {
+		return true
+	}
+	for _, id := range config.AllowedInstallations {
+		if id == installation.GetID() {
+			return true
+		}
+	}
+	account := installation.GetAccount()
+	accountID := strconv.FormatInt(account.GetID(), 10)
+	for _, owner := range config.AllowedOwners {
+		if owner == accountID || account.GetLogin() != "" && strings.EqualFold(owner, account.GetLogin()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyInstallationPolicy checks that the App installation of the repository is allowed by
+// AllowedOwners and AllowedInstallations. Returns a *PolicyError otherwise.
+func ApplyInstallationPolicy(config *Config, installation *github.Installation) error {
+	if installationAllowed(config, installation) {
+		return nil
+	}
+	account := installation.GetAccount()
+	return &PolicyError{
+		Message: fmt.Sprintf("GitHub App installation %d on account %s (ID %d) is not allowed to get tokens",
+			installation.GetID(), account.GetLogin(), account.GetID()),
+		Details: map[string]interface{}{
+			"installation_id": installation.GetID(),
+			"account":         account.GetLogin(),
+			"account_id":      account.GetID(),
+		},
+	}
+}
+
+// WebhookHandler handles POST /webhook requests, the App webhooks signed with GitHubWebhookSecret.
+// Installations not allowed by the allowlists are logged when created, unsuspended or granted new permissions,
+// and deleted if UninstallUnknownInstallations is enabled. Other events are acknowledged and ignored.
+func (s *Server) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	logger := NewRequestLogger(r)
+
+	payload, err := github.ValidatePayload(r, []byte(s.config.GitHubWebhookSecret))
+	if err != nil {
+		logger.LogValidationError("webhook", err.Error())
+		logger.LogResponse(http.StatusUnauthorized, nil)
+		writeError(w, http.StatusUnauthorized, fmt.Sprintf("invalid webhook signature: %v", err), nil)
+		return
+	}
+
+	eventType := github.WebHookType(r)
+	response := WebhookResponse{Event: eventType, Result: webhookIgnored}
+	if eventType != "installation" {
+		logger.LogResponse(http.StatusOK, nil)
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		logger.LogValidationError("webhook", err.Error())
+		logger.LogResponse(http.StatusBadRequest, nil)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid webhook payload: %v", err), nil)
+		return
+	}
+	installationEvent, _ := event.(*github.InstallationEvent)
+	installation := installationEvent.GetInstallation()
+	response.InstallationID = installation.GetID()
+	if installation.GetID() == 0 || !installationWebhookActions[installationEvent.GetAction()] {
+		logger.LogResponse(http.StatusOK, nil)
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	if installationAllowed(s.config, installation) {
+		response.Result = webhookAllowed
+		logger.LogResponse(http.StatusOK, nil)
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	account := installation.GetAccount()
+	response.Result = webhookDenied
+	if !s.config.UninstallUnknownInstallations {
+		log.Printf("GitHub App installation %d on account %s (ID %d) is not allowed to get tokens",
+			installation.GetID(), account.GetLogin(), account.GetID())
+		logger.LogResponse(http.StatusOK, nil)
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	if err := s.uninstall(ctx, installation.GetID()); err != nil {
+		logger.LogGitHubAPICall("delete_installation", false, err.Error())
+		logger.LogResponse(http.StatusServiceUnavailable, nil)
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("failed to uninstall GitHub App installation %d: %v", installation.GetID(), err), nil)
+		return
+	}
+	logger.LogGitHubAPICall("delete_installation", true, "")
+	log.Printf("Uninstalled GitHub App installation %d on account %s (ID %d): not allowed to get tokens",
+		installation.GetID(), account.GetLogin(), account.GetID())
+
+	response.Result = webhookUninstalled
+	logger.LogResponse(http.StatusOK, nil)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// uninstall deletes the App installation, authenticated as the App.
+// Installations already deleted are ignored.
+func (s *Server) uninstall(ctx context.Context, installationID int64) error {
+	keys, err := s.loadKeys(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = s.authenticateApp(keys, func(client *github.Client) error {
+		resp, err := client.Apps.DeleteInstallation(ctx, installationID)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	})
+	return err
+}
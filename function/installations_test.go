@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// testWebhookSecret is the App webhook secret of webhook tests.
+const testWebhookSecret = "webhook-secret"
+
+// TestApplyInstallationPolicy tests the owner and installation allowlists.
+//
+// Test steps:
+//  1. Configure the allowlists of the test case
+//  2. Apply the installation policy to an installation 42 on account "Owner" (ID 7)
+//  3. Verify the installation is allowed or denied with a *PolicyError
+func TestApplyInstallationPolicy(t *testing.T) {
+	installation := &github.Installation{
+		ID:      github.Ptr(int64(42)),
+		Account: &github.User{Login: github.Ptr("Owner"), ID: github.Ptr(int64(7))},
+	}
+
+	tests := []struct {
+		name          string
+		owners        []string
+		installations []int64
+		wantErr       bool
+	}{
+		{name: "no allowlist"},
+		{name: "owner login", owners: []string{"other", "owner"}},
+		{name: "owner ID", owners: []string{"7"}},
+		{name: "installation ID", owners: []string{"other"}, installations: []int64{42}},
+		{name: "other owner", owners: []string{"other", "8"}, wantErr: true},
+		{name: "other installation", installations: []int64{43}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Configure allowlists
+			config := testConfig()
+			config.AllowedOwners = tt.owners
+			config.AllowedInstallations = tt.installations
+
+			// Step 2: Apply policy
+			err := ApplyInstallationPolicy(config, installation)
+
+			// Step 3: Verify decision
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("ApplyInstallationPolicy() error = %v, want nil", err)
+				}
+				return
+			}
+			policyErr, ok := err.(*PolicyError)
+			if !ok {
+				t.Fatalf("ApplyInstallationPolicy() error = %v, want *PolicyError", err)
+			}
+			if want := "GitHub App installation 42 on account Owner (ID 7) is not allowed to get tokens"; policyErr.Message != want {
+				t.Errorf("ApplyInstallationPolicy() error = %v, want %q", policyErr.Message, want)
+			}
+			if policyErr.Details["installation_id"] != int64(42) || policyErr.Details["account_id"] != int64(7) {
+				t.Errorf("ApplyInstallationPolicy() details = %v, want installation 42 and account 7", policyErr.Details)
+			}
+		})
+	}
+}
+
+// TestE2E_InstallationAllowlist tests token requests of installations outside the allowlist.
+//
+// Test steps:
+//  1. Start the service allowing the test case owners only
+//  2. Request a token for owner/repo
+//  3. Verify the response status and error
+func TestE2E_InstallationAllowlist(t *testing.T) {
+	tests := []struct {
+		name       string
+		owners     []string
+		wantStatus int
+	}{
+		{name: "allowed owner ID", owners: []string{strconv.FormatInt(FakeOwnerID("owner"), 10)}, wantStatus: http.StatusOK},
+		{name: "other owner", owners: []string{"someone-else"}, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Start environment
+			env := newE2EEnvironmentWithConfig(t, func(config *Config) { config.AllowedOwners = tt.owners })
+
+			// Step 2: Request token
+			status, body := env.requestToken(t, "owner/repo", "contents=read")
+
+			// Step 3: Verify response
+			if status != tt.wantStatus {
+				t.Fatalf("status = %v, want %v (body: %v)", status, tt.wantStatus, body)
+			}
+			if errMsg, _ := body["error"].(string); status == http.StatusForbidden && !strings.Contains(errMsg, "is not allowed to get tokens") {
+				t.Errorf("error = %v, want containing 'is not allowed to get tokens'", body["error"])
+			}
+		})
+	}
+}
+
+// postWebhook posts a webhook event signed with secret to the service and returns the response status and body.
+func postWebhook(t *testing.T, serviceURL, secret, event string, payload interface{}) (int, WebhookResponse) {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("failed to encode payload: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+
+	req, err := http.NewRequest(http.MethodPost, serviceURL+"/webhook", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var response WebhookResponse
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response
+}
+
+// TestWebhookHandler_UninstallUnknownInstallations tests that installations of unknown owners are uninstalled
+// when their installation webhook is received.
+//
+// Test steps:
+//  1. Start the service allowing "owner" only, with a second installation on "intruder"
+//  2. Post the webhook of the test case
+//  3. Verify the response status and result
+//  4. Verify the intruder installation is uninstalled after its creation webhook only
+func TestWebhookHandler_UninstallUnknownInstallations(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironmentWithConfig(t, func(config *Config) {
+		config.AllowedOwners = []string{"owner"}
+		config.GitHubWebhookSecret = testWebhookSecret
+		config.UninstallUnknownInstallations = true
+	})
+	env.github.AddInstallation(&FakeInstallation{ID: 99, Account: "intruder", Permissions: map[string]string{"contents": "read"}})
+	installationEvent := func(action string, id int64, login string) map[string]interface{} {
+		return map[string]interface{}{
+			"action":       action,
+			"installation": map[string]interface{}{"id": id, "account": map[string]interface{}{"login": login, "id": FakeOwnerID(login)}},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		secret        string
+		event         string
+		payload       interface{}
+		wantStatus    int
+		wantResult    string
+		wantInstalled bool
+	}{
+		{name: "invalid signature", secret: "wrong", event: "installation", payload: installationEvent("created", 99, "intruder"), wantStatus: http.StatusUnauthorized, wantInstalled: true},
+		{name: "ping", event: "ping", payload: map[string]interface{}{"zen": "Keep it logically awesome."}, wantStatus: http.StatusOK, wantResult: webhookIgnored, wantInstalled: true},
+		{name: "allowed installation", event: "installation", payload: installationEvent("created", e2eInstallationID, "owner"), wantStatus: http.StatusOK, wantResult: webhookAllowed, wantInstalled: true},
+		{name: "suspended installation", event: "installation", payload: installationEvent("suspend", 99, "intruder"), wantStatus: http.StatusOK, wantResult: webhookIgnored, wantInstalled: true},
+		{name: "unknown installation", event: "installation", payload: installationEvent("created", 99, "intruder"), wantStatus: http.StatusOK, wantResult: webhookUninstalled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Post webhook
+			secret := tt.secret
+			if secret == "" {
+				secret = testWebhookSecret
+			}
+			status, response := postWebhook(t, env.service.URL, secret, tt.event, tt.payload)
+
+			// Step 3: Verify response
+			if status != tt.wantStatus || response.Result != tt.wantResult {
+				t.Errorf("webhook = %v %+v, want %v with result %q", status, response, tt.wantStatus, tt.wantResult)
+			}
+
+			// Step 4: Verify installation
+			oidcStatus, body := env.requestToken(t, "intruder/repo", "contents=read")
+			if installed := !strings.Contains(body["error"].(string), "not installed"); installed != tt.wantInstalled {
+				t.Errorf("token request = %v %v, want installed = %v", oidcStatus, body, tt.wantInstalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/iterator"
+)
+
+// privateKeySecretName is the Secret Manager secret holding the GitHub App private key.
+const privateKeySecretName = "github-app-private-key"
+
+// AppKey is a candidate GitHub App private key.
+type AppKey struct {
+	// ID identifies the key in its source (e.g., the Secret Manager version name).
+	ID string `json:"id"`
+
+	// Fingerprint is the SHA-256 fingerprint of the public key, as shown in the GitHub App settings.
+	Fingerprint string `json:"fingerprint"`
+
+	Signer crypto.Signer `json:"-"`
+}
+
+// NewAppKey creates an AppKey for signer, computing its fingerprint.
+func NewAppKey(id string, signer crypto.Signer) (AppKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return AppKey{}, fmt.Errorf("failed to encode public key of key %s: %w", id, err)
+	}
+	sum := sha256.Sum256(der)
+
+	return AppKey{
+		ID:          id,
+		Fingerprint: "SHA256:" + base64.StdEncoding.EncodeToString(sum[:]),
+		Signer:      signer,
+	}, nil
+}
+
+// ParsePrivateKeys parses one or more concatenated PEM-encoded RSA private keys, in order.
+// Every key is parsed with ParsePrivateKey.
+func ParsePrivateKeys(data []byte) ([]*rsa.PrivateKey, error) {
+	var keys []*rsa.PrivateKey
+	rest := data
+	for {
+		block, next := pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		key, err := ParsePrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", len(keys)+1, err)
+		}
+		keys = append(keys, key)
+		rest = next
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("failed to decode PEM block from private key")
+	}
+
+	return keys, nil
+}
+
+// GetPrivateKeys fetches up to limit of the newest enabled versions of the GitHub App private key
+// from GCP Secret Manager, newest first.
+// Versions that can't be parsed are skipped, so that a bad upload doesn't break issuance.
+func GetPrivateKeys(ctx context.Context, projectID string, limit int) (keys []AppKey, err error) {
+	client, err := secretmanager.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Secret Manager client: %w", err)
+	}
+	defer func() {
+		if closeErr := client.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close Secret Manager client: %w", closeErr)
+		}
+	}()
+
+	versions, err := listEnabledSecretVersions(ctx, client, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, version := range newestSecretVersions(versions, limit) {
+		result, err := client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: version})
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve private key from Secret Manager: %w", err)
+		}
+
+		privateKey, err := ParsePrivateKey(result.Payload.Data)
+		if err != nil {
+			log.Printf("Skipping invalid private key version %s: %v", version, err)
+			continue
+		}
+		key, err := NewAppKey(version, privateKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("failed to retrieve private key from Secret Manager: no enabled valid versions of secret %s", privateKeySecretName)
+	}
+
+	return keys, nil
+}
+
+// listEnabledSecretVersions returns the names of the enabled versions of the private key secret.
+func listEnabledSecretVersions(ctx context.Context, client *secretmanager.Client, projectID string) ([]string, error) {
+	var versions []string
+	it := client.ListSecretVersions(ctx, &secretmanagerpb.ListSecretVersionsRequest{
+		Parent: fmt.Sprintf("projects/%s/secrets/%s", projectID, privateKeySecretName),
+		Filter: "state:ENABLED",
+	})
+	for {
+		version, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list private key versions in Secret Manager: %w", err)
+		}
+		versions = append(versions, version.GetName())
+	}
+	return versions, nil
+}
+
+// newestSecretVersions returns up to limit version names ordered by version number, newest first.
+func newestSecretVersions(versions []string, limit int) []string {
+	number := func(version string) int {
+		n, _ := strconv.Atoi(version[strings.LastIndex(version, "/")+1:])
+		return n
+	}
+
+	sorted := append([]string(nil), versions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return number(sorted[i]) > number(sorted[j])
+	})
+
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParsePrivateKeys tests parsing of one or more concatenated PEM-encoded private keys.
+//
+// Test steps:
+//  1. Encode test keys and concatenate them
+//  2. Call ParsePrivateKeys
+//  3. Verify keys are returned in order
+//  4. Verify an invalid key anywhere fails parsing
+func TestParsePrivateKeys(t *testing.T) {
+	// Step 1: Encode keys
+	first := generateTestRSAKey(t)
+	second := generateTestRSAKey(t)
+	firstPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(first)}))
+	secondPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(second)}))
+
+	// Step 2 & 3: Parse concatenated keys
+	keys, err := ParsePrivateKeys([]byte(firstPEM + "\n" + secondPEM))
+	if err != nil {
+		t.Fatalf("ParsePrivateKeys() error = %v", err)
+	}
+	if len(keys) != 2 || !keys[0].Equal(first) || !keys[1].Equal(second) {
+		t.Errorf("ParsePrivateKeys() returned %d keys, want the 2 keys in order", len(keys))
+	}
+
+	// Step 4: Verify invalid input
+	invalidPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("garbage")}))
+	tests := []struct {
+		name        string
+		data        string
+		errContains string
+	}{
+		{name: "not PEM", data: "not a key", errContains: "failed to decode PEM block"},
+		{name: "invalid second key", data: firstPEM + invalidPEM, errContains: "key 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePrivateKeys([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("ParsePrivateKeys() error = %v, want containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+// TestNewAppKey tests the key fingerprint, which must match the GitHub App settings format.
+//
+// Test steps:
+//  1. Create AppKeys for two different keys
+//  2. Verify the fingerprint format
+//  3. Verify fingerprints are stable and distinct
+func TestNewAppKey(t *testing.T) {
+	// Step 1: Create keys
+	key := generateTestRSAKey(t)
+	appKey, err := NewAppKey("inline/1", key)
+	if err != nil {
+		t.Fatalf("NewAppKey() error = %v", err)
+	}
+	otherKey, err := NewAppKey("inline/2", generateTestRSAKey(t))
+	if err != nil {
+		t.Fatalf("NewAppKey() error = %v", err)
+	}
+
+	// Step 2: Verify format (SHA256:<base64 of 32 bytes>)
+	if !strings.HasPrefix(appKey.Fingerprint, "SHA256:") || len(appKey.Fingerprint) != len("SHA256:")+44 {
+		t.Errorf("Fingerprint = %v, want SHA256:<base64>", appKey.Fingerprint)
+	}
+
+	// Step 3: Verify stability and uniqueness
+	again, _ := NewAppKey("inline/1", key)
+	if again.Fingerprint != appKey.Fingerprint {
+		t.Errorf("Fingerprint not stable: %v != %v", again.Fingerprint, appKey.Fingerprint)
+	}
+	if otherKey.Fingerprint == appKey.Fingerprint {
+		t.Error("different keys have the same fingerprint")
+	}
+}
+
+// TestNewestSecretVersions tests selection of candidate Secret Manager versions.
+//
+// Test steps:
+//  1. Call newestSecretVersions with unordered version names
+//  2. Verify the newest versions are returned first, up to the limit
+func TestNewestSecretVersions(t *testing.T) {
+	versions := []string{
+		"projects/p/secrets/s/versions/2",
+		"projects/p/secrets/s/versions/10",
+		"projects/p/secrets/s/versions/9",
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "limit 2",
+			limit: 2,
+			want:  []string{"projects/p/secrets/s/versions/10", "projects/p/secrets/s/versions/9"},
+		},
+		{
+			name:  "limit above count",
+			limit: 5,
+			want:  []string{"projects/p/secrets/s/versions/10", "projects/p/secrets/s/versions/9", "projects/p/secrets/s/versions/2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Select versions
+			got := newestSecretVersions(versions, tt.limit)
+
+			// Step 2: Verify order and limit
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newestSecretVersions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -14,6 +14,8 @@ type RequestLogger struct {
 	enabled   bool
 	startTime time.Time
 	repo      string
+	repoID    int64
+	ownerID   int64
 	scopes    int
 }
 
@@ -32,6 +34,13 @@ func (l *RequestLogger) SetRepository(repo string) {
 	l.repo = repo
 }
 
+// SetRepositoryIDs sets the repository and owner IDs for logging context.
+// Log entries carry them when set, since the repository name can change or be reused.
+func (l *RequestLogger) SetRepositoryIDs(repoID, ownerID int64) {
+	l.repoID = repoID
+	l.ownerID = ownerID
+}
+
 // SetScopesCount sets the number of scopes for logging context.
 func (l *RequestLogger) SetScopesCount(count int) {
 	l.scopes = count
@@ -112,8 +121,31 @@ func (l *RequestLogger) LogResponse(statusCode int, grantedScopes map[string]str
 	l.logJSON(entry)
 }
 
+// LogAudit logs an audit decision of the category, e.g. organization permissions granted to a repository.
+// Unlike the other entries, audit entries are logged for every request, not only via tag URLs.
+func (l *RequestLogger) LogAudit(category string, decision string, scopes []string, detail string) {
+	entry := map[string]interface{}{
+		"event":    "audit",
+		"category": category,
+		"repo":     l.repo,
+		"decision": decision,
+		"scopes":   scopes,
+	}
+	if detail != "" {
+		entry["detail"] = detail
+	}
+
+	l.logJSON(entry)
+}
+
 // logJSON outputs a structured JSON log entry.
 func (l *RequestLogger) logJSON(entry map[string]interface{}) {
+	if l.repoID != 0 {
+		entry["repo_id"] = l.repoID
+	}
+	if l.ownerID != 0 {
+		entry["owner_id"] = l.ownerID
+	}
 	entry["timestamp"] = time.Now().UTC().Format(time.RFC3339)
 	data, err := json.Marshal(entry)
 	if err != nil {
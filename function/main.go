@@ -1,32 +1,100 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
 
-func init() {
-	// Validate required environment variables at startup
-	appID := os.Getenv("GITHUB_APP_ID")
-	if appID == "" {
-		log.Fatal("GITHUB_APP_ID environment variable is required")
-	}
+// usage describes the supported commands.
+const usage = `Usage: token-issuer [command]
 
-	// Register HTTP function
-	functions.HTTP("TokenHandler", TokenHandler)
-}
+Commands:
+  (none)        Serve through the Functions Framework (Cloud Run)
+  serve         Serve with a standalone HTTP server (Kubernetes, VM)
+  dev           Serve offline with a fake GitHub, a fake OIDC issuer and a throwaway App key
+  print-config  Print the redacted configuration and validation errors
+  admin         Administrative tasks (rotate-key)
+`
 
 func main() {
-	// Start the Functions Framework
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	// Load configuration from the optional config file and environment variables
+	config, err := LoadConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+
+	switch command {
+	case "", "serve":
+		// Validate configuration at startup, reporting all errors together
+		config.Mode = command
+		if err := config.Validate(); err != nil {
+			log.Fatalf("invalid configuration:\n%v", err)
+		}
+
+	case "dev":
+		// Run offline against a fake GitHub and a fake OIDC issuer
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		err := RunDev(ctx, config, os.Args[2:])
+		stop()
+		if err != nil {
+			log.Fatalf("dev mode: %v", err)
+		}
+		return
+
+	case "admin":
+		// Run an administrative task and exit
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		err := RunAdmin(ctx, config, os.Args[2:])
+		stop()
+		if err != nil {
+			log.Fatalf("admin: %v", err)
+		}
+		return
+
+	case "print-config":
+		// Print redacted configuration for debugging
+		fmt.Print(config)
+		if err := config.Validate(); err != nil {
+			log.Fatalf("invalid configuration:\n%v", err)
+		}
+		return
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	server := NewServer(config)
+
+	if command == "serve" {
+		// Serve until SIGTERM/SIGINT, then drain in-flight requests
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		go server.RunRevocations(ctx)
+		err := RunStandalone(ctx, config, server)
+		stop()
+		if err != nil {
+			log.Fatalf("standalone server: %v", err)
+		}
+		return
 	}
 
-	if err := funcframework.Start(port); err != nil {
+	// Register HTTP function (token endpoint with health, readiness and version endpoints)
+	functions.HTTP("TokenHandler", server.Routes().ServeHTTP)
+
+	// Start the Functions Framework
+	if err := funcframework.Start(config.Port); err != nil {
 		log.Fatalf("funcframework.Start: %v", err)
 	}
 }
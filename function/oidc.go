@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// GitHubActionsIssuer is the OIDC token issuer of GitHub Actions on github.com.
+const GitHubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+// JWKS caching: keys are refetched after jwksTTL, or on an unknown key ID at most every jwksMinRefresh.
+const (
+	jwksTTL        = time.Hour
+	jwksMinRefresh = time.Minute
+)
+
+// errRepositoryNotMapped is returned when a CI project has no GitHub repository in the mapping table.
+var errRepositoryNotMapped = errors.New("no GitHub repository mapped")
+
+// OIDCProviderConfig configures an OIDC token issuer whose tokens are accepted.
+type OIDCProviderConfig struct {
+	// Type is the CI provider: github-actions, gitlab, buildkite or circleci.
+	Type string `yaml:"type"`
+
+	// Issuer is the "iss" claim and the base URL of the discovery document,
+	// e.g. https://gitlab.com, https://agent.buildkite.com or https://oidc.circleci.com/org/<org-id>.
+	// Defaults to GitHubActionsIssuer for github-actions.
+	Issuer string `yaml:"issuer"`
+
+	// Audience is the required "aud" claim.
+	Audience string `yaml:"audience"`
+
+	// Repositories maps CI project identifiers to GitHub repositories in "owner/repo" format:
+	// the GitLab project_path, the Buildkite "organization_slug/pipeline_slug" or the CircleCI project ID.
+	// Required for all types except github-actions, whose tokens have a repository claim.
+	Repositories map[string]string `yaml:"repositories"`
+}
+
+// validate checks the provider configuration.
+func (c *OIDCProviderConfig) validate() error {
+	if _, ok := ciProviders[c.Type]; !ok {
+		return fmt.Errorf("unknown type '%s' (must be github-actions, gitlab, buildkite or circleci)", c.Type)
+	}
+	if c.Issuer == "" && c.Type != "github-actions" {
+		return fmt.Errorf("issuer is required")
+	}
+	if c.Issuer != "" {
+		if parsed, err := url.Parse(c.Issuer); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("issuer '%s' must be an absolute URL", c.Issuer)
+		}
+	}
+	if c.Audience == "" {
+		return fmt.Errorf("audience is required")
+	}
+	if c.Type == "github-actions" && len(c.Repositories) > 0 {
+		return fmt.Errorf("repositories mapping is not supported for github-actions (the repository claim is used)")
+	}
+	if c.Type != "github-actions" && len(c.Repositories) == 0 {
+		return fmt.Errorf("repositories mapping is required for %s", c.Type)
+	}
+	for projectID, repository := range c.Repositories {
+		if owner, name, ok := strings.Cut(repository, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("repository '%s' of '%s' must be in owner/repo format", repository, projectID)
+		}
+	}
+	return nil
+}
+
+// hasGitHubActionsProvider reports whether GitHub Actions tokens are verified by one of the providers.
+func hasGitHubActionsProvider(configs []OIDCProviderConfig) bool {
+	for _, config := range configs {
+		if config.Type == "github-actions" {
+			return true
+		}
+	}
+	return false
+}
+
+// ciProvider extracts the project identifier of a CI provider's OIDC token.
+type ciProvider struct {
+	// project describes the project identifier in error messages.
+	project string
+
+	// projectID returns the project identifier from the claims.
+	projectID func(claims jwt.MapClaims) string
+}
+
+// ciProviders are the supported CI providers by type.
+var ciProviders = map[string]ciProvider{
+	"github-actions": {
+		project:   "repository",
+		projectID: func(claims jwt.MapClaims) string { return claimString(claims, "repository") },
+	},
+	"gitlab": {
+		project:   "GitLab project",
+		projectID: func(claims jwt.MapClaims) string { return claimString(claims, "project_path") },
+	},
+	"buildkite": {
+		project: "Buildkite pipeline",
+		projectID: func(claims jwt.MapClaims) string {
+			organization, pipeline := claimString(claims, "organization_slug"), claimString(claims, "pipeline_slug")
+			if organization == "" || pipeline == "" {
+				return ""
+			}
+			return organization + "/" + pipeline
+		},
+	},
+	"circleci": {
+		project:   "CircleCI project",
+		projectID: func(claims jwt.MapClaims) string { return claimString(claims, "oidc.circleci.com/project-id") },
+	},
+}
+
+// OIDCClaims are the claims of an accepted OIDC token.
+type OIDCClaims struct {
+	// Repository is the GitHub repository the token can get installation tokens for.
+	Repository string
+
+	// RepositoryID is the ID of Repository (repository_id claim) of GitHub Actions tokens.
+	// It is zero for other CI providers, whose installation tokens are restricted by repository name.
+	RepositoryID int64
+
+	// RepositoryOwnerID is the ID of the owner of Repository (repository_owner_id claim) of GitHub Actions tokens.
+	// It is zero for other CI providers.
+	RepositoryOwnerID int64
+
+	// Provider is the CI provider type, github-actions for GitHub Actions tokens.
+	Provider string
+
+	// Claims are all claims of the token.
+	Claims map[string]interface{}
+}
+
+// Claim returns a string claim, or an empty string if it is missing or not a string.
+func (c *OIDCClaims) Claim(name string) string {
+	return claimString(c.Claims, name)
+}
+
+// TokenRepository returns the repository installation tokens are restricted to, with its IDs if known.
+func (c *OIDCClaims) TokenRepository() TokenRepository {
+	return TokenRepository{ID: c.RepositoryID, OwnerID: c.RepositoryOwnerID, Name: c.Repository}
+}
+
+// OIDCVerifier verifies OIDC tokens of the configured providers and maps them to GitHub repositories.
+type OIDCVerifier struct {
+	issuers map[string]*oidcIssuer
+}
+
+// oidcIssuer is a configured OIDC issuer with its signing keys.
+type oidcIssuer struct {
+	config     OIDCProviderConfig
+	provider   ciProvider
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewOIDCVerifier creates a verifier for the providers.
+// The configurations are expected to be validated already.
+func NewOIDCVerifier(configs []OIDCProviderConfig) *OIDCVerifier {
+	v := &OIDCVerifier{issuers: make(map[string]*oidcIssuer)}
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	for _, config := range configs {
+		if config.Type == "github-actions" {
+			if config.Issuer == "" {
+				config.Issuer = GitHubActionsIssuer
+			}
+		}
+		v.issuers[strings.TrimSuffix(config.Issuer, "/")] = &oidcIssuer{
+			config:     config,
+			provider:   ciProviders[config.Type],
+			httpClient: httpClient,
+		}
+	}
+	return v
+}
+
+// Verify verifies the token and returns its claims with the GitHub repository it can get tokens for.
+// Without configured providers, tokens are trusted as GitHub Actions tokens validated by GCP IAM;
+// Config.Validate only allows this in ModeFunction. Otherwise, tokens of unconfigured issuers are rejected.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (*OIDCClaims, error) {
+	if len(v.issuers) == 0 {
+		return unverifiedGitHubActionsClaims(token)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT format")
+	}
+	issuerURL, _ := claims.GetIssuer()
+
+	issuer, ok := v.issuers[strings.TrimSuffix(issuerURL, "/")]
+	if !ok {
+		return nil, fmt.Errorf("untrusted token issuer '%s'", issuerURL)
+	}
+
+	verifiedClaims, err := issuer.verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := issuer.repository(verifiedClaims)
+	if err != nil {
+		return nil, err
+	}
+	result := &OIDCClaims{Repository: repository, Provider: issuer.config.Type, Claims: verifiedClaims}
+	if issuer.config.Type == "github-actions" {
+		if result.RepositoryID, err = repositoryIDFromClaims(verifiedClaims); err != nil {
+			return nil, err
+		}
+		if result.RepositoryOwnerID, err = repositoryOwnerIDFromClaims(verifiedClaims); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// unverifiedGitHubActionsClaims returns the claims of a GitHub Actions token validated by GCP IAM.
+func unverifiedGitHubActionsClaims(token string) (*OIDCClaims, error) {
+	claims, err := ExtractClaimsFromOIDC(token)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := repositoryFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	repositoryID, err := repositoryIDFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	ownerID, err := repositoryOwnerIDFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCClaims{Repository: repository, RepositoryID: repositoryID, RepositoryOwnerID: ownerID, Provider: "github-actions", Claims: claims}, nil
+}
+
+// verify verifies the token signature, audience and expiration.
+// The issuer was matched (ignoring a trailing slash) when the oidcIssuer was selected.
+func (i *oidcIssuer) verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithAudience(i.config.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	_, err := parser.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return i.key(ctx, keyID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("token verification failed: %w", err)
+	}
+	return claims, nil
+}
+
+// repository maps verified claims to the GitHub repository.
+func (i *oidcIssuer) repository(claims jwt.MapClaims) (string, error) {
+	projectID := i.provider.projectID(claims)
+	if projectID == "" {
+		return "", fmt.Errorf("%s claim not found in OIDC token", i.provider.project)
+	}
+
+	if i.config.Type == "github-actions" {
+		return repositoryFromClaims(claims)
+	}
+
+	repository, ok := i.config.Repositories[projectID]
+	if !ok {
+		return "", fmt.Errorf("%w to %s '%s'", errRepositoryNotMapped, i.provider.project, projectID)
+	}
+	return repository, nil
+}
+
+// key returns the signing key with the key ID, fetching the issuer's JWKS when needed.
+func (i *oidcIssuer) key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key, ok := i.keys[keyID]
+	stale := time.Since(i.fetchedAt) > jwksTTL
+	if ok && !stale {
+		return key, nil
+	}
+	if !stale && time.Since(i.fetchedAt) < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown signing key '%s'", keyID)
+	}
+
+	keys, err := fetchJWKS(ctx, i.httpClient, i.config.Issuer)
+	if err != nil {
+		if ok {
+			// Keep using the cached key while the issuer is unavailable
+			return key, nil
+		}
+		return nil, err
+	}
+	i.keys = keys
+	i.fetchedAt = time.Now()
+
+	if key, ok := keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key '%s'", keyID)
+}
+
+// fetchJWKS fetches the RSA signing keys of an issuer by key ID using OIDC discovery.
+func fetchJWKS(ctx context.Context, httpClient *http.Client, issuer string) (map[string]*rsa.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, httpClient, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document has no jwks_uri")
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, httpClient, discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no RSA signing keys")
+	}
+	return keys, nil
+}
+
+// getJSON fetches a JSON document.
+func getJSON(ctx context.Context, httpClient *http.Client, documentURL string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, documentURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned HTTP %d", documentURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// claimString returns a string claim, or an empty string if it is missing or not a string.
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
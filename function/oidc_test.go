@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestOIDCIssuer starts a FakeOIDCIssuer served at its issuer URL.
+func newTestOIDCIssuer(t *testing.T) *FakeOIDCIssuer {
+	t.Helper()
+	var issuer *FakeOIDCIssuer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	issuer = NewFakeOIDCIssuer(server.URL, generateTestRSAKey(t))
+	issuer.DefaultClaims = map[string]interface{}{"sub": "test"}
+	return issuer
+}
+
+// TestOIDCVerifier_Verify tests verification of CI provider tokens and mapping to GitHub repositories.
+//
+// Test steps:
+//  1. Configure GitLab, Buildkite and CircleCI providers with fake issuers
+//  2. Mint tokens with the provider claims
+//  3. Verify mapped repositories, unmapped projects and verification failures
+func TestOIDCVerifier_Verify(t *testing.T) {
+	// Step 1: Configure providers
+	gitlab := newTestOIDCIssuer(t)
+	buildkite := newTestOIDCIssuer(t)
+	circleci := newTestOIDCIssuer(t)
+	forger := NewFakeOIDCIssuer(gitlab.Issuer, generateTestRSAKey(t))
+	verifier := NewOIDCVerifier([]OIDCProviderConfig{
+		{Type: "gitlab", Issuer: gitlab.Issuer, Audience: "issuer", Repositories: map[string]string{"group/project": "owner/repo"}},
+		{Type: "buildkite", Issuer: buildkite.Issuer + "/", Audience: "issuer", Repositories: map[string]string{"org/pipeline": "owner/bk"}},
+		{Type: "circleci", Issuer: circleci.Issuer, Audience: "issuer", Repositories: map[string]string{"0f4e0c3c": "owner/ci"}},
+	})
+
+	tests := []struct {
+		name        string
+		issuer      *FakeOIDCIssuer
+		claims      map[string]interface{}
+		want        string
+		wantErr     bool
+		errContains string
+		errIs       error
+	}{
+		{
+			name:   "GitLab project",
+			issuer: gitlab,
+			claims: map[string]interface{}{"aud": "issuer", "project_path": "group/project"},
+			want:   "owner/repo",
+		},
+		{
+			name:   "Buildkite pipeline",
+			issuer: buildkite,
+			claims: map[string]interface{}{"aud": "issuer", "organization_slug": "org", "pipeline_slug": "pipeline"},
+			want:   "owner/bk",
+		},
+		{
+			name:   "CircleCI project",
+			issuer: circleci,
+			claims: map[string]interface{}{"aud": "issuer", "oidc.circleci.com/project-id": "0f4e0c3c"},
+			want:   "owner/ci",
+		},
+		{
+			name:        "unmapped project",
+			issuer:      gitlab,
+			claims:      map[string]interface{}{"aud": "issuer", "project_path": "group/other"},
+			wantErr:     true,
+			errContains: "no GitHub repository mapped to GitLab project 'group/other'",
+			errIs:       errRepositoryNotMapped,
+		},
+		{
+			name:        "missing project claim",
+			issuer:      buildkite,
+			claims:      map[string]interface{}{"aud": "issuer", "pipeline_slug": "pipeline"},
+			wantErr:     true,
+			errContains: "Buildkite pipeline claim not found",
+		},
+		{
+			name:        "wrong audience",
+			issuer:      gitlab,
+			claims:      map[string]interface{}{"aud": "other", "project_path": "group/project"},
+			wantErr:     true,
+			errContains: "token verification failed",
+		},
+		{
+			name:        "expired",
+			issuer:      gitlab,
+			claims:      map[string]interface{}{"aud": "issuer", "project_path": "group/project", "exp": time.Now().Add(-time.Hour).Unix()},
+			wantErr:     true,
+			errContains: "token verification failed",
+		},
+		{
+			name:        "forged signature",
+			issuer:      forger,
+			claims:      map[string]interface{}{"aud": "issuer", "project_path": "group/project"},
+			wantErr:     true,
+			errContains: "token verification failed",
+		},
+		{
+			name:        "untrusted issuer",
+			issuer:      NewFakeOIDCIssuer("https://ci.example", generateTestRSAKey(t)),
+			claims:      map[string]interface{}{"aud": "issuer", "project_path": "group/project"},
+			wantErr:     true,
+			errContains: "untrusted token issuer 'https://ci.example'",
+		},
+		{
+			name:        "unverified GitHub Actions token",
+			issuer:      NewFakeOIDCIssuer(GitHubActionsIssuer, generateTestRSAKey(t)),
+			claims:      map[string]interface{}{"aud": "issuer", "repository": "owner/actions"},
+			wantErr:     true,
+			errContains: "untrusted token issuer '" + GitHubActionsIssuer + "'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Mint token
+			token, err := tt.issuer.Mint(tt.claims)
+			if err != nil {
+				t.Fatalf("Mint() error = %v", err)
+			}
+
+			// Step 3: Verify
+			got, err := verifier.Verify(context.Background(), token)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("Verify() error = %v, want containing %q", err, tt.errContains)
+				}
+				if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+					t.Errorf("Verify() error = %v, want wrapping %v", err, tt.errIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() unexpected error = %v", err)
+			}
+			if got.Repository != tt.want {
+				t.Errorf("Verify().Repository = %q, want %q", got.Repository, tt.want)
+			}
+		})
+	}
+}
+
+// TestOIDCVerifier_GitHubActions tests that a github-actions provider requires verified tokens.
+//
+// Test steps:
+//  1. Configure a github-actions provider with a fake issuer
+//  2. Verify a signed token is accepted and its repository claim is used
+//  3. Verify unsigned tokens with the github.com issuer are no longer trusted
+func TestOIDCVerifier_GitHubActions(t *testing.T) {
+	// Step 1: Configure provider
+	actions := newTestOIDCIssuer(t)
+	verifier := NewOIDCVerifier([]OIDCProviderConfig{{Type: "github-actions", Issuer: actions.Issuer, Audience: "issuer"}})
+
+	// Step 2: Signed token
+	token, err := actions.Mint(map[string]interface{}{"aud": "issuer", "repository": "owner/repo"})
+	if err != nil {
+		t.Fatalf("Mint() error = %v", err)
+	}
+	got, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if got.Repository != "owner/repo" || got.Provider != "github-actions" || got.Claim("aud") != "issuer" {
+		t.Errorf("Verify() = %+v, want owner/repo from github-actions", got)
+	}
+
+	// Step 3: github.com issuer
+	token, err = NewFakeOIDCIssuer(GitHubActionsIssuer, generateTestRSAKey(t)).Mint(map[string]interface{}{"aud": "issuer", "repository": "owner/repo"})
+	if err != nil {
+		t.Fatalf("Mint() error = %v", err)
+	}
+	if _, err := verifier.Verify(context.Background(), token); err == nil || !strings.Contains(err.Error(), "untrusted token issuer") {
+		t.Errorf("Verify() error = %v, want containing %q", err, "untrusted token issuer")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// optionalParam is the query parameter listing the scopes a caller can do without, e.g. ?optional=issues,pull-requests.
+const optionalParam = "optional"
+
+// ParseOptionalScopes parses the comma-separated scope IDs of the optional parameter, named like scope parameters.
+// Each optional scope must be among the requested scopes. Returns the sorted scope IDs.
+func ParseOptionalScopes(value string, scopes map[string]string) ([]string, error) {
+	var optional []string
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		scopeID := normalizeScopeID(strings.TrimSpace(entry))
+		if scopeID == "" || seen[scopeID] {
+			continue
+		}
+		if _, ok := scopes[scopeID]; !ok {
+			return nil, fmt.Errorf("optional scope '%s' is not requested", scopeID)
+		}
+		seen[scopeID] = true
+		optional = append(optional, scopeID)
+	}
+	sort.Strings(optional)
+	return optional, nil
+}
+
+// DropOptionalScopes leaves out the optional scopes the installation doesn't have at the requested level,
+// since GitHub rejects the whole token request for them. Returns the remaining scopes
+// and the dropped scopes as sorted "scope:level" entries.
+func DropOptionalScopes(scopes map[string]string, optional []string, installation *github.InstallationPermissions) (map[string]string, []string) {
+	installed := ScopesOfPermissions(installation)
+
+	remaining := make(map[string]string, len(scopes))
+	for scopeID, level := range scopes {
+		remaining[scopeID] = level
+	}
+	var dropped []string
+	for _, scopeID := range optional {
+		level, ok := remaining[scopeID]
+		if ok && permissionRank[installed[scopeID]] < permissionRank[level] {
+			delete(remaining, scopeID)
+			dropped = append(dropped, scopeID+":"+level)
+		}
+	}
+	sort.Strings(dropped)
+	return remaining, dropped
+}
+
+// ungrantedScopes returns the requested scopes GitHub didn't grant at the requested level,
+// as sorted "scope:level" entries.
+func ungrantedScopes(requested map[string]string, granted map[string]string) []string {
+	var ungranted []string
+	for scopeID, level := range requested {
+		if granted[scopeID] != level {
+			ungranted = append(ungranted, scopeID+":"+level)
+		}
+	}
+	sort.Strings(ungranted)
+	return ungranted
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// TestParseOptionalScopes tests parsing of the optional parameter against the requested scopes.
+//
+// Test steps:
+//  1. Parse the optional parameter of each test case
+//  2. Verify the optional scope IDs or the error
+func TestParseOptionalScopes(t *testing.T) {
+	scopes := map[string]string{"contents": "write", "issues": "read", "pull_requests": "read"}
+
+	tests := []struct {
+		name        string
+		value       string
+		want        []string
+		wantErr     bool
+		errContains string
+	}{
+		{name: "empty", value: "", want: nil},
+		{name: "sorted and normalized", value: "pull-requests, issues", want: []string{"issues", "pull_requests"}},
+		{name: "duplicates", value: "issues,issues", want: []string{"issues"}},
+		{name: "not requested", value: "issues,secrets", wantErr: true, errContains: "optional scope 'secrets' is not requested"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Parse
+			got, err := ParseOptionalScopes(tt.value, scopes)
+
+			// Step 2: Verify
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseOptionalScopes() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseOptionalScopes() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOptionalScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDropOptionalScopes tests that optional scopes the installation lacks are dropped, and required scopes kept.
+//
+// Test steps:
+//  1. Drop the optional scopes of each test case for an installation with contents:write and issues:read
+//  2. Verify the remaining and dropped scopes
+func TestDropOptionalScopes(t *testing.T) {
+	installation := &github.InstallationPermissions{Contents: github.Ptr("write"), Issues: github.Ptr("read")}
+
+	tests := []struct {
+		name        string
+		scopes      map[string]string
+		optional    []string
+		wantScopes  map[string]string
+		wantDropped []string
+	}{
+		{
+			name:        "installed optional scopes kept",
+			scopes:      map[string]string{"contents": "write", "issues": "read"},
+			optional:    []string{"contents", "issues"},
+			wantScopes:  map[string]string{"contents": "write", "issues": "read"},
+			wantDropped: nil,
+		},
+		{
+			name:        "missing and lower level optional scopes dropped",
+			scopes:      map[string]string{"contents": "read", "issues": "write", "pull_requests": "read"},
+			optional:    []string{"issues", "pull_requests"},
+			wantScopes:  map[string]string{"contents": "read"},
+			wantDropped: []string{"issues:write", "pull_requests:read"},
+		},
+		{
+			name:        "missing required scopes kept",
+			scopes:      map[string]string{"contents": "write", "pull_requests": "read"},
+			optional:    nil,
+			wantScopes:  map[string]string{"contents": "write", "pull_requests": "read"},
+			wantDropped: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Drop optional scopes
+			scopes, dropped := DropOptionalScopes(tt.scopes, tt.optional, installation)
+
+			// Step 2: Verify
+			if !reflect.DeepEqual(scopes, tt.wantScopes) {
+				t.Errorf("DropOptionalScopes() scopes = %v, want %v", scopes, tt.wantScopes)
+			}
+			if !reflect.DeepEqual(dropped, tt.wantDropped) {
+				t.Errorf("DropOptionalScopes() dropped = %v, want %v", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+// TestE2E_OptionalScopes tests token requests with optional scopes the installation lacks.
+//
+// Test steps:
+//  1. Start the service with an installation having contents:write and issues:read
+//  2. Request contents:write with optional issues:write and pull_requests:read
+//  3. Verify the token is issued with the granted scopes and the dropped scopes are reported
+//  4. Verify missing required scopes, only missing scopes and unrequested optional scopes are rejected
+func TestE2E_OptionalScopes(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironment(t)
+
+	// Step 2: Request token
+	status, body := env.requestToken(t, "owner/repo", "contents=write&issues=write&pull-requests=read&optional=issues,pull-requests")
+
+	// Step 3: Verify granted and dropped scopes
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	scopes, _ := body["scopes"].(map[string]interface{})
+	if len(scopes) != 1 || scopes["contents"] != "write" {
+		t.Errorf("scopes = %v, want contents:write", body["scopes"])
+	}
+	dropped, _ := body["dropped_scopes"].([]interface{})
+	if len(dropped) != 2 || dropped[0] != "issues:write" || dropped[1] != "pull_requests:read" {
+		t.Errorf("dropped_scopes = %v, want [issues:write pull_requests:read]", body["dropped_scopes"])
+	}
+
+	// Step 4: Verify rejected requests
+	tests := []struct {
+		query       string
+		wantStatus  int
+		errContains string
+	}{
+		{query: "contents=write&pull_requests=read&optional=contents", wantStatus: http.StatusForbidden},
+		{query: "pull_requests=read&optional=pull_requests", wantStatus: http.StatusForbidden, errContains: "none of the requested scopes"},
+		{query: "contents=write&optional=issues", wantStatus: http.StatusBadRequest, errContains: "optional scope 'issues' is not requested"},
+	}
+	for _, tt := range tests {
+		status, body := env.requestToken(t, "owner/repo", tt.query)
+		if errMsg, _ := body["error"].(string); status != tt.wantStatus || !strings.Contains(errMsg, tt.errContains) {
+			t.Errorf("%s = %v %v, want %v containing %q", tt.query, status, body, tt.wantStatus, tt.errContains)
+		}
+	}
+}
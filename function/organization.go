@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// organizationAuditCategory is the audit log category of organization permission decisions.
+const organizationAuditCategory = "organization_permissions"
+
+// SplitOrganizationScopes splits requested scopes into repository scopes and organization permissions.
+func SplitOrganizationScopes(scopes map[string]string) (repository map[string]string, organization map[string]string) {
+	repository = make(map[string]string, len(scopes))
+	organization = make(map[string]string)
+	for scopeID, level := range scopes {
+		if _, ok := OrganizationScopes[scopeID]; ok {
+			organization[scopeID] = level
+		} else {
+			repository[scopeID] = level
+		}
+	}
+	return repository, organization
+}
+
+// ValidateOrganizationScopes validates organization permissions against OrganizationScopes.
+func ValidateOrganizationScopes(scopes map[string]string) error {
+	for scopeID, permission := range scopes {
+		levels, ok := OrganizationScopes[scopeID]
+		if !ok {
+			return fmt.Errorf("scope '%s' is not an organization permission", scopeID)
+		}
+		if !slices.Contains(levels, permission) {
+			return fmt.Errorf("permission '%s' not allowed for scope '%s' (allowed: %v)", permission, scopeID, levels)
+		}
+	}
+	return nil
+}
+
+// validateOrganizationPolicy checks the entries of OrganizationRepositories and OrganizationWorkflows.
+// Each entry must name an organization permission and be configured in both maps.
+func validateOrganizationPolicy(c *Config) []error {
+	entries := make(map[string]bool)
+	for entry := range c.OrganizationRepositories {
+		entries[entry] = true
+	}
+	for entry := range c.OrganizationWorkflows {
+		entries[entry] = true
+	}
+	sorted := make([]string, 0, len(entries))
+	for entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	sort.Strings(sorted)
+
+	var errs []error
+	for _, entry := range sorted {
+		scopeID, level, _ := strings.Cut(entry, ":")
+		if err := ValidateOrganizationScopes(map[string]string{scopeID: level}); err != nil {
+			errs = append(errs, fmt.Errorf("invalid organization policy entry '%s': %w", entry, err))
+			continue
+		}
+
+		repositories, workflows := c.OrganizationRepositories[entry], c.OrganizationWorkflows[entry]
+		if len(repositories) == 0 || len(workflows) == 0 {
+			errs = append(errs, fmt.Errorf("invalid organization policy entry '%s': organization_repositories and organization_workflows are required", entry))
+			continue
+		}
+		for _, repositoryID := range repositories {
+			if repositoryID <= 0 {
+				errs = append(errs, fmt.Errorf("invalid organization_repositories[%s] entry '%d': must be a repository ID", entry, repositoryID))
+			}
+		}
+		for i, rule := range workflows {
+			if err := rule.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("invalid organization_workflows[%s][%d]: %w", entry, i, err))
+			}
+		}
+	}
+	return errs
+}
+
+// ApplyOrganizationPolicy checks that the organization permissions among the scopes are granted by the organization
+// policy to the repository and the workflow of the job. Only GitHub Actions jobs can get organization permissions.
+// Returns a *PolicyError naming the permissions that aren't granted.
+func ApplyOrganizationPolicy(config *Config, claims *OIDCClaims, scopes map[string]string) error {
+	_, organization := SplitOrganizationScopes(scopes)
+
+	var denied []string
+	for scopeID, level := range organization {
+		if !organizationPolicyGrants(config, claims, scopeID, level) {
+			denied = append(denied, scopeID+":"+level)
+		}
+	}
+	if len(denied) == 0 {
+		return nil
+	}
+	sort.Strings(denied)
+
+	workflowRef := claims.Claim("job_workflow_ref")
+	return &PolicyError{
+		Message: fmt.Sprintf("organization permissions (%s) are not granted to %s (repository ID %d, job_workflow_ref '%s')",
+			strings.Join(denied, ", "), claims.Repository, claims.RepositoryID, workflowRef),
+		Details: map[string]interface{}{
+			"organization_scopes": denied,
+			"repository_id":       claims.RepositoryID,
+			"job_workflow_ref":    workflowRef,
+		},
+	}
+}
+
+// organizationPolicyGrants reports whether an entry of the organization policy at level or higher
+// lists the repository ID of the job, and one of its workflow rules matches the job.
+// Repositories are matched by ID, so a repository recreated under a granted name doesn't match.
+func organizationPolicyGrants(config *Config, claims *OIDCClaims, scopeID, level string) bool {
+	if claims.Provider != "github-actions" || claims.RepositoryID == 0 {
+		return false
+	}
+	workflowRef, workflowSHA := claims.Claim("job_workflow_ref"), claims.Claim("job_workflow_sha")
+	for entry, repositories := range config.OrganizationRepositories {
+		entryScope, entryLevel, _ := strings.Cut(entry, ":")
+		if entryScope != scopeID || permissionRank[entryLevel] < permissionRank[level] {
+			continue
+		}
+		if !slices.Contains(repositories, claims.RepositoryID) {
+			continue
+		}
+		for _, rule := range config.OrganizationWorkflows[entry] {
+			if rule.matches(workflowRef, workflowSHA) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// organizationScopeEntries returns the organization permissions among the scopes as sorted "scope:level" entries.
+func organizationScopeEntries(scopes map[string]string) []string {
+	_, organization := SplitOrganizationScopes(scopes)
+	entries := make([]string, 0, len(organization))
+	for scopeID, level := range organization {
+		entries = append(entries, scopeID+":"+level)
+	}
+	sort.Strings(entries)
+	return entries
+}
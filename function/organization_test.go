@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testOrganizationWorkflowRef is the job_workflow_ref granted organization permissions in organization tests.
+const testOrganizationWorkflowRef = "owner/automation/.github/workflows/sync.yml@refs/heads/main"
+
+// configureOrganizationPolicy grants members:read and organization_projects:write to owner/automation
+// when run by its sync workflow on main.
+func configureOrganizationPolicy(config *Config) {
+	rules := []WorkflowRule{{Workflow: "owner/automation/.github/workflows/sync.yml", Ref: "refs/heads/main"}}
+	repositoryID := FakeRepositoryID("owner/automation")
+	config.OrganizationRepositories = map[string][]int64{
+		"members:read":                {repositoryID},
+		"organization_projects:write": {repositoryID},
+	}
+	config.OrganizationWorkflows = map[string][]WorkflowRule{
+		"members:read":                rules,
+		"organization_projects:write": rules,
+	}
+}
+
+// TestApplyOrganizationPolicy tests that organization permissions are only issued to the repositories
+// and workflows of the organization policy.
+//
+// Test steps:
+//  1. Configure the organization policy
+//  2. Apply the policy to the requested scopes with the repository and job_workflow_ref of each test case
+//  3. Verify granted permissions pass, and other repository IDs, workflows, levels and providers are denied
+func TestApplyOrganizationPolicy(t *testing.T) {
+	// Step 1: Configure policy
+	config := testConfig()
+	configureOrganizationPolicy(config)
+
+	tests := []struct {
+		name         string
+		provider     string
+		repository   string
+		repositoryID int64
+		workflowRef  string
+		scopes       map[string]string
+		wantErr      bool
+		errContains  string
+	}{
+		{
+			name:        "repository scopes are not restricted",
+			repository:  "owner/repo",
+			workflowRef: "owner/repo/.github/workflows/ci.yml@refs/heads/main",
+			scopes:      map[string]string{"contents": "write"},
+		},
+		{
+			name:        "granted permissions",
+			repository:  "owner/automation",
+			workflowRef: testOrganizationWorkflowRef,
+			scopes:      map[string]string{"members": "read", "organization_projects": "write", "contents": "read"},
+		},
+		{
+			name:        "write entry covers read",
+			repository:  "owner/automation",
+			workflowRef: testOrganizationWorkflowRef,
+			scopes:      map[string]string{"organization_projects": "read"},
+		},
+		{
+			name:        "read entry does not cover write",
+			repository:  "owner/automation",
+			workflowRef: testOrganizationWorkflowRef,
+			scopes:      map[string]string{"members": "write"},
+			wantErr:     true,
+			errContains: "organization permissions (members:write) are not granted to owner/automation",
+		},
+		{
+			name:        "other repository",
+			repository:  "owner/repo",
+			workflowRef: "owner/repo/.github/workflows/sync.yml@refs/heads/main",
+			scopes:      map[string]string{"members": "read"},
+			wantErr:     true,
+			errContains: "organization permissions (members:read)",
+		},
+		{
+			name:         "recreated repository with the granted name",
+			repository:   "owner/automation",
+			repositoryID: FakeRepositoryID("owner/automation-recreated"),
+			workflowRef:  testOrganizationWorkflowRef,
+			scopes:       map[string]string{"members": "read"},
+			wantErr:      true,
+			errContains:  "organization permissions (members:read) are not granted to owner/automation",
+		},
+		{
+			name:        "other workflow",
+			repository:  "owner/automation",
+			workflowRef: "owner/automation/.github/workflows/sync.yml@refs/heads/feature",
+			scopes:      map[string]string{"members": "read", "organization_projects": "write"},
+			wantErr:     true,
+			errContains: "organization permissions (members:read, organization_projects:write)",
+		},
+		{
+			name:        "other CI provider",
+			provider:    "gitlab",
+			repository:  "owner/automation",
+			workflowRef: testOrganizationWorkflowRef,
+			scopes:      map[string]string{"members": "read"},
+			wantErr:     true,
+			errContains: "organization permissions (members:read)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := tt.provider
+			if provider == "" {
+				provider = "github-actions"
+			}
+			repositoryID := tt.repositoryID
+			if repositoryID == 0 {
+				repositoryID = FakeRepositoryID(tt.repository)
+			}
+			claims := &OIDCClaims{Repository: tt.repository, RepositoryID: repositoryID, Provider: provider,
+				Claims: map[string]interface{}{"job_workflow_ref": tt.workflowRef}}
+
+			// Step 2: Apply policy
+			err := ApplyOrganizationPolicy(config, claims, tt.scopes)
+
+			// Step 3: Verify
+			if tt.wantErr {
+				var policyErr *PolicyError
+				if !errors.As(err, &policyErr) || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("ApplyOrganizationPolicy() error = %v, want *PolicyError containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("ApplyOrganizationPolicy() unexpected error = %v", err)
+			}
+		})
+	}
+}
+
+// TestE2E_OrganizationPermissions tests issuing organization permissions through the service.
+//
+// Test steps:
+//  1. Start the service with the organization policy and an installation with members:read
+//  2. Request members:read from the granted workflow and verify the token is issued
+//  3. Verify requests from other workflows and invalid levels are rejected
+func TestE2E_OrganizationPermissions(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironmentWithConfig(t, configureOrganizationPolicy)
+	env.github.installations[0].Permissions["members"] = "read"
+	claims := map[string]interface{}{"repository": "owner/automation", "job_workflow_ref": testOrganizationWorkflowRef}
+
+	// Step 2: Request granted permission
+	status, body := env.requestTokenWithClaims(t, claims, "members=read&contents=read")
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	scopes, _ := body["scopes"].(map[string]interface{})
+	if len(scopes) != 2 || scopes["members"] != "read" || scopes["contents"] != "read" {
+		t.Errorf("scopes = %v, want members:read and contents:read", body["scopes"])
+	}
+
+	// Step 3: Verify rejected requests
+	tests := []struct {
+		name        string
+		claims      map[string]interface{}
+		query       string
+		wantStatus  int
+		errContains string
+	}{
+		{
+			name:        "other workflow",
+			claims:      map[string]interface{}{"repository": "owner/automation", "job_workflow_ref": "owner/automation/.github/workflows/ci.yml@refs/heads/main"},
+			query:       "members=read",
+			wantStatus:  http.StatusForbidden,
+			errContains: "organization permissions (members:read) are not granted",
+		},
+		{
+			name:        "invalid level",
+			claims:      claims,
+			query:       "organization_plan=write",
+			wantStatus:  http.StatusBadRequest,
+			errContains: "permission 'write' not allowed for scope 'organization_plan'",
+		},
+	}
+	for _, tt := range tests {
+		status, body := env.requestTokenWithClaims(t, tt.claims, tt.query)
+		if errMsg, _ := body["error"].(string); status != tt.wantStatus || !strings.Contains(errMsg, tt.errContains) {
+			t.Errorf("%s: %s = %v %v, want %v containing %q", tt.name, tt.query, status, body, tt.wantStatus, tt.errContains)
+		}
+	}
+}
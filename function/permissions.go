@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// Shorthands of workflow permissions blocks, requested with ?permissions=read-all or ?permissions=write-all.
+const (
+	ShorthandReadAll  = "read-all"
+	ShorthandWriteAll = "write-all"
+)
+
+// permissionsParam is the query parameter of shorthands.
+const permissionsParam = "permissions"
+
+// permissionNone leaves a scope out of a shorthand, like "none" in a workflow permissions block.
+const permissionNone = "none"
+
+// shorthandLevels maps the shorthands to the highest permission level they expand to.
+var shorthandLevels = map[string]string{
+	ShorthandReadAll:  "read",
+	ShorthandWriteAll: "write",
+}
+
+// workflowOnlyPermissions are permissions of workflow permissions blocks that GitHub App tokens don't have.
+// They are ignored, so that a token request can mirror the permissions block of its job.
+var workflowOnlyPermissions = map[string]bool{
+	"id_token": true,
+	"models":   true,
+}
+
+// normalizeScopeID returns the scope ID of a query parameter: hyphens of workflow permissions blocks
+// (e.g. pull-requests) are replaced with underscores, and aliases are resolved.
+func normalizeScopeID(param string) string {
+	scopeID := strings.ReplaceAll(param, "-", "_")
+	if canonical, ok := scopeAliases[scopeID]; ok {
+		return canonical
+	}
+	return scopeID
+}
+
+// ExpandShorthand expands read-all or write-all to the scopes the caller can get: each scope the installation
+// has at the highest level up to the shorthand level that the policies allow without an approval.
+// Scopes denied by a policy or requiring an approval are left out.
+func ExpandShorthand(config *Config, claims *OIDCClaims, shorthand string, installation *github.InstallationPermissions) map[string]string {
+	maxLevel := shorthandLevels[shorthand]
+	installed := ScopesOfPermissions(installation)
+
+	scopes := make(map[string]string)
+	for _, definition := range ScopeDefinitions {
+		for i := len(definition.Levels) - 1; i >= 0; i-- {
+			level := definition.Levels[i]
+			if permissionRank[level] > permissionRank[maxLevel] || permissionRank[level] > permissionRank[installed[definition.ID]] {
+				continue
+			}
+			decision := scopeDecision(config, claims, definition.ID, level)
+			if decision.Decision == ScopeDecisionAllow {
+				scopes[definition.ID] = level
+				break
+			}
+		}
+	}
+	return scopes
+}
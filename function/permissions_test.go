@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// TestNormalizeScopeID tests that workflow permissions block names and aliases map to scope IDs.
+//
+// Test steps:
+//  1. Normalize the query parameter of each test case
+//  2. Verify the scope ID
+func TestNormalizeScopeID(t *testing.T) {
+	tests := []struct {
+		param string
+		want  string
+	}{
+		{param: "contents", want: "contents"},
+		{param: "pull-requests", want: "pull_requests"},
+		{param: "security-events", want: "security_events"},
+		{param: "repository-projects", want: "projects"},
+		{param: "secret_scanning_alerts", want: "secret_scanning"},
+		{param: "unknown-scope", want: "unknown_scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			// Step 1: Normalize
+			got := normalizeScopeID(tt.param)
+
+			// Step 2: Verify
+			if got != tt.want {
+				t.Errorf("normalizeScopeID(%q) = %q, want %q", tt.param, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExpandShorthand tests the expansion of read-all and write-all against the policies and the installation.
+//
+// Test steps:
+//  1. Configure approval of contents:write and an installation with contents, issues, secrets and administration
+//  2. Expand the shorthand of each test case for a push job outside a deployment environment
+//  3. Verify the scopes
+func TestExpandShorthand(t *testing.T) {
+	// Step 1: Configure policies and installation
+	config := testConfig()
+	config.ApprovalScopes = []string{"contents:write"}
+	installation := &github.InstallationPermissions{
+		Contents:       github.Ptr("write"),
+		Issues:         github.Ptr("write"),
+		Secrets:        github.Ptr("write"),
+		Administration: github.Ptr("read"),
+	}
+	claims := &OIDCClaims{Repository: "owner/repo", Provider: "github-actions", Claims: map[string]interface{}{"event_name": "push"}}
+
+	tests := []struct {
+		name      string
+		shorthand string
+		want      map[string]string
+	}{
+		{
+			name:      "read-all",
+			shorthand: ShorthandReadAll,
+			want:      map[string]string{"contents": "read", "issues": "read", "secrets": "read"},
+		},
+		{
+			name:      "write-all",
+			shorthand: ShorthandWriteAll,
+			want:      map[string]string{"contents": "read", "issues": "write", "secrets": "read"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Expand
+			got := ExpandShorthand(config, claims, tt.shorthand, installation)
+
+			// Step 3: Verify
+			if len(got) != len(tt.want) {
+				t.Fatalf("ExpandShorthand() = %v, want %v", got, tt.want)
+			}
+			for scopeID, level := range tt.want {
+				if got[scopeID] != level {
+					t.Errorf("ExpandShorthand()[%s] = %q, want %q", scopeID, got[scopeID], level)
+				}
+			}
+		})
+	}
+}
+
+// TestE2E_WorkflowPermissionsSyntax tests token requests mirroring a workflow permissions block.
+//
+// Test steps:
+//  1. Start the service
+//  2. Request write-all with contents limited to read, id-token and issues excluded
+//  3. Verify the issued scopes
+//  4. Verify invalid shorthands and duplicates after normalization are rejected
+func TestE2E_WorkflowPermissionsSyntax(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironment(t)
+
+	// Step 2: Request token
+	status, body := env.requestToken(t, "owner/repo", "permissions=write-all&contents=read&id-token=write&issues=none")
+
+	// Step 3: Verify scopes
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	scopes, _ := body["scopes"].(map[string]interface{})
+	if len(scopes) != 1 || scopes["contents"] != "read" {
+		t.Errorf("scopes = %v, want contents:read", body["scopes"])
+	}
+	if status, body := env.requestToken(t, "owner/repo", "permissions=read-all"); status != http.StatusOK ||
+		body["scopes"].(map[string]interface{})["issues"] != "read" {
+		t.Errorf("read-all = %v %v, want installation scopes at read", status, body)
+	}
+
+	// Step 4: Verify rejected requests
+	tests := []struct {
+		query       string
+		errContains string
+	}{
+		{query: "permissions=all", errContains: "invalid permissions 'all'"},
+		{query: "pull-requests=read&pull_requests=write", errContains: "duplicate scope 'pull_requests'"},
+		{query: "contents=admin", errContains: "must be 'read', 'write' or 'none'"},
+	}
+	for _, tt := range tests {
+		status, body := env.requestToken(t, "owner/repo", tt.query)
+		if errMsg, _ := body["error"].(string); status != http.StatusBadRequest || !strings.Contains(errMsg, tt.errContains) {
+			t.Errorf("%s = %v %v, want %v containing %q", tt.query, status, body, http.StatusBadRequest, tt.errContains)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Event policies for write scopes requested by pull request workflows.
+const (
+	EventPolicyAllow     = "allow"
+	EventPolicyDeny      = "deny"
+	EventPolicyDowngrade = "downgrade"
+)
+
+// pullRequestEvents are the events whose workflows run the code of a pull request, possibly from a fork.
+var pullRequestEvents = map[string]bool{
+	"pull_request":                true,
+	"pull_request_review":         true,
+	"pull_request_review_comment": true,
+}
+
+// PolicyError is returned when a policy denies the request.
+// Details describe the decision and are returned in the error response.
+type PolicyError struct {
+	Message string
+	Details map[string]interface{}
+}
+
+// Error implements the error interface.
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+// ScopeDowngrade is a requested scope issued at a lower level by a policy.
+type ScopeDowngrade struct {
+	Scope     string `json:"scope"`
+	Requested string `json:"requested"`
+	Granted   string `json:"granted"`
+
+	// Policy is the configuration key of the downgrading policy, e.g. pull_request_policy.
+	Policy    string `json:"policy"`
+	EventName string `json:"event_name"`
+}
+
+// ApplyEventPolicy applies the pull request policies to the write scopes of a GitHub Actions token.
+// Workflows of pull_request events (and other events with a head_ref, except pull_request_target)
+// use PullRequestPolicy, pull_request_target workflows use PullRequestTargetPolicy.
+// Returns the scopes to request, with write downgraded to read by the downgrade policy and the downgrades
+// ordered by scope, or a *PolicyError by the deny policy.
+func ApplyEventPolicy(config *Config, claims *OIDCClaims, scopes map[string]string) (map[string]string, []ScopeDowngrade, error) {
+	if claims.Provider != "github-actions" {
+		return scopes, nil, nil
+	}
+
+	eventName := claims.Claim("event_name")
+	headRef := claims.Claim("head_ref")
+	var policy, policyName string
+	switch {
+	case eventName == "pull_request_target":
+		policy, policyName = config.PullRequestTargetPolicy, "pull_request_target_policy"
+	case pullRequestEvents[eventName] || headRef != "":
+		policy, policyName = config.PullRequestPolicy, "pull_request_policy"
+	default:
+		return scopes, nil, nil
+	}
+
+	var writeScopes []string
+	for scopeID, permission := range scopes {
+		if permission == "write" {
+			writeScopes = append(writeScopes, scopeID)
+		}
+	}
+	if len(writeScopes) == 0 || policy == EventPolicyAllow {
+		return scopes, nil, nil
+	}
+	sort.Strings(writeScopes)
+
+	if policy == EventPolicyDowngrade {
+		downgraded := make(map[string]string, len(scopes))
+		for scopeID, permission := range scopes {
+			if permission == "write" {
+				permission = "read"
+			}
+			downgraded[scopeID] = permission
+		}
+		downgrades := make([]ScopeDowngrade, 0, len(writeScopes))
+		for _, scopeID := range writeScopes {
+			downgrades = append(downgrades, ScopeDowngrade{
+				Scope:     scopeID,
+				Requested: "write",
+				Granted:   "read",
+				Policy:    policyName,
+				EventName: eventName,
+			})
+		}
+		return downgraded, downgrades, nil
+	}
+
+	return nil, nil, &PolicyError{
+		Message: fmt.Sprintf("write scopes (%s) are not allowed for %s workflows", strings.Join(writeScopes, ", "), eventName),
+		Details: map[string]interface{}{
+			"policy":       EventPolicyDeny,
+			"event_name":   eventName,
+			"head_ref":     headRef,
+			"base_ref":     claims.Claim("base_ref"),
+			"write_scopes": writeScopes,
+		},
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestApplyEventPolicy tests the pull request policies for write scopes.
+//
+// Test steps:
+//  1. Configure the pull_request and pull_request_target policies
+//  2. Apply them to scopes requested with the claims of the test case
+//  3. Verify the resulting scopes and downgrades or the denial
+func TestApplyEventPolicy(t *testing.T) {
+	scopes := map[string]string{"contents": "write", "issues": "write", "metadata": "read"}
+
+	tests := []struct {
+		name              string
+		pullRequest       string
+		pullRequestTarget string
+		provider          string
+		claims            map[string]interface{}
+		want              map[string]string
+		wantDowngrades    []string
+		wantErr           bool
+		errContains       string
+	}{
+		{
+			name:   "push is allowed",
+			claims: map[string]interface{}{"event_name": "push"},
+			want:   scopes,
+		},
+		{
+			name:        "pull_request is denied",
+			claims:      map[string]interface{}{"event_name": "pull_request", "head_ref": "feature", "base_ref": "main"},
+			wantErr:     true,
+			errContains: "write scopes (contents, issues) are not allowed for pull_request workflows",
+		},
+		{
+			name:        "pull_request_review is denied",
+			claims:      map[string]interface{}{"event_name": "pull_request_review"},
+			wantErr:     true,
+			errContains: "pull_request_review workflows",
+		},
+		{
+			name:        "other event with head_ref is denied",
+			claims:      map[string]interface{}{"event_name": "custom", "head_ref": "feature"},
+			wantErr:     true,
+			errContains: "custom workflows",
+		},
+		{
+			name:        "pull_request is downgraded",
+			pullRequest: EventPolicyDowngrade,
+			claims:      map[string]interface{}{"event_name": "pull_request"},
+			want:        map[string]string{"contents": "read", "issues": "read", "metadata": "read"},
+			wantDowngrades: []string{
+				"contents:write->read by pull_request_policy for pull_request",
+				"issues:write->read by pull_request_policy for pull_request",
+			},
+		},
+		{
+			name:        "pull_request is allowed when configured",
+			pullRequest: EventPolicyAllow,
+			claims:      map[string]interface{}{"event_name": "pull_request"},
+			want:        scopes,
+		},
+		{
+			name:   "pull_request_target is allowed by default",
+			claims: map[string]interface{}{"event_name": "pull_request_target", "head_ref": "feature"},
+			want:   scopes,
+		},
+		{
+			name:              "pull_request_target is denied when configured",
+			pullRequestTarget: EventPolicyDeny,
+			claims:            map[string]interface{}{"event_name": "pull_request_target"},
+			wantErr:           true,
+			errContains:       "pull_request_target workflows",
+		},
+		{
+			name:              "pull_request_target is downgraded when configured",
+			pullRequestTarget: EventPolicyDowngrade,
+			claims:            map[string]interface{}{"event_name": "pull_request_target"},
+			want:              map[string]string{"contents": "read", "issues": "read", "metadata": "read"},
+			wantDowngrades: []string{
+				"contents:write->read by pull_request_target_policy for pull_request_target",
+				"issues:write->read by pull_request_target_policy for pull_request_target",
+			},
+		},
+		{
+			name:     "other CI providers are not affected",
+			provider: "gitlab",
+			claims:   map[string]interface{}{"event_name": "pull_request"},
+			want:     scopes,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Configure policies
+			config := testConfig()
+			if tt.pullRequest != "" {
+				config.PullRequestPolicy = tt.pullRequest
+			}
+			if tt.pullRequestTarget != "" {
+				config.PullRequestTargetPolicy = tt.pullRequestTarget
+			}
+			provider := tt.provider
+			if provider == "" {
+				provider = "github-actions"
+			}
+
+			// Step 2: Apply policies
+			got, downgrades, err := ApplyEventPolicy(config, &OIDCClaims{Repository: "owner/repo", Provider: provider, Claims: tt.claims}, scopes)
+
+			// Step 3: Verify
+			if tt.wantErr {
+				var policyErr *PolicyError
+				if !errors.As(err, &policyErr) || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("ApplyEventPolicy() error = %v, want *PolicyError containing %q", err, tt.errContains)
+				}
+				if policyErr.Details["policy"] != EventPolicyDeny {
+					t.Errorf("Details = %v, want deny policy", policyErr.Details)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyEventPolicy() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ApplyEventPolicy() = %v, want %v", got, tt.want)
+			}
+			var gotDowngrades []string
+			for _, d := range downgrades {
+				gotDowngrades = append(gotDowngrades, fmt.Sprintf("%s:%s->%s by %s for %s", d.Scope, d.Requested, d.Granted, d.Policy, d.EventName))
+			}
+			if !reflect.DeepEqual(gotDowngrades, tt.wantDowngrades) {
+				t.Errorf("ApplyEventPolicy() downgrades = %v, want %v", gotDowngrades, tt.wantDowngrades)
+			}
+		})
+	}
+}
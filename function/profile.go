@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// profileParam is the query parameter naming the scope profile of a token request.
+const profileParam = "profile"
+
+// profileNamePattern matches valid profile names, e.g. "release" or "dependency-update".
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// profileNames returns the names of the configured profiles in sorted order.
+func profileNames(config *Config) []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateProfile checks the "scope:level" entries of a profile.
+func validateProfile(entries []string) error {
+	if len(entries) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	scopes := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		scopeID, level, _ := strings.Cut(entry, ":")
+		if _, exists := scopes[scopeID]; exists {
+			return fmt.Errorf("duplicate scope '%s'", scopeID)
+		}
+		if err := ValidateScopes(map[string]string{scopeID: level}); err != nil {
+			return fmt.Errorf("invalid entry '%s': %w", entry, err)
+		}
+		scopes[scopeID] = level
+	}
+	return nil
+}
+
+// ExpandProfile returns the scopes of the named profile merged with the scopes requested explicitly.
+// Explicit scopes can add scopes to the profile, but not change the level of its scopes.
+func ExpandProfile(config *Config, name string, scopes map[string]string) (map[string]string, error) {
+	entries, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile '%s' (available: %v)", name, profileNames(config))
+	}
+
+	expanded := make(map[string]string, len(entries)+len(scopes))
+	for scopeID, permission := range scopes {
+		expanded[scopeID] = permission
+	}
+	for _, entry := range entries {
+		scopeID, level, _ := strings.Cut(entry, ":")
+		if permission, exists := scopes[scopeID]; exists && permission != level {
+			return nil, fmt.Errorf("scope '%s' is requested with '%s' but profile '%s' grants '%s'", scopeID, permission, name, level)
+		}
+		expanded[scopeID] = level
+	}
+	return expanded, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestExpandProfile tests that profiles expand to their scopes, merged with explicitly requested scopes.
+//
+// Test steps:
+//  1. Configure release and dependency-update profiles
+//  2. Expand the profile of each test case with the explicit scopes
+//  3. Verify the merged scopes, or the error
+func TestExpandProfile(t *testing.T) {
+	// Step 1: Configure profiles
+	config := testConfig()
+	config.Profiles = map[string][]string{
+		"release":           {"contents:write", "pull_requests:write"},
+		"dependency-update": {"contents:write", "pull_requests:write", "workflows:write"},
+	}
+
+	tests := []struct {
+		name        string
+		profile     string
+		scopes      map[string]string
+		want        map[string]string
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:    "profile only",
+			profile: "release",
+			scopes:  map[string]string{},
+			want:    map[string]string{"contents": "write", "pull_requests": "write"},
+		},
+		{
+			name:    "profile with additional scope",
+			profile: "release",
+			scopes:  map[string]string{"issues": "read"},
+			want:    map[string]string{"contents": "write", "pull_requests": "write", "issues": "read"},
+		},
+		{
+			name:    "scope repeated with the same level",
+			profile: "release",
+			scopes:  map[string]string{"contents": "write"},
+			want:    map[string]string{"contents": "write", "pull_requests": "write"},
+		},
+		{
+			name:        "scope repeated with another level",
+			profile:     "release",
+			scopes:      map[string]string{"contents": "read"},
+			wantErr:     true,
+			errContains: "scope 'contents' is requested with 'read' but profile 'release' grants 'write'",
+		},
+		{
+			name:        "unknown profile",
+			profile:     "deploy",
+			scopes:      map[string]string{},
+			wantErr:     true,
+			errContains: "unknown profile 'deploy' (available: [dependency-update release])",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 2: Expand profile
+			got, err := ExpandProfile(config, tt.profile, tt.scopes)
+
+			// Step 3: Verify result
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ExpandProfile() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandProfile() unexpected error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ExpandProfile() = %v, want %v", got, tt.want)
+			}
+			for scopeID, level := range tt.want {
+				if got[scopeID] != level {
+					t.Errorf("ExpandProfile()[%s] = %v, want %v", scopeID, got[scopeID], level)
+				}
+			}
+		})
+	}
+}
+
+// TestE2E_Profile tests requesting a token with a named profile.
+//
+// Test steps:
+//  1. Start the service with a release profile
+//  2. Request a token with the profile and an additional scope
+//  3. Verify the response contains the resolved scopes and the profile
+//  4. Verify unknown profiles and requests without scopes are rejected
+func TestE2E_Profile(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironmentWithConfig(t, func(config *Config) {
+		config.Profiles = map[string][]string{"release": {"contents:write"}}
+	})
+
+	// Step 2: Request token
+	status, body := env.requestToken(t, "owner/repo", "profile=release&issues=read")
+
+	// Step 3: Verify response
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	scopes, _ := body["scopes"].(map[string]interface{})
+	if len(scopes) != 2 || scopes["contents"] != "write" || scopes["issues"] != "read" {
+		t.Errorf("scopes = %v, want contents:write and issues:read", body["scopes"])
+	}
+	if body["profile"] != "release" {
+		t.Errorf("profile = %v, want release", body["profile"])
+	}
+
+	// Step 4: Verify rejected requests
+	if status, body := env.requestToken(t, "owner/repo", "profile=deploy"); status != http.StatusBadRequest ||
+		!strings.Contains(body["error"].(string), "unknown profile 'deploy'") {
+		t.Errorf("unknown profile = %v %v, want %v", status, body, http.StatusBadRequest)
+	}
+	if status, body := env.requestToken(t, "owner/repo", "profile=release&profile=release"); status != http.StatusBadRequest {
+		t.Errorf("duplicate profile status = %v, want %v (body: %v)", status, http.StatusBadRequest, body)
+	}
+}
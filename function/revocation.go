@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// Bounds of token lifetimes requested with ?lifetime= or configured with MaxTokenLifetime.
+// GitHub installation tokens expire after one hour.
+const (
+	minTokenLifetime = time.Minute
+	maxTokenLifetime = time.Hour
+)
+
+// lifetimeParam is the query parameter of the requested token lifetime, e.g. ?lifetime=10m.
+const lifetimeParam = "lifetime"
+
+// revocationInterval is the interval between checks for due token revocations.
+const revocationInterval = 10 * time.Second
+
+// Revocation is an issued token scheduled for revocation at the end of its lifetime.
+type Revocation struct {
+	// ID is the hex SHA-256 hash of the token.
+	ID string `json:"id"`
+
+	// Token is the installation token, encrypted at rest by FileRevocationStore.
+	Token      string `json:"token"`
+	Repository string `json:"repository"`
+
+	// RevokeAt is the end of the token lifetime, reported as expires_at of the token response.
+	RevokeAt time.Time `json:"revoke_at"`
+
+	// ExpiresAt is the expiry of the token on GitHub, after which the revocation is dropped.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ParseTokenLifetime parses the lifetime parameter, a duration between minTokenLifetime and maxTokenLifetime.
+func ParseTokenLifetime(value string) (time.Duration, error) {
+	lifetime, err := time.ParseDuration(value)
+	if err != nil || lifetime < minTokenLifetime || lifetime > maxTokenLifetime {
+		return 0, fmt.Errorf("invalid lifetime '%s' (must be a duration between %s and %s, e.g. 10m)", value, minTokenLifetime, maxTokenLifetime)
+	}
+	return lifetime, nil
+}
+
+// revocationUnavailable returns why tokens can't be revoked at the end of a lifetime, or "" if they can.
+// Revocations need a durable store, and a background loop that isn't throttled between requests;
+// in function mode, the revocation endpoint called by Cloud Scheduler and a store shared by all instances.
+func (c *Config) revocationUnavailable() string {
+	switch {
+	case c.Mode == ModeFunction && !c.RevocationEndpoint:
+		return "Cloud Run throttles the CPU between requests in function mode, so revocations require REVOCATION_ENDPOINT called by Cloud Scheduler"
+	case c.Mode == ModeFunction && !strings.HasPrefix(c.RevocationStoreFile, gcsLocationPrefix):
+		return "REVOCATION_STORE_FILE must be a Cloud Storage object (gs://bucket/object) in function mode, since instances don't share local files"
+	case c.Mode != ModeDev && c.RevocationStoreFile == "":
+		return "REVOCATION_STORE_FILE is required, so that revocations survive restarts"
+	}
+	return ""
+}
+
+// effectiveTokenLifetime returns the requested lifetime capped by MaxTokenLifetime.
+// Zero means the token lives until GitHub expires it.
+func effectiveTokenLifetime(config *Config, requested time.Duration) time.Duration {
+	if config.MaxTokenLifetime != 0 && (requested == 0 || requested > config.MaxTokenLifetime) {
+		return config.MaxTokenLifetime
+	}
+	return requested
+}
+
+// scheduleRevocation schedules the revocation of the token at the end of its lifetime,
+// unless GitHub expires it earlier. Returns the effective expiry of the token.
+func (s *Server) scheduleRevocation(ctx context.Context, token *github.InstallationToken, repository string, lifetime time.Duration) (time.Time, error) {
+	expiresAt := token.GetExpiresAt().Time
+	if lifetime == 0 {
+		return expiresAt, nil
+	}
+	revokeAt := time.Now().Add(lifetime).UTC().Truncate(time.Second)
+	if !revokeAt.Before(expiresAt) {
+		return expiresAt, nil
+	}
+
+	hash := sha256.Sum256([]byte(token.GetToken()))
+	revocation := &Revocation{
+		ID:         hex.EncodeToString(hash[:]),
+		Token:      token.GetToken(),
+		Repository: repository,
+		RevokeAt:   revokeAt,
+		ExpiresAt:  expiresAt,
+	}
+	if err := s.revocations.Add(ctx, revocation); err != nil {
+		return time.Time{}, err
+	}
+	return revokeAt, nil
+}
+
+// RunRevocations revokes tokens at the end of their lifetime, checking every revocationInterval
+// until ctx is cancelled. Overdue revocations, e.g. scheduled before a restart, are revoked right away.
+func (s *Server) RunRevocations(ctx context.Context) {
+	ticker := time.NewTicker(revocationInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.revokeDue(ctx, time.Now()); err != nil {
+			log.Printf("Failed to revoke due tokens: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RevocationsHandler handles POST /revocations requests of the Cloud Scheduler job of function mode,
+// revoking the tokens whose lifetime ended. Cloud Run IAM authenticates the job; other callers can only
+// make due revocations happen sooner, so the handler doesn't check the caller further.
+func (s *Server) RevocationsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := NewRequestLogger(r)
+
+	if err := s.revokeDue(r.Context(), time.Now()); err != nil {
+		logger.LogResponse(http.StatusServiceUnavailable, nil)
+		writeError(w, http.StatusServiceUnavailable, err.Error(), nil)
+		return
+	}
+
+	logger.LogResponse(http.StatusNoContent, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeDue revokes the tokens due at now. Failed revocations are retried on the next run
+// until GitHub expires the token. Returns an error if the scheduled revocations can't be listed.
+func (s *Server) revokeDue(ctx context.Context, now time.Time) error {
+	revocations, err := s.revocations.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list scheduled token revocations: %w", err)
+	}
+	for _, revocation := range revocations {
+		if now.Before(revocation.RevokeAt) {
+			continue
+		}
+		if now.Before(revocation.ExpiresAt) {
+			if err := s.revokeToken(ctx, revocation.Token); err != nil {
+				log.Printf("Failed to revoke token of %s due at %s, retrying: %v",
+					revocation.Repository, revocation.RevokeAt.Format(time.RFC3339), err)
+				continue
+			}
+			log.Printf("Revoked token of %s at the end of its lifetime (%s)", revocation.Repository, revocation.RevokeAt.Format(time.RFC3339))
+		}
+		if err := s.revocations.Remove(ctx, revocation.ID); err != nil {
+			log.Printf("Failed to remove scheduled token revocation: %v", err)
+		}
+	}
+	return nil
+}
+
+// revokeToken revokes an installation token with GitHub's revoke API.
+func (s *Server) revokeToken(ctx context.Context, token string) error {
+	return RevokeInstallationToken(ctx, s.config.GitHubAPIURL, token)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseTokenLifetime tests parsing of the lifetime parameter.
+//
+// Test steps:
+//  1. Parse the lifetime parameter of each test case
+//  2. Verify the lifetime or the error
+func TestParseTokenLifetime(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		want        time.Duration
+		wantErr     bool
+		errContains string
+	}{
+		{name: "minutes", value: "10m", want: 10 * time.Minute},
+		{name: "minimum", value: "1m", want: time.Minute},
+		{name: "maximum", value: "1h", want: time.Hour},
+		{name: "too short", value: "30s", wantErr: true, errContains: "invalid lifetime '30s'"},
+		{name: "too long", value: "2h", wantErr: true, errContains: "must be a duration between 1m0s and 1h0m0s"},
+		{name: "not a duration", value: "10", wantErr: true, errContains: "invalid lifetime '10'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Parse
+			got, err := ParseTokenLifetime(tt.value)
+
+			// Step 2: Verify
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ParseTokenLifetime() error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTokenLifetime() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTokenLifetime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestE2E_TokenLifetime tests that tokens with a lifetime are revoked when it ends, also after a restart.
+//
+// Test steps:
+//  1. Start the service with a file revocation store
+//  2. Request a token with a 5 minute lifetime and verify expires_at reports the end of the lifetime
+//  3. Verify the token isn't revoked before the end of its lifetime
+//  4. Verify a new server instance on the same store revokes the token at the end of its lifetime
+//  5. Verify invalid lifetimes are rejected, and lifetimes without revocation support
+func TestE2E_TokenLifetime(t *testing.T) {
+	ctx := context.Background()
+
+	// Step 1: Start environment
+	env := newE2EEnvironmentWithConfig(t, func(config *Config) { configureRevocationStore(t, config) })
+
+	// Step 2: Request token
+	requestedAt := time.Now()
+	status, body := env.requestToken(t, "owner/repo", "contents=read&lifetime=5m")
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	token, _ := body["token"].(string)
+	expiresAt, err := time.Parse(time.RFC3339, body["expires_at"].(string))
+	if err != nil || expiresAt.Before(requestedAt.Add(5*time.Minute-time.Second)) || expiresAt.After(requestedAt.Add(5*time.Minute+time.Second)) {
+		t.Errorf("expires_at = %v, want about 5 minutes from now", body["expires_at"])
+	}
+
+	// Step 3: Verify not revoked early
+	env.server.revokeDue(ctx, requestedAt.Add(4*time.Minute))
+	if !env.hasToken(token) {
+		t.Fatal("token revoked before the end of its lifetime")
+	}
+
+	// Step 4: Revoke after a restart
+	NewServer(env.server.config).revokeDue(ctx, requestedAt.Add(6*time.Minute))
+	if env.hasToken(token) {
+		t.Error("token not revoked at the end of its lifetime")
+	}
+	if revocations, _ := env.server.revocations.List(ctx); len(revocations) != 0 {
+		t.Errorf("revocations = %+v, want none", revocations)
+	}
+
+	// Step 5: Verify invalid lifetimes
+	status, body = env.requestToken(t, "owner/repo", "contents=read&lifetime=2h")
+	if errMsg, _ := body["error"].(string); status != http.StatusBadRequest || !strings.Contains(errMsg, "invalid lifetime '2h'") {
+		t.Errorf("lifetime=2h = %v %v, want %v", status, body, http.StatusBadRequest)
+	}
+	env.server.config.Mode = ModeFunction
+	status, body = env.requestToken(t, "owner/repo", "contents=read&lifetime=5m")
+	if errMsg, _ := body["error"].(string); status != http.StatusBadRequest || !strings.Contains(errMsg, "lifetime is not supported by this service") {
+		t.Errorf("lifetime in function mode = %v %v, want %v", status, body, http.StatusBadRequest)
+	}
+}
+
+// TestE2E_MaxTokenLifetime tests that MaxTokenLifetime caps requested and default token lifetimes.
+//
+// Test steps:
+//  1. Start the service with a maximum token lifetime of 10 minutes and a file revocation store
+//  2. Request tokens without a lifetime, with a longer and with a shorter lifetime
+//  3. Verify expires_at and the scheduled revocations
+func TestE2E_MaxTokenLifetime(t *testing.T) {
+	// Step 1: Start environment
+	env := newE2EEnvironmentWithConfig(t, func(config *Config) {
+		configureRevocationStore(t, config)
+		config.MaxTokenLifetime = 10 * time.Minute
+	})
+
+	tests := []struct {
+		query        string
+		wantLifetime time.Duration
+	}{
+		{query: "contents=read", wantLifetime: 10 * time.Minute},
+		{query: "contents=read&lifetime=30m", wantLifetime: 10 * time.Minute},
+		{query: "contents=read&lifetime=2m", wantLifetime: 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		// Step 2: Request token
+		requestedAt := time.Now()
+		status, body := env.requestToken(t, "owner/repo", tt.query)
+		if status != http.StatusOK {
+			t.Fatalf("%s: status = %v, want %v (body: %v)", tt.query, status, http.StatusOK, body)
+		}
+
+		// Step 3: Verify lifetime
+		want := requestedAt.Add(tt.wantLifetime)
+		expiresAt, err := time.Parse(time.RFC3339, body["expires_at"].(string))
+		if err != nil || expiresAt.Before(want.Add(-time.Second)) || expiresAt.After(want.Add(time.Second)) {
+			t.Errorf("%s: expires_at = %v, want about %v", tt.query, body["expires_at"], want.UTC().Format(time.RFC3339))
+		}
+	}
+	if revocations, _ := env.server.revocations.List(context.Background()); len(revocations) != len(tests) {
+		t.Errorf("revocations = %d, want %d", len(revocations), len(tests))
+	}
+}
+
+// hasToken reports whether the fake GitHub API accepts the installation token.
+func (e *e2eEnvironment) hasToken(token string) bool {
+	e.github.mu.Lock()
+	defer e.github.mu.Unlock()
+	_, ok := e.github.tokens[token]
+	return ok
+}
+
+// TestE2E_RevocationEndpoint tests that in function mode, tokens are revoked at the end of their lifetime
+// by the Cloud Scheduler job calling POST /revocations, with revocations shared in Cloud Storage.
+//
+// Test steps:
+//  1. Start the service in function mode with the revocation endpoint and a Cloud Storage revocation store
+//  2. Request a token with a lifetime and verify the endpoint doesn't revoke it early
+//  3. End the lifetime of the token and verify the endpoint revokes it
+//  4. Verify the endpoint fails while the revocation store is unavailable
+func TestE2E_RevocationEndpoint(t *testing.T) {
+	ctx := context.Background()
+
+	// Step 1: Start environment
+	_, options := newFakeGCS(t)
+	env := newE2EEnvironmentWithConfig(t, func(config *Config) {
+		configureRevocationStore(t, config)
+		config.Mode = ModeFunction
+		config.RevocationStoreFile = "gs://bucket/revocations.json"
+		config.RevocationEndpoint = true
+	})
+	store := &FileRevocationStore{document: newGCSDocument("bucket", "revocations.json", "revocation store", options...), key: testRevocationStoreKey}
+	env.server.revocations = store
+	runRevocations := func() int {
+		resp, err := http.Post(env.service.URL+"/revocations", "", nil)
+		if err != nil {
+			t.Fatalf("POST /revocations failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Step 2: Request token
+	status, body := env.requestToken(t, "owner/repo", "contents=read&lifetime=5m")
+	if status != http.StatusOK {
+		t.Fatalf("status = %v, want %v (body: %v)", status, http.StatusOK, body)
+	}
+	token, _ := body["token"].(string)
+	if status := runRevocations(); status != http.StatusNoContent || !env.hasToken(token) {
+		t.Fatalf("POST /revocations = %v, token revoked = %v, want %v before the end of the lifetime", status, !env.hasToken(token), http.StatusNoContent)
+	}
+
+	// Step 3: End lifetime
+	revocations, err := store.List(ctx)
+	if err != nil || len(revocations) != 1 {
+		t.Fatalf("List() = %+v, %v, want the scheduled revocation", revocations, err)
+	}
+	revocations[0].RevokeAt = time.Now().Add(-time.Second)
+	if err := store.Add(ctx, &revocations[0]); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if status := runRevocations(); status != http.StatusNoContent || env.hasToken(token) {
+		t.Errorf("POST /revocations = %v, token revoked = %v, want %v and revoked", status, !env.hasToken(token), http.StatusNoContent)
+	}
+	if revocations, _ := store.List(ctx); len(revocations) != 0 {
+		t.Errorf("revocations = %+v, want none", revocations)
+	}
+
+	// Step 4: Store unavailable
+	env.server.revocations = &FileRevocationStore{document: store.document, key: []byte("invalid")}
+	if err := store.Add(ctx, &Revocation{ID: "r1", Token: "ghs_other", RevokeAt: time.Now()}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if status := runRevocations(); status != http.StatusServiceUnavailable {
+		t.Errorf("POST /revocations = %v, want %v", status, http.StatusServiceUnavailable)
+	}
+}
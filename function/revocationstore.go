@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// RevocationStore persists the tokens scheduled for revocation.
+type RevocationStore interface {
+	// Add schedules the revocation of a token.
+	Add(ctx context.Context, revocation *Revocation) error
+
+	// List returns copies of all scheduled revocations.
+	List(ctx context.Context) ([]Revocation, error)
+
+	// Remove deletes the revocation of the token with the ID, if scheduled.
+	Remove(ctx context.Context, id string) error
+}
+
+// revocationStoreKeySize is the size of the AES-256 key of REVOCATION_STORE_KEY.
+const revocationStoreKeySize = 32
+
+// NewRevocationStore returns the revocation store of the configuration: a FileRevocationStore if
+// RevocationStoreFile is set, a MemoryRevocationStore otherwise (dev mode only, see Config.Validate).
+func NewRevocationStore(config *Config) RevocationStore {
+	if config.RevocationStoreFile != "" {
+		// An invalid key is reported by Validate, and fails every scheduled revocation
+		key, _ := base64.StdEncoding.DecodeString(config.RevocationStoreKey)
+		return NewFileRevocationStore(config.RevocationStoreFile, key)
+	}
+	return NewMemoryRevocationStore()
+}
+
+// MemoryRevocationStore is an in-memory RevocationStore. Scheduled revocations are lost on restart.
+type MemoryRevocationStore struct {
+	mu          sync.Mutex
+	revocations map[string]Revocation
+}
+
+// NewMemoryRevocationStore creates an empty in-memory store.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{revocations: make(map[string]Revocation)}
+}
+
+// Add implements RevocationStore.
+func (s *MemoryRevocationStore) Add(ctx context.Context, revocation *Revocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revocations[revocation.ID] = *revocation
+	return nil
+}
+
+// List implements RevocationStore.
+func (s *MemoryRevocationStore) List(ctx context.Context) ([]Revocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedRevocations(s.revocations), nil
+}
+
+// Remove implements RevocationStore.
+func (s *MemoryRevocationStore) Remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.revocations, id)
+	return nil
+}
+
+// sortedRevocations returns the revocations ordered by RevokeAt.
+func sortedRevocations(revocations map[string]Revocation) []Revocation {
+	sorted := make([]Revocation, 0, len(revocations))
+	for _, revocation := range revocations {
+		sorted = append(sorted, revocation)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].RevokeAt.Before(sorted[j].RevokeAt) })
+	return sorted
+}
+
+// FileRevocationStore is a RevocationStore persisting all scheduled revocations in a JSON document,
+// so that tokens issued before a restart are still revoked: a local file, rewritten atomically with mode 0600,
+// for a single instance, or a Cloud Storage object (gs://bucket/object) updated with compare-and-swap,
+// shared by all instances. Tokens are encrypted with AES-256-GCM, bound to their revocation ID.
+type FileRevocationStore struct {
+	document jsonDocument
+	key      []byte
+}
+
+// NewFileRevocationStore creates a store persisting scheduled revocations in the file or Cloud Storage object
+// at path, with tokens encrypted by the 32-byte key. The file is created on the first scheduled revocation.
+func NewFileRevocationStore(path string, key []byte) *FileRevocationStore {
+	return &FileRevocationStore{document: newJSONDocument(path, "revocation store"), key: key}
+}
+
+// Add implements RevocationStore.
+func (s *FileRevocationStore) Add(ctx context.Context, revocation *Revocation) error {
+	return s.update(ctx, func(revocations map[string]Revocation) error {
+		revocations[revocation.ID] = *revocation
+		return nil
+	})
+}
+
+// List implements RevocationStore.
+func (s *FileRevocationStore) List(ctx context.Context) ([]Revocation, error) {
+	sealed := make(map[string]Revocation)
+	if err := s.document.read(ctx, &sealed); err != nil {
+		return nil, err
+	}
+	revocations, err := s.open(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return sortedRevocations(revocations), nil
+}
+
+// Remove implements RevocationStore.
+func (s *FileRevocationStore) Remove(ctx context.Context, id string) error {
+	return s.update(ctx, func(revocations map[string]Revocation) error {
+		if _, ok := revocations[id]; !ok {
+			return errDocumentUnchanged
+		}
+		delete(revocations, id)
+		return nil
+	})
+}
+
+// update applies modify to the decrypted revocations of the document and stores them encrypted,
+// unless modify returns an error.
+func (s *FileRevocationStore) update(ctx context.Context, modify func(revocations map[string]Revocation) error) error {
+	newValue := func() interface{} {
+		sealed := make(map[string]Revocation)
+		return &sealed
+	}
+	return s.document.update(ctx, newValue, func(v interface{}) error {
+		revocations, err := s.open(*v.(*map[string]Revocation))
+		if err != nil {
+			return err
+		}
+		if err := modify(revocations); err != nil {
+			return err
+		}
+		sealed, err := s.seal(revocations)
+		if err != nil {
+			return err
+		}
+		*v.(*map[string]Revocation) = sealed
+		return nil
+	})
+}
+
+// open decrypts the tokens of the revocations read from the document.
+func (s *FileRevocationStore) open(sealed map[string]Revocation) (map[string]Revocation, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	revocations := make(map[string]Revocation, len(sealed))
+	for id, revocation := range sealed {
+		ciphertext, err := base64.StdEncoding.DecodeString(revocation.Token)
+		if err != nil || len(ciphertext) < aead.NonceSize() {
+			return nil, fmt.Errorf("failed to decrypt token of revocation %s: invalid ciphertext", id)
+		}
+		token, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], []byte(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt token of revocation %s: %w", id, err)
+		}
+		revocation.Token = string(token)
+		revocations[id] = revocation
+	}
+	return revocations, nil
+}
+
+// seal encrypts the tokens of the revocations written to the document.
+func (s *FileRevocationStore) seal(revocations map[string]Revocation) (map[string]Revocation, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	sealed := make(map[string]Revocation, len(revocations))
+	for id, revocation := range revocations {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		revocation.Token = base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(revocation.Token), []byte(id)))
+		sealed[id] = revocation
+	}
+	return sealed, nil
+}
+
+// aead returns the AES-256-GCM cipher of the store key.
+func (s *FileRevocationStore) aead() (cipher.AEAD, error) {
+	if len(s.key) != revocationStoreKeySize {
+		return nil, fmt.Errorf("invalid revocation store key: must be %d bytes", revocationStoreKeySize)
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
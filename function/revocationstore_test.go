@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRevocationStoreKey is the AES-256 key of file revocation stores in tests.
+var testRevocationStoreKey = bytes.Repeat([]byte{7}, revocationStoreKeySize)
+
+// configureRevocationStore configures a file revocation store in a temporary directory, in standalone mode.
+func configureRevocationStore(t *testing.T, config *Config) {
+	config.Mode = ModeStandalone
+	config.RevocationStoreFile = filepath.Join(t.TempDir(), "revocations.json")
+	config.RevocationStoreKey = base64.StdEncoding.EncodeToString(testRevocationStoreKey)
+}
+
+// TestRevocationStores tests the in-memory, file-backed and Cloud Storage revocation stores.
+//
+// Test steps:
+//  1. Create the store of the test case
+//  2. Add two revocations and verify List returns them ordered by RevokeAt
+//  3. Remove a revocation and verify unknown IDs are ignored
+func TestRevocationStores(t *testing.T) {
+	tests := []struct {
+		name     string
+		newStore func(t *testing.T) RevocationStore
+	}{
+		{
+			name:     "memory",
+			newStore: func(t *testing.T) RevocationStore { return NewMemoryRevocationStore() },
+		},
+		{
+			name: "file",
+			newStore: func(t *testing.T) RevocationStore {
+				return NewFileRevocationStore(filepath.Join(t.TempDir(), "revocations.json"), testRevocationStoreKey)
+			},
+		},
+		{
+			name: "cloud storage",
+			newStore: func(t *testing.T) RevocationStore {
+				_, options := newFakeGCS(t)
+				document := newGCSDocument("bucket", "revocations.json", "revocation store", options...)
+				return &FileRevocationStore{document: document, key: testRevocationStoreKey}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// Step 1: Create store
+			store := tt.newStore(t)
+
+			// Step 2: Add revocations
+			now := time.Now().UTC()
+			for _, revocation := range []*Revocation{
+				{ID: "r1", Token: "ghs_1", Repository: "owner/repo", RevokeAt: now.Add(10 * time.Minute), ExpiresAt: now.Add(time.Hour)},
+				{ID: "r2", Token: "ghs_2", Repository: "owner/other", RevokeAt: now.Add(5 * time.Minute), ExpiresAt: now.Add(time.Hour)},
+			} {
+				if err := store.Add(ctx, revocation); err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+			revocations, err := store.List(ctx)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(revocations) != 2 || revocations[0].ID != "r2" || revocations[1].ID != "r1" {
+				t.Errorf("List() = %+v, want r2 and r1", revocations)
+			}
+
+			// Step 3: Remove revocation
+			if err := store.Remove(ctx, "r2"); err != nil {
+				t.Fatalf("Remove() error = %v", err)
+			}
+			if err := store.Remove(ctx, "unknown"); err != nil {
+				t.Errorf("Remove() unknown error = %v, want nil", err)
+			}
+			if revocations, _ := store.List(ctx); len(revocations) != 1 || revocations[0].Token != "ghs_1" {
+				t.Errorf("List() after Remove() = %+v, want r1", revocations)
+			}
+		})
+	}
+}
+
+// TestFileRevocationStore_Persistence tests that scheduled revocations survive a new file store instance,
+// with the tokens encrypted in the file.
+//
+// Test steps:
+//  1. Add a revocation with a file store
+//  2. Verify the file doesn't contain the token
+//  3. Open the file with a new store and verify the revocation is loaded
+//  4. Verify a store with another key fails to load it
+func TestFileRevocationStore_Persistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "revocations.json")
+
+	// Step 1: Add revocation
+	revokeAt := time.Now().UTC().Add(10 * time.Minute).Truncate(time.Second)
+	revocation := &Revocation{ID: "r1", Token: "ghs_secret1", Repository: "owner/repo", RevokeAt: revokeAt}
+	if err := NewFileRevocationStore(path, testRevocationStoreKey).Add(ctx, revocation); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	// Step 2: Verify encrypted
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read store file: %v", err)
+	}
+	if strings.Contains(string(data), "ghs_secret1") {
+		t.Errorf("store file = %s, must not contain the token", data)
+	}
+
+	// Step 3: Open with new store
+	revocations, err := NewFileRevocationStore(path, testRevocationStoreKey).List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(revocations) != 1 || revocations[0].Token != "ghs_secret1" || !revocations[0].RevokeAt.Equal(revokeAt) {
+		t.Errorf("List() = %+v, want persisted revocation", revocations)
+	}
+
+	// Step 4: Open with another key
+	if _, err := NewFileRevocationStore(path, bytes.Repeat([]byte{8}, revocationStoreKeySize)).List(ctx); err == nil {
+		t.Error("List() with another key error = nil, want decryption error")
+	}
+}
+
+// TestFileRevocationStore_CloudStorageInstances tests that instances sharing a Cloud Storage revocation store
+// keep the revocations scheduled by all of them, with the tokens encrypted in the object.
+//
+// Test steps:
+//  1. Create stores of the same object, one per instance
+//  2. Add a revocation concurrently from each instance
+//  3. Verify every revocation is listed and no token is stored in plain text
+func TestFileRevocationStore_CloudStorageInstances(t *testing.T) {
+	ctx := context.Background()
+	const instances = 4
+
+	// Step 1: Create stores
+	fake, options := newFakeGCS(t)
+	newStore := func() *FileRevocationStore {
+		document := newGCSDocument("bucket", "revocations.json", "revocation store", options...)
+		return &FileRevocationStore{document: document, key: testRevocationStoreKey}
+	}
+
+	// Step 2: Add concurrently
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+	for i := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- newStore().Add(ctx, &Revocation{ID: fmt.Sprintf("r%d", i), Token: fmt.Sprintf("ghs_secret%d", i), RevokeAt: time.Now()})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	// Step 3: Verify all revocations and encryption
+	revocations, err := newStore().List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(revocations) != instances {
+		t.Errorf("List() = %d revocations, want %d", len(revocations), instances)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if data := fake.objects["bucket/revocations.json"].data; strings.Contains(string(data), "ghs_secret") {
+		t.Errorf("store object = %s, must not contain tokens", data)
+	}
+}
@@ -1,32 +1,120 @@
 package main
 
-// AllowedScopes defines repository permission scopes and their allowed permission levels.
-// Only repository-level permissions are supported; organization and account permissions are not allowed.
-var AllowedScopes = map[string][]string{
-	// Read and write permissions
-	"actions":            {"read", "write"},
-	"attestations":       {"read", "write"},
-	"checks":             {"read", "write"},
-	"contents":           {"read", "write"},
-	"dependabot_secrets": {"read", "write"},
-	"deployments":        {"read", "write"},
-	"discussions":        {"read", "write"},
-	"environments":       {"read", "write"},
-	"issues":             {"read", "write"},
-	"merge_queues":       {"read", "write"},
-	"packages":           {"read", "write"},
-	"pages":              {"read", "write"},
-	"projects":           {"read", "write"},
-	"pull_requests":      {"read", "write"},
-	"secrets":            {"read", "write"},
-	"statuses":           {"read", "write"},
-	"workflows":          {"read", "write"},
-
-	// Read-only permissions (security and administrative)
-	"administration":  {"read"}, // Repository administration settings
-	"secret_scanning": {"read"}, // Secret scanning alerts
+import "github.com/google/go-github/v81/github"
+
+// Permission levels of repository permission scopes.
+var (
+	readOnly  = []string{"read"}
+	readWrite = []string{"read", "write"}
+)
+
+// ScopeDefinition defines a repository permission scope.
+type ScopeDefinition struct {
+	// ID is the scope ID of token requests, e.g. "pull_requests".
+	ID string
+
+	// Levels are the allowed permission levels, ordered from read to write.
+	Levels []string
+
+	// Aliases are other names of the scope accepted in token requests, e.g. the GitHub App permission name.
+	// Hyphenated names of workflow permissions blocks are normalized to underscores first.
+	Aliases []string
+
+	// Permission returns the field of the scope in GitHub installation permissions.
+	Permission func(permissions *github.InstallationPermissions) **string
+}
+
+// ScopeDefinitions defines all supported repository permission scopes, ordered by scope ID.
+// AllowedScopes, the token request mapping and the verification of granted permissions are derived from it.
+// Organization permissions are defined separately in OrganizationScopeDefinitions; account permissions are not allowed.
+var ScopeDefinitions = []ScopeDefinition{
+	{ID: "actions", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Actions }},
+	{ID: "actions_variables", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.ActionsVariables }},
+	{ID: "administration", Levels: readOnly, Permission: func(p *github.InstallationPermissions) **string { return &p.Administration }},
+	{ID: "attestations", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Attestations }},
+	{ID: "checks", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Checks }},
+	{ID: "codespaces", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Codespaces }},
+	{ID: "contents", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Contents }},
+	{ID: "dependabot_secrets", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.DependabotSecrets }},
+	{ID: "deployments", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Deployments }},
+	{ID: "discussions", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Discussions }},
+	{ID: "environments", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Environments }},
+	{ID: "issues", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Issues }},
+	{ID: "merge_queues", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.MergeQueues }},
+	{ID: "metadata", Levels: readOnly, Permission: func(p *github.InstallationPermissions) **string { return &p.Metadata }},
+	{ID: "packages", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Packages }},
+	{ID: "pages", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Pages }},
+	{ID: "projects", Levels: readWrite, Aliases: []string{"repository_projects"}, Permission: func(p *github.InstallationPermissions) **string { return &p.RepositoryProjects }},
+	{ID: "pull_requests", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.PullRequests }},
+	{ID: "repository_advisories", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.RepositoryAdvisories }},
+	{ID: "repository_custom_properties", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.RepositoryCustomProperties }},
+	{ID: "repository_hooks", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.RepositoryHooks }},
+	{ID: "secret_scanning", Levels: readOnly, Aliases: []string{"secret_scanning_alerts"}, Permission: func(p *github.InstallationPermissions) **string { return &p.SecretScanningAlerts }},
+	{ID: "secrets", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Secrets }},
+	{ID: "security_events", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.SecurityEvents }},
+	{ID: "single_file", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.SingleFile }},
+	{ID: "statuses", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Statuses }},
+	{ID: "vulnerability_alerts", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.VulnerabilityAlerts }},
+	{ID: "workflows", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Workflows }},
 }
 
+// OrganizationScopeDefinitions defines the supported organization permission scopes, ordered by scope ID.
+// They are only issued to the repositories and workflows granted by the organization policy
+// (organization_repositories and organization_workflows), and are never part of AllowedScopes.
+var OrganizationScopeDefinitions = []ScopeDefinition{
+	{ID: "members", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.Members }},
+	{ID: "organization_actions_variables", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationActionsVariables }},
+	{ID: "organization_administration", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationAdministration }},
+	{ID: "organization_custom_properties", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationCustomProperties }},
+	{ID: "organization_custom_roles", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationCustomRoles }},
+	{ID: "organization_hooks", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationHooks }},
+	{ID: "organization_packages", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationPackages }},
+	{ID: "organization_plan", Levels: readOnly, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationPlan }},
+	{ID: "organization_projects", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationProjects }},
+	{ID: "organization_secrets", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationSecrets }},
+	{ID: "organization_self_hosted_runners", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationSelfHostedRunners }},
+	{ID: "organization_user_blocking", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.OrganizationUserBlocking }},
+	{ID: "team_discussions", Levels: readWrite, Permission: func(p *github.InstallationPermissions) **string { return &p.TeamDiscussions }},
+}
+
+// scopeDefinitionsByID indexes ScopeDefinitions and OrganizationScopeDefinitions by scope ID.
+var scopeDefinitionsByID = func() map[string]ScopeDefinition {
+	definitions := make(map[string]ScopeDefinition, len(ScopeDefinitions)+len(OrganizationScopeDefinitions))
+	for _, definition := range append(append([]ScopeDefinition(nil), ScopeDefinitions...), OrganizationScopeDefinitions...) {
+		definitions[definition.ID] = definition
+	}
+	return definitions
+}()
+
+// scopeAliases maps the Aliases of ScopeDefinitions to scope IDs.
+var scopeAliases = func() map[string]string {
+	aliases := make(map[string]string)
+	for _, definition := range ScopeDefinitions {
+		for _, alias := range definition.Aliases {
+			aliases[alias] = definition.ID
+		}
+	}
+	return aliases
+}()
+
+// AllowedScopes maps the scope IDs of ScopeDefinitions to their allowed permission levels.
+var AllowedScopes = func() map[string][]string {
+	scopes := make(map[string][]string, len(ScopeDefinitions))
+	for _, definition := range ScopeDefinitions {
+		scopes[definition.ID] = definition.Levels
+	}
+	return scopes
+}()
+
+// OrganizationScopes maps the scope IDs of OrganizationScopeDefinitions to their allowed permission levels.
+var OrganizationScopes = func() map[string][]string {
+	scopes := make(map[string][]string, len(OrganizationScopeDefinitions))
+	for _, definition := range OrganizationScopeDefinitions {
+		scopes[definition.ID] = definition.Levels
+	}
+	return scopes
+}()
+
 // BlacklistedScopes defines scopes that are explicitly forbidden.
 // Currently empty but can be used to block specific scopes for security requirements.
 var BlacklistedScopes = map[string]bool{}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -80,6 +81,7 @@ func TestAllowedScopes_SecurityScopesAreReadOnly(t *testing.T) {
 	// Step 1: Define read-only scopes
 	readOnlyScopes := []string{
 		"administration",
+		"metadata",
 		"secret_scanning",
 	}
 
@@ -111,8 +113,10 @@ func TestAllowedScopes_ReadWriteScopesHaveBoth(t *testing.T) {
 	// Step 1: Define read-write scopes
 	readWriteScopes := []string{
 		"actions",
+		"actions_variables",
 		"attestations",
 		"checks",
+		"codespaces",
 		"contents",
 		"dependabot_secrets",
 		"deployments",
@@ -124,8 +128,14 @@ func TestAllowedScopes_ReadWriteScopesHaveBoth(t *testing.T) {
 		"pages",
 		"projects",
 		"pull_requests",
+		"repository_advisories",
+		"repository_custom_properties",
+		"repository_hooks",
 		"secrets",
+		"security_events",
+		"single_file",
 		"statuses",
+		"vulnerability_alerts",
 		"workflows",
 	}
 
@@ -165,7 +175,7 @@ func TestAllowedScopes_ReadWriteScopesHaveBoth(t *testing.T) {
 //  3. Verify counts match
 func TestAllowedScopes_ExpectedCount(t *testing.T) {
 	// Step 1: Define expected count (based on scopes.go content)
-	expectedCount := 19
+	expectedCount := 28
 
 	// Step 2 & 3: Verify count matches
 	if len(AllowedScopes) != expectedCount {
@@ -279,3 +289,52 @@ func TestAllowedScopes_PermissionsAreOrdered(t *testing.T) {
 		}
 	}
 }
+
+// TestScopeDefinitions_MapToDistinctPermissions verifies each scope maps to its own installation permission.
+// A scope mapped to the field of another scope would request or verify the wrong permission.
+//
+// Test steps:
+//  1. Iterate through ScopeDefinitions and OrganizationScopeDefinitions in order
+//  2. Verify scope IDs are sorted and unique, and not both repository and organization scopes
+//  3. Build the installation permissions of the scope alone
+//  4. Verify exactly one permission is set, and no other scope set the same one
+func TestScopeDefinitions_MapToDistinctPermissions(t *testing.T) {
+	fields := make(map[string]string)
+
+	// Step 1: Iterate through definitions
+	for _, definitions := range [][]ScopeDefinition{ScopeDefinitions, OrganizationScopeDefinitions} {
+		for i, definition := range definitions {
+			// Step 2: Verify order
+			if i > 0 && definitions[i-1].ID >= definition.ID {
+				t.Errorf("scope %q is not sorted after %q", definition.ID, definitions[i-1].ID)
+			}
+			if _, ok := AllowedScopes[definition.ID]; ok && OrganizationScopes[definition.ID] != nil {
+				t.Errorf("scope %q is both a repository and an organization scope", definition.ID)
+			}
+
+			// Step 3: Build permissions
+			permissions, err := BuildInstallationPermissions(map[string]string{definition.ID: "read"})
+			if err != nil {
+				t.Fatalf("BuildInstallationPermissions(%q) error = %v", definition.ID, err)
+			}
+
+			// Step 4: Verify a single distinct permission
+			var set map[string]string
+			data, _ := json.Marshal(permissions)
+			_ = json.Unmarshal(data, &set)
+			if len(set) != 1 {
+				t.Errorf("scope %q sets permissions %v, want one", definition.ID, set)
+				continue
+			}
+			for field := range set {
+				if other, exists := fields[field]; exists {
+					t.Errorf("scopes %q and %q both set permission %q", other, definition.ID, field)
+				}
+				fields[field] = definition.ID
+			}
+			if err := VerifyRequestedScopes(map[string]string{definition.ID: "read"}, permissions); err != nil {
+				t.Errorf("VerifyRequestedScopes(%q) error = %v", definition.ID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v81/github"
+)
+
+// keyCacheTTL is how long loaded App keys are reused before their source (e.g. Secret Manager) is read again.
+const keyCacheTTL = 5 * time.Minute
+
+// errAppAuthentication is returned by authenticateApp when a GitHub App client can't be created.
+var errAppAuthentication = errors.New("failed to authenticate as GitHub App")
+
+// Server holds the dependencies of the HTTP handlers.
+type Server struct {
+	config *Config
+
+	// oidc verifies OIDC tokens and maps them to GitHub repositories.
+	oidc *OIDCVerifier
+
+	// approvals stores approval requests; nil if no scopes require approval.
+	approvals ApprovalStore
+
+	// revocations stores the tokens to revoke at the end of their lifetime.
+	revocations RevocationStore
+
+	// signerMu guards signer, the Cloud KMS or PKCS#11 signer created on first use.
+	signerMu sync.Mutex
+	signer   crypto.Signer
+
+	// keysMu guards keys, the candidate App keys loaded at keysLoadedAt and reused for keyCacheTTL.
+	keysMu       sync.Mutex
+	keys         []AppKey
+	keysLoadedAt time.Time
+
+	// activeKey is the candidate key GitHub last accepted.
+	activeKey atomic.Pointer[AppKey]
+
+	// draining is set once a graceful shutdown has started.
+	draining atomic.Bool
+}
+
+// NewServer creates a Server for the given configuration.
+// The configuration is expected to be validated already.
+func NewServer(config *Config) *Server {
+	return &Server{
+		config:      config,
+		oidc:        NewOIDCVerifier(config.OIDCProviders),
+		approvals:   NewApprovalStore(config),
+		revocations: NewRevocationStore(config),
+	}
+}
+
+// Routes returns the HTTP handler serving all endpoints of the service.
+// Health, readiness, version and scope discovery endpoints, the approval endpoints if approvals are enabled,
+// the App webhook endpoint if a webhook secret is configured, and the revocation endpoint if enabled,
+// are served on their own paths; every other path is handled by TokenHandler.
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", HealthzHandler)
+	mux.HandleFunc("/readyz", s.ReadyzHandler)
+	mux.HandleFunc("/version", VersionHandler)
+	mux.HandleFunc("GET /scopes", s.ScopesHandler)
+	if s.approvals != nil {
+		mux.HandleFunc("GET /approvals/{id}", s.ApprovalStatusHandler)
+		mux.HandleFunc("GET /approvals/{id}/{action}", s.ApprovalConfirmHandler)
+		mux.HandleFunc("POST /approvals/{id}/{action}", s.ApprovalDecisionHandler)
+	}
+	if s.config.GitHubWebhookSecret != "" {
+		mux.HandleFunc("POST /webhook", s.WebhookHandler)
+	}
+	if s.config.RevocationEndpoint {
+		mux.HandleFunc("POST /revocations", s.RevocationsHandler)
+	}
+	mux.HandleFunc("/", s.TokenHandler)
+	return mux
+}
+
+// loadKeys returns the candidate GitHub App keys, preferred key first.
+// Keys are cached for keyCacheTTL, or until GitHub rejects one of them (see authenticateApp),
+// so that token requests and readiness probes don't read the key source every time.
+func (s *Server) loadKeys(ctx context.Context) ([]AppKey, error) {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+
+	if s.keys != nil && time.Since(s.keysLoadedAt) < keyCacheTTL {
+		return s.keys, nil
+	}
+	keys, err := s.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.keys, s.keysLoadedAt = keys, time.Now()
+	return keys, nil
+}
+
+// invalidateKeys drops the cached App keys, so that the next loadKeys reads the key source again.
+func (s *Server) invalidateKeys() {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	s.keys = nil
+}
+
+// fetchKeys reads the candidate GitHub App keys from the first configured source: the inline private keys,
+// Cloud KMS, a PKCS#11 token, or the newest enabled private key versions stored in Secret Manager.
+func (s *Server) fetchKeys(ctx context.Context) ([]AppKey, error) {
+	switch {
+	case s.config.PrivateKey != "":
+		privateKeys, err := ParsePrivateKeys([]byte(s.config.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]AppKey, 0, len(privateKeys))
+		for i, privateKey := range privateKeys {
+			key, err := NewAppKey(fmt.Sprintf("inline/%d", i+1), privateKey)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	case s.config.KMSKeyVersion != "" || s.config.PKCS11Module != "":
+		signer, err := s.loadExternalSigner(ctx)
+		if err != nil {
+			return nil, err
+		}
+		id := s.config.KMSKeyVersion
+		if id == "" {
+			id = "pkcs11:" + s.config.PKCS11TokenLabel + "/" + s.config.PKCS11KeyLabel
+		}
+		key, err := NewAppKey(id, signer)
+		if err != nil {
+			return nil, err
+		}
+		return []AppKey{key}, nil
+	case s.config.ProjectID == "":
+		return nil, fmt.Errorf("GCP project ID not configured")
+	}
+
+	return GetPrivateKeys(ctx, s.config.ProjectID, s.config.KeyCandidates)
+}
+
+// authenticateApp returns a GitHub client authenticated as the App with the first candidate key GitHub accepts.
+// check is called with the client of each key in order; when it fails because GitHub rejects the JWT
+// (401 Bad credentials) and more keys are left, the next key is tried. The error of check is returned as is.
+// A rejected key drops the cached keys, so that keys rotated since they were loaded are picked up.
+// JWT signing and client creation errors are wrapped in errAppAuthentication.
+func (s *Server) authenticateApp(keys []AppKey, check func(client *github.Client) error) (*github.Client, error) {
+	var err error
+	for i, key := range keys {
+		jwtToken, jwtErr := CreateJWT(key.Signer, s.config.GitHubAppID)
+		if jwtErr != nil {
+			return nil, fmt.Errorf("%w: failed to create JWT: %v", errAppAuthentication, jwtErr)
+		}
+		client, clientErr := NewGitHubClientWithJWTForURL(jwtToken, s.config.GitHubAPIURL)
+		if clientErr != nil {
+			return nil, fmt.Errorf("%w: %v", errAppAuthentication, clientErr)
+		}
+
+		err = check(client)
+		if err != nil && isBadCredentialsError(err) {
+			s.invalidateKeys()
+			if i < len(keys)-1 {
+				log.Printf("GitHub rejected App key %s (%s), falling back to the next key", key.ID, key.Fingerprint)
+				continue
+			}
+		}
+		if err == nil {
+			s.setActiveKey(key)
+		}
+		return client, err
+	}
+	return nil, err
+}
+
+// setActiveKey records the key GitHub last accepted, logging when it changes.
+func (s *Server) setActiveKey(key AppKey) {
+	if previous := s.activeKey.Swap(&key); previous == nil || previous.ID != key.ID {
+		log.Printf("Active GitHub App key: %s (%s)", key.ID, key.Fingerprint)
+	}
+}
+
+// loadExternalSigner returns the Cloud KMS or PKCS#11 signer, creating it on first use.
+// The signer holds a client connection or token session, so it is reused across requests.
+func (s *Server) loadExternalSigner(ctx context.Context) (crypto.Signer, error) {
+	s.signerMu.Lock()
+	defer s.signerMu.Unlock()
+
+	if s.signer != nil {
+		return s.signer, nil
+	}
+
+	if s.config.KMSKeyVersion != "" {
+		signer, err := NewKMSSigner(ctx, s.config.KMSKeyVersion)
+		if err != nil {
+			return nil, err
+		}
+		s.signer = signer
+	} else {
+		signer, err := NewPKCS11Signer(s.config.PKCS11Module, s.config.PKCS11TokenLabel, s.config.PKCS11PIN, s.config.PKCS11KeyLabel)
+		if err != nil {
+			return nil, err
+		}
+		s.signer = signer
+	}
+
+	return s.signer, nil
+}
+
+// startDraining marks the server as shutting down, so that readiness checks fail
+// and load balancers stop routing new requests while in-flight requests complete.
+func (s *Server) startDraining() {
+	s.draining.Store(true)
+}
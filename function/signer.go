@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// kmsSignTimeout is the timeout of a single Cloud KMS signing request.
+const kmsSignTimeout = 10 * time.Second
+
+// signingMethodRS256Signer signs RS256 JWTs with a crypto.Signer, so that the private key
+// can stay in a key management system. jwt.SigningMethodRS256 only accepts *rsa.PrivateKey.
+var signingMethodRS256Signer jwt.SigningMethod = signingMethodSigner{}
+
+// signingMethodSigner is an RS256 jwt.SigningMethod backed by a crypto.Signer.
+type signingMethodSigner struct{}
+
+// Alg returns the JWT algorithm name.
+func (signingMethodSigner) Alg() string {
+	return jwt.SigningMethodRS256.Alg()
+}
+
+// Sign signs the SHA-256 digest of signingString with key, which must be a crypto.Signer.
+func (signingMethodSigner) Sign(signingString string, key interface{}) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, jwt.ErrInvalidKeyType
+	}
+	digest := sha256.Sum256([]byte(signingString))
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// Verify verifies an RS256 signature with an *rsa.PublicKey.
+func (signingMethodSigner) Verify(signingString string, signature []byte, key interface{}) error {
+	return jwt.SigningMethodRS256.Verify(signingString, signature, key)
+}
+
+// KMSClient defines the Cloud KMS API methods used by KMSSigner.
+type KMSClient interface {
+	GetPublicKey(ctx context.Context, req *kmspb.GetPublicKeyRequest, opts ...gax.CallOption) (*kmspb.PublicKey, error)
+	AsymmetricSign(ctx context.Context, req *kmspb.AsymmetricSignRequest, opts ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error)
+}
+
+// KMSSigner signs with a Cloud KMS asymmetric signing key version.
+// The private key never leaves Cloud KMS; only digests are sent for signing.
+type KMSSigner struct {
+	client     KMSClient
+	closer     io.Closer
+	keyVersion string
+	publicKey  *rsa.PublicKey
+}
+
+// NewKMSSigner creates a signer for the Cloud KMS key version
+// (projects/*/locations/*/keyRings/*/cryptoKeys/*/cryptoKeyVersions/*).
+func NewKMSSigner(ctx context.Context, keyVersion string) (*KMSSigner, error) {
+	client, err := kms.NewKeyManagementClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud KMS client: %w", err)
+	}
+
+	signer, err := NewKMSSignerWithClient(ctx, client, keyVersion)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	signer.closer = client
+
+	return signer, nil
+}
+
+// NewKMSSignerWithClient creates a signer for the Cloud KMS key version using the given client.
+// The key version must use an RSA PKCS#1 v1.5 SHA-256 algorithm, as required by GitHub.
+func NewKMSSignerWithClient(ctx context.Context, client KMSClient, keyVersion string) (*KMSSigner, error) {
+	publicKey, err := client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: keyVersion})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key from Cloud KMS: %w", err)
+	}
+
+	switch publicKey.GetAlgorithm() {
+	case kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256,
+		kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256:
+	default:
+		return nil, fmt.Errorf("unsupported Cloud KMS key algorithm %s (must be RSA_SIGN_PKCS1_*_SHA256)", publicKey.GetAlgorithm())
+	}
+
+	block, _ := pem.Decode([]byte(publicKey.GetPem()))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block from Cloud KMS public key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Cloud KMS public key: %w", err)
+	}
+	rsaPublicKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Cloud KMS public key is not an RSA key")
+	}
+
+	return &KMSSigner{
+		client:     client,
+		keyVersion: keyVersion,
+		publicKey:  rsaPublicKey,
+	}, nil
+}
+
+// Public returns the public key of the Cloud KMS key version.
+func (s *KMSSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs a SHA-256 digest with Cloud KMS.
+// Request and response integrity is verified with CRC32C checksums.
+func (s *KMSSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, fmt.Errorf("unsupported hash function %v (must be SHA-256)", opts.HashFunc())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kmsSignTimeout)
+	defer cancel()
+
+	resp, err := s.client.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{
+		Name:         s.keyVersion,
+		Digest:       &kmspb.Digest{Digest: &kmspb.Digest_Sha256{Sha256: digest}},
+		DigestCrc32C: wrapperspb.Int64(kmsCRC32C(digest)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with Cloud KMS: %w", err)
+	}
+
+	if !resp.GetVerifiedDigestCrc32C() || resp.GetName() != s.keyVersion ||
+		resp.GetSignatureCrc32C().GetValue() != kmsCRC32C(resp.GetSignature()) {
+		return nil, fmt.Errorf("Cloud KMS signing request corrupted in transit")
+	}
+
+	return resp.GetSignature(), nil
+}
+
+// Close releases the Cloud KMS client if it was created by NewKMSSigner.
+func (s *KMSSigner) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// kmsCRC32C returns the CRC32C checksum of data as used by Cloud KMS.
+func kmsCRC32C(data []byte) int64 {
+	return int64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+}
@@ -0,0 +1,47 @@
+//go:build pkcs11
+
+package main
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/ThalesGroup/crypto11"
+)
+
+// PKCS11Signer signs with a private key stored on a PKCS#11 token (HSM, SoftHSM, YubiHSM, etc.).
+// The private key never leaves the token.
+type PKCS11Signer struct {
+	crypto.Signer
+	context *crypto11.Context
+}
+
+// NewPKCS11Signer opens the token with the given label in the PKCS#11 module
+// and finds the key pair with the given label.
+func NewPKCS11Signer(modulePath, tokenLabel, pin, keyLabel string) (*PKCS11Signer, error) {
+	pkcs11Context, err := crypto11.Configure(&crypto11.Config{
+		Path:       modulePath,
+		TokenLabel: tokenLabel,
+		Pin:        pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PKCS#11 token '%s': %w", tokenLabel, err)
+	}
+
+	signer, err := pkcs11Context.FindKeyPair(nil, []byte(keyLabel))
+	if err != nil {
+		_ = pkcs11Context.Close()
+		return nil, fmt.Errorf("failed to find PKCS#11 key '%s': %w", keyLabel, err)
+	}
+	if signer == nil {
+		_ = pkcs11Context.Close()
+		return nil, fmt.Errorf("PKCS#11 key '%s' not found on token '%s'", keyLabel, tokenLabel)
+	}
+
+	return &PKCS11Signer{Signer: signer, context: pkcs11Context}, nil
+}
+
+// Close closes the PKCS#11 session.
+func (s *PKCS11Signer) Close() error {
+	return s.context.Close()
+}
@@ -0,0 +1,24 @@
+//go:build !pkcs11
+
+package main
+
+import (
+	"crypto"
+	"fmt"
+)
+
+// PKCS11Signer signs with a private key stored on a PKCS#11 token.
+// PKCS#11 support requires cgo and is only compiled in with the pkcs11 build tag.
+type PKCS11Signer struct {
+	crypto.Signer
+}
+
+// NewPKCS11Signer returns an error, because PKCS#11 support is not compiled in.
+func NewPKCS11Signer(modulePath, tokenLabel, pin, keyLabel string) (*PKCS11Signer, error) {
+	return nil, fmt.Errorf("PKCS#11 support is not compiled in (build with -tags pkcs11)")
+}
+
+// Close does nothing.
+func (s *PKCS11Signer) Close() error {
+	return nil
+}
@@ -0,0 +1,103 @@
+//go:build pkcs11
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// softHSMModulePaths lists common SoftHSM2 module locations.
+var softHSMModulePaths = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// setupSoftHSMToken initializes a SoftHSM2 token in a temporary directory and generates an RSA key on it.
+// The test is skipped if SoftHSM2 is not installed.
+func setupSoftHSMToken(t *testing.T, tokenLabel, pin, keyLabel string) string {
+	t.Helper()
+
+	modulePath := os.Getenv("SOFTHSM2_MODULE")
+	for _, candidate := range softHSMModulePaths {
+		if modulePath != "" {
+			break
+		}
+		if _, err := os.Stat(candidate); err == nil {
+			modulePath = candidate
+		}
+	}
+	if modulePath == "" {
+		t.Skip("SoftHSM2 module not found (set SOFTHSM2_MODULE)")
+	}
+	if _, err := exec.LookPath("softhsm2-util"); err != nil {
+		t.Skip("softhsm2-util not found")
+	}
+
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "softhsm2.conf")
+	conf := "directories.tokendir = " + dir + "\nobjectstore.backend = file\n"
+	if err := os.WriteFile(confPath, []byte(conf), 0o600); err != nil {
+		t.Fatalf("failed to write SoftHSM2 config: %v", err)
+	}
+	t.Setenv("SOFTHSM2_CONF", confPath)
+
+	output, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", tokenLabel, "--pin", pin, "--so-pin", pin).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to initialize SoftHSM2 token: %v: %s", err, output)
+	}
+
+	pkcs11Context, err := crypto11.Configure(&crypto11.Config{Path: modulePath, TokenLabel: tokenLabel, Pin: pin})
+	if err != nil {
+		t.Fatalf("failed to open SoftHSM2 token: %v", err)
+	}
+	defer func() { _ = pkcs11Context.Close() }()
+	if _, err := pkcs11Context.GenerateRSAKeyPairWithLabel([]byte("1"), []byte(keyLabel), 2048); err != nil {
+		t.Fatalf("failed to generate key on SoftHSM2 token: %v", err)
+	}
+
+	return modulePath
+}
+
+// TestPKCS11Signer tests signing GitHub App JWTs with a key on a SoftHSM2 token.
+//
+// Test steps:
+//  1. Initialize a SoftHSM2 token with an RSA key
+//  2. Create PKCS11Signer and a JWT
+//  3. Verify the JWT signature with the token's public key
+//  4. Verify a missing key label produces an error
+func TestPKCS11Signer(t *testing.T) {
+	// Step 1: Initialize token
+	modulePath := setupSoftHSMToken(t, "test-token", "1234", "github-app")
+
+	// Step 2: Create signer and JWT
+	signer, err := NewPKCS11Signer(modulePath, "test-token", "1234", "github-app")
+	if err != nil {
+		t.Fatalf("NewPKCS11Signer() error = %v", err)
+	}
+	defer func() { _ = signer.Close() }()
+
+	tokenString, err := CreateJWT(signer, "12345")
+	if err != nil {
+		t.Fatalf("CreateJWT() error = %v", err)
+	}
+
+	// Step 3: Verify signature
+	if _, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return signer.Public(), nil
+	}); err != nil {
+		t.Errorf("failed to verify JWT: %v", err)
+	}
+
+	// Step 4: Verify missing key
+	if _, err := NewPKCS11Signer(modulePath, "test-token", "1234", "missing"); err == nil {
+		t.Error("NewPKCS11Signer() error = nil, want error for missing key")
+	}
+}
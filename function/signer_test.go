@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// opaqueSigner hides the concrete key type, like signers backed by a key management system.
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+// fakeKMSClient is an in-memory KMSClient signing with a local RSA key.
+type fakeKMSClient struct {
+	key       *rsa.PrivateKey
+	algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+
+	// corruptSignature makes AsymmetricSign return a signature not matching its checksum.
+	corruptSignature bool
+}
+
+func (f *fakeKMSClient) GetPublicKey(ctx context.Context, req *kmspb.GetPublicKeyRequest, opts ...gax.CallOption) (*kmspb.PublicKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.PublicKey{
+		Name:      req.GetName(),
+		Algorithm: f.algorithm,
+		Pem:       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
+
+func (f *fakeKMSClient) AsymmetricSign(ctx context.Context, req *kmspb.AsymmetricSignRequest, opts ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error) {
+	digest := req.GetDigest().GetSha256()
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest)
+	if err != nil {
+		return nil, err
+	}
+	checksum := kmsCRC32C(signature)
+	if f.corruptSignature {
+		checksum++
+	}
+	return &kmspb.AsymmetricSignResponse{
+		Name:                 req.GetName(),
+		Signature:            signature,
+		SignatureCrc32C:      wrapperspb.Int64(checksum),
+		VerifiedDigestCrc32C: req.GetDigestCrc32C().GetValue() == kmsCRC32C(digest),
+	}, nil
+}
+
+// TestCreateJWT_Signer tests JWT creation with signers other than an in-memory *rsa.PrivateKey.
+//
+// Test steps:
+//  1. Create a signer hiding the RSA key type
+//  2. Call CreateJWT with the signer
+//  3. Verify the JWT signature with the public key
+//  4. Verify non-RSA signers are rejected
+func TestCreateJWT_Signer(t *testing.T) {
+	// Step 1: Create opaque signer
+	key := generateTestRSAKey(t)
+	signer := opaqueSigner{Signer: key}
+
+	// Step 2: Create JWT
+	tokenString, err := CreateJWT(signer, "12345")
+	if err != nil {
+		t.Fatalf("CreateJWT() error = %v", err)
+	}
+
+	// Step 3: Verify signature
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil || !token.Valid {
+		t.Errorf("failed to verify JWT: %v", err)
+	}
+
+	// Step 4: Verify non-RSA signer
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	if _, err := CreateJWT(ecKey, "12345"); err == nil || !strings.Contains(err.Error(), "not an RSA key") {
+		t.Errorf("CreateJWT() error = %v, want containing 'not an RSA key'", err)
+	}
+}
+
+// TestKMSSigner tests signing GitHub App JWTs with a Cloud KMS key version.
+//
+// Test steps:
+//  1. Create fake KMS client with the test case algorithm and behavior
+//  2. Create KMSSigner and a JWT
+//  3. Verify the JWT signature with the local public key
+//  4. Verify unsupported algorithms and corrupted responses produce errors
+func TestKMSSigner(t *testing.T) {
+	key := generateTestRSAKey(t)
+	keyVersion := "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+
+	tests := []struct {
+		name        string
+		client      *fakeKMSClient
+		errContains string
+	}{
+		{
+			name:   "RSA PKCS1 SHA-256 key",
+			client: &fakeKMSClient{key: key, algorithm: kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256},
+		},
+		{
+			name:        "PSS key is not supported",
+			client:      &fakeKMSClient{key: key, algorithm: kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256},
+			errContains: "unsupported Cloud KMS key algorithm",
+		},
+		{
+			name:        "corrupted signature",
+			client:      &fakeKMSClient{key: key, algorithm: kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256, corruptSignature: true},
+			errContains: "corrupted in transit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1 & 2: Create signer and JWT
+			signer, err := NewKMSSignerWithClient(context.Background(), tt.client, keyVersion)
+			var tokenString string
+			if err == nil {
+				tokenString, err = CreateJWT(signer, "12345")
+			}
+
+			// Step 4: Verify errors
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error = %v", err)
+			}
+
+			// Step 3: Verify signature
+			if _, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			}); err != nil {
+				t.Errorf("failed to verify JWT: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certificateCheckInterval is the minimum interval between checks of TLS files for changes.
+const certificateCheckInterval = 10 * time.Second
+
+// RunStandalone serves all endpoints with a plain net/http server, without the Functions Framework.
+// It blocks until ctx is cancelled (e.g., on SIGTERM) and then shuts down gracefully:
+// the readiness endpoint starts failing, new connections are refused
+// and in-flight requests are given ShutdownTimeout to complete.
+func RunStandalone(ctx context.Context, config *Config, server *Server) error {
+	listener, err := net.Listen("tcp", config.ListenAddress())
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", config.ListenAddress(), err)
+	}
+
+	return serveStandalone(ctx, listener, config, server.Routes(), server.startDraining)
+}
+
+// serveStandalone serves handler on listener until ctx is cancelled.
+// onShutdown is called right before the graceful shutdown starts.
+func serveStandalone(ctx context.Context, listener net.Listener, config *Config, handler http.Handler, onShutdown func()) error {
+	httpServer := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+
+	if config.TLSCertFile != "" {
+		reloader, err := newCertificateReloader(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		httpServer.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+		listener = tls.NewListener(listener, httpServer.TLSConfig)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving on %s (TLS: %t)", listener.Addr(), config.TLSCertFile != "")
+		serveErr <- httpServer.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", config.ShutdownTimeout)
+	if onShutdown != nil {
+		onShutdown()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server failed: %w", err)
+	}
+
+	return nil
+}
+
+// certificateReloader serves a TLS certificate and reloads it when its files change on disk,
+// so that rotated certificates (e.g., from cert-manager) are picked up without a restart.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	// checkInterval is the minimum interval between checks of the files for changes.
+	checkInterval time.Duration
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+	lastCheck   time.Time
+}
+
+// newCertificateReloader loads the certificate and key files.
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		checkInterval: certificateCheckInterval,
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the files changed.
+// If reloading fails, the previously loaded certificate is kept.
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= r.checkInterval {
+		r.lastCheck = time.Now()
+		if modTime, err := r.latestModTime(); err == nil && !modTime.Equal(r.modTime) {
+			if err := r.reloadLocked(); err != nil {
+				log.Printf("Failed to reload TLS certificate, keeping the previous one: %v", err)
+			}
+		}
+	}
+
+	return r.certificate, nil
+}
+
+// reload loads the certificate and key files.
+func (r *certificateReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+// reloadLocked loads the certificate and key files. The caller must hold r.mu.
+func (r *certificateReloader) reloadLocked() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.certificate = &certificate
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the latest modification time of the certificate and key files.
+func (r *certificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat TLS file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate and key for localhost to the given files.
+func writeTestCertificate(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+	key := generateTestRSAKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+// TestServeStandalone_GracefulShutdown tests that in-flight requests complete during shutdown.
+//
+// Test steps:
+//  1. Start standalone server with a handler that blocks until released
+//  2. Send a request and wait until it is in flight
+//  3. Cancel the server context
+//  4. Verify the shutdown callback is called while the request is still in flight
+//  5. Release the request and verify it completes with 200 OK
+//  6. Verify the server returns without error
+func TestServeStandalone_GracefulShutdown(t *testing.T) {
+	// Step 1: Start server with blocking handler
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	shutdownStarted := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- serveStandalone(ctx, listener, DefaultConfig(), handler, func() { close(shutdownStarted) })
+	}()
+
+	// Step 2: Send request
+	responseStatus := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/token")
+		if err != nil {
+			responseStatus <- 0
+			return
+		}
+		_ = resp.Body.Close()
+		responseStatus <- resp.StatusCode
+	}()
+	<-inFlight
+
+	// Step 3: Cancel context
+	cancel()
+
+	// Step 4: Verify shutdown started
+	select {
+	case <-shutdownStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown callback was not called")
+	}
+
+	// Step 5: Release request and verify it completes
+	close(release)
+	if status := <-responseStatus; status != http.StatusOK {
+		t.Errorf("in-flight request status = %v, want %v", status, http.StatusOK)
+	}
+
+	// Step 6: Verify server returns cleanly
+	select {
+	case err := <-serverDone:
+		if err != nil {
+			t.Errorf("serveStandalone() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveStandalone() did not return after shutdown")
+	}
+}
+
+// TestServeStandalone_TLS tests serving over TLS from certificate files.
+//
+// Test steps:
+//  1. Write a self-signed certificate and key
+//  2. Start standalone server with TLS configured
+//  3. Send a request to /healthz over TLS
+//  4. Verify response status is 200 OK
+func TestServeStandalone_TLS(t *testing.T) {
+	// Step 1: Write certificate
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.TLSCertFile = filepath.Join(dir, "tls.crt")
+	config.TLSKeyFile = filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, config.TLSCertFile, config.TLSKeyFile, 1)
+
+	// Step 2: Start server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- serveStandalone(ctx, listener, config, NewServer(testConfig()).Routes(), nil)
+	}()
+	defer func() {
+		cancel()
+		<-serverDone
+	}()
+
+	// Step 3: Send request over TLS
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // self-signed test certificate
+	}}
+	resp, err := client.Get("https://" + listener.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("TLS request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Step 4: Verify status
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("TLS request status = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+}
+
+// TestCertificateReloader tests that changed certificate files are picked up without a restart.
+//
+// Test steps:
+//  1. Write initial certificate and create reloader
+//  2. Replace certificate files with a new certificate and a later modification time
+//  3. Call GetCertificate
+//  4. Verify the new certificate is served
+func TestCertificateReloader(t *testing.T) {
+	// Step 1: Create reloader with initial certificate
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, certFile, keyFile, 1)
+
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertificateReloader() error = %v", err)
+	}
+	reloader.checkInterval = 0
+
+	// Step 2: Replace certificate
+	writeTestCertificate(t, certFile, keyFile, 2)
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatalf("failed to change modification time: %v", err)
+		}
+	}
+
+	// Step 3: Get certificate
+	certificate, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+
+	// Step 4: Verify new certificate
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	if leaf.SerialNumber.Int64() != 2 {
+		t.Errorf("GetCertificate() serial = %v, want 2 (reloaded)", leaf.SerialNumber)
+	}
+}
+
+// TestReadyzHandler_Draining tests that readiness fails once a graceful shutdown has started.
+//
+// Test steps:
+//  1. Create server and start draining
+//  2. Call ReadyzHandler
+//  3. Verify response status is 503 with status "shutting down"
+func TestReadyzHandler_Draining(t *testing.T) {
+	// Step 1: Start draining
+	server := NewServer(testConfig())
+	server.startDraining()
+
+	// Step 2: Call handler
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	w := httptest.NewRecorder()
+	server.ReadyzHandler(w, req)
+
+	// Step 3: Verify status
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ReadyzHandler() status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+	var resp HealthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Status != "shutting down" {
+		t.Errorf("ReadyzHandler() status = %v, want 'shutting down'", resp.Status)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -11,23 +12,37 @@ import (
 // Returns the repository in "owner/repo" format.
 // Note: GCP IAM has already validated the token signature, issuer, audience, and expiration.
 func ExtractRepositoryFromOIDC(token string) (string, error) {
+	claims, err := ExtractClaimsFromOIDC(token)
+	if err != nil {
+		return "", err
+	}
+	return repositoryFromClaims(claims)
+}
+
+// ExtractClaimsFromOIDC decodes the claims of an OIDC token without verifying it.
+func ExtractClaimsFromOIDC(token string) (map[string]interface{}, error) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
-		return "", fmt.Errorf("invalid JWT format")
+		return nil, fmt.Errorf("invalid JWT format")
 	}
 
 	// Decode the payload (middle part)
 	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return "", fmt.Errorf("failed to decode JWT payload: %w", err)
+		return nil, fmt.Errorf("failed to decode JWT payload: %w", err)
 	}
 
 	// Parse JSON claims
 	var claims map[string]interface{}
 	if err := json.Unmarshal(payload, &claims); err != nil {
-		return "", fmt.Errorf("failed to parse JWT claims: %w", err)
+		return nil, fmt.Errorf("failed to parse JWT claims: %w", err)
 	}
 
+	return claims, nil
+}
+
+// repositoryFromClaims returns the repository claim of a GitHub Actions OIDC token.
+func repositoryFromClaims(claims map[string]interface{}) (string, error) {
 	// Extract repository claim
 	repository, ok := claims["repository"].(string)
 	if !ok || repository == "" {
@@ -42,6 +57,38 @@ func ExtractRepositoryFromOIDC(token string) (string, error) {
 	return repository, nil
 }
 
+// repositoryIDFromClaims returns the repository_id claim of a GitHub Actions OIDC token.
+func repositoryIDFromClaims(claims map[string]interface{}) (int64, error) {
+	return idFromClaims(claims, "repository_id")
+}
+
+// repositoryOwnerIDFromClaims returns the repository_owner_id claim of a GitHub Actions OIDC token.
+func repositoryOwnerIDFromClaims(claims map[string]interface{}) (int64, error) {
+	return idFromClaims(claims, "repository_owner_id")
+}
+
+// idFromClaims returns a positive numeric ID claim.
+// GitHub sends IDs as strings; numbers are accepted too.
+func idFromClaims(claims map[string]interface{}, name string) (int64, error) {
+	var id int64
+	switch value := claims[name].(type) {
+	case string:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s claim: %s", name, value)
+		}
+		id = parsed
+	case float64:
+		id = int64(value)
+	default:
+		return 0, fmt.Errorf("%s claim not found in OIDC token", name)
+	}
+	if id <= 0 {
+		return 0, fmt.Errorf("invalid %s claim: %d", name, id)
+	}
+	return id, nil
+}
+
 // ValidateScopes validates requested scopes against allowlist and blacklist.
 // Checks for:
 // - Blacklisted scopes
@@ -169,6 +169,56 @@ func TestExtractRepositoryFromOIDC(t *testing.T) {
 	}
 }
 
+// TestRepositoryIDFromClaims tests parsing of the repository_id and repository_owner_id claims of GitHub Actions tokens.
+//
+// Test steps:
+//  1. Call repositoryIDFromClaims or repositoryOwnerIDFromClaims with the test case claims
+//  2. Verify the ID or the error
+func TestRepositoryIDFromClaims(t *testing.T) {
+	tests := []struct {
+		name        string
+		owner       bool
+		claims      map[string]interface{}
+		want        int64
+		wantErr     bool
+		errContains string
+	}{
+		{name: "string claim", claims: map[string]interface{}{"repository_id": "123456"}, want: 123456},
+		{name: "number claim", claims: map[string]interface{}{"repository_id": float64(42)}, want: 42},
+		{name: "missing claim", claims: map[string]interface{}{}, wantErr: true, errContains: "repository_id claim not found"},
+		{name: "not a number", claims: map[string]interface{}{"repository_id": "repo"}, wantErr: true, errContains: "invalid repository_id claim: repo"},
+		{name: "zero", claims: map[string]interface{}{"repository_id": "0"}, wantErr: true, errContains: "invalid repository_id claim: 0"},
+		{name: "owner ID", owner: true, claims: map[string]interface{}{"repository_owner_id": "7", "repository_id": "1"}, want: 7},
+		{name: "missing owner ID", owner: true, claims: map[string]interface{}{"repository_id": "1"}, wantErr: true, errContains: "repository_owner_id claim not found"},
+		{name: "negative owner ID", owner: true, claims: map[string]interface{}{"repository_owner_id": float64(-1)}, wantErr: true, errContains: "invalid repository_owner_id claim: -1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Step 1: Parse claim
+			parse := repositoryIDFromClaims
+			if tt.owner {
+				parse = repositoryOwnerIDFromClaims
+			}
+			got, err := parse(tt.claims)
+
+			// Step 2: Verify result
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("parse error = %v, want containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parse = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
 // TestValidateScopes tests the scope validation logic against allowlist and blacklist.
 // It verifies that valid scopes pass validation and invalid scopes are rejected with appropriate errors.
 //
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultApprovedWorkflowScopes are the scopes restricted to approved workflows when ApprovedWorkflows are configured.
+var DefaultApprovedWorkflowScopes = []string{"*:write"}
+
+// commitSHAPattern matches a full commit SHA.
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// WorkflowRule approves a reusable workflow by its job_workflow_ref and job_workflow_sha claims.
+type WorkflowRule struct {
+	// Workflow is a glob matched against the workflow path of job_workflow_ref (before "@"),
+	// e.g. "my-org/platform-workflows/.github/workflows/*.yml". "*" doesn't match "/".
+	Workflow string `yaml:"workflow"`
+
+	// Ref is a glob matched against the ref of job_workflow_ref (after "@"), e.g. "refs/tags/v*".
+	Ref string `yaml:"ref"`
+
+	// SHA is the required job_workflow_sha. At least one of Ref and SHA is required.
+	SHA string `yaml:"sha"`
+}
+
+// validate checks the rule.
+func (r *WorkflowRule) validate() error {
+	if r.Workflow == "" {
+		return fmt.Errorf("workflow is required")
+	}
+	if r.Ref == "" && r.SHA == "" {
+		return fmt.Errorf("ref or sha is required")
+	}
+	for _, pattern := range []string{r.Workflow, r.Ref} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+	if r.SHA != "" && !commitSHAPattern.MatchString(r.SHA) {
+		return fmt.Errorf("sha '%s' must be a full lowercase commit SHA", r.SHA)
+	}
+	return nil
+}
+
+// matches reports whether the job_workflow_ref and job_workflow_sha claims match the rule.
+func (r *WorkflowRule) matches(workflowRef, workflowSHA string) bool {
+	workflow, ref, ok := strings.Cut(workflowRef, "@")
+	if !ok {
+		return false
+	}
+	if matched, _ := path.Match(r.Workflow, workflow); !matched {
+		return false
+	}
+	if r.Ref != "" {
+		if matched, _ := path.Match(r.Ref, ref); !matched {
+			return false
+		}
+	}
+	return r.SHA == "" || r.SHA == workflowSHA
+}
+
+// restrictedScopes returns the requested scopes matching a "scope:level" entry, sorted.
+// "*" matches every scope, and a read entry also covers write.
+func restrictedScopes(entries []string, scopes map[string]string) []string {
+	var restricted []string
+	for scopeID, permission := range scopes {
+		for _, entry := range entries {
+			entryScope, level, _ := strings.Cut(entry, ":")
+			if (entryScope == "*" || entryScope == scopeID) && permissionRank[permission] >= permissionRank[level] {
+				restricted = append(restricted, scopeID+":"+permission)
+				break
+			}
+		}
+	}
+	sort.Strings(restricted)
+	return restricted
+}
+
+// ApplyWorkflowPolicy checks that ApprovedWorkflowScopes and privileged scopes are only requested by jobs
+// of an approved reusable workflow. It applies only when ApprovedWorkflows are configured.
+// Returns a *PolicyError if the job doesn't match any rule.
+func ApplyWorkflowPolicy(config *Config, claims *OIDCClaims, scopes map[string]string) error {
+	if len(config.ApprovedWorkflows) == 0 {
+		return nil
+	}
+
+	restricted := restrictedScopes(append(append([]string(nil), config.ApprovedWorkflowScopes...), config.PrivilegedScopes...), scopes)
+	if len(restricted) == 0 {
+		return nil
+	}
+
+	workflowRef, workflowSHA := claims.Claim("job_workflow_ref"), claims.Claim("job_workflow_sha")
+	if claims.Provider == "github-actions" {
+		for _, rule := range config.ApprovedWorkflows {
+			if rule.matches(workflowRef, workflowSHA) {
+				return nil
+			}
+		}
+	}
+
+	return &PolicyError{
+		Message: fmt.Sprintf("scopes (%s) are only issued to approved reusable workflows (job_workflow_ref '%s')", strings.Join(restricted, ", "), workflowRef),
+		Details: map[string]interface{}{
+			"restricted_scopes": restricted,
+			"job_workflow_ref":  workflowRef,
+			"job_workflow_sha":  workflowSHA,
+		},
+	}
+}
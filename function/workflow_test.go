@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestApplyWorkflowPolicy tests that restricted scopes are only issued to approved reusable workflows.
+//
+// Test steps:
+//  1. Configure approved workflow rules pinned by tag and by commit SHA
+//  2. Apply the policy to the requested scopes with the job_workflow_ref and job_workflow_sha claims
+//  3. Verify approved jobs and unrestricted scopes pass, and other jobs are denied
+func TestApplyWorkflowPolicy(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+
+	// Step 1: Configure rules
+	config := testConfig()
+	config.ApprovedWorkflows = []WorkflowRule{
+		{Workflow: "platform/workflows/.github/workflows/release-*.yml", Ref: "refs/tags/v*"},
+		{Workflow: "platform/workflows/.github/workflows/deploy.yml", SHA: sha},
+	}
+
+	tests := []struct {
+		name        string
+		provider    string
+		scopes      map[string]string
+		claims      map[string]interface{}
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:   "read scopes are not restricted",
+			scopes: map[string]string{"contents": "read"},
+			claims: map[string]interface{}{"job_workflow_ref": "owner/repo/.github/workflows/ci.yml@refs/heads/main"},
+		},
+		{
+			name:   "approved workflow pinned by tag",
+			scopes: map[string]string{"contents": "write"},
+			claims: map[string]interface{}{"job_workflow_ref": "platform/workflows/.github/workflows/release-npm.yml@refs/tags/v1.2.0"},
+		},
+		{
+			name:   "approved workflow pinned by SHA",
+			scopes: map[string]string{"contents": "write"},
+			claims: map[string]interface{}{"job_workflow_ref": "platform/workflows/.github/workflows/deploy.yml@refs/heads/main", "job_workflow_sha": sha},
+		},
+		{
+			name:        "approved workflow on a branch",
+			scopes:      map[string]string{"contents": "write"},
+			claims:      map[string]interface{}{"job_workflow_ref": "platform/workflows/.github/workflows/release-npm.yml@refs/heads/main"},
+			wantErr:     true,
+			errContains: "scopes (contents:write) are only issued to approved reusable workflows",
+		},
+		{
+			name:        "approved workflow at another SHA",
+			scopes:      map[string]string{"contents": "write"},
+			claims:      map[string]interface{}{"job_workflow_ref": "platform/workflows/.github/workflows/deploy.yml@refs/heads/main", "job_workflow_sha": strings.Repeat("f", 40)},
+			wantErr:     true,
+			errContains: "deploy.yml@refs/heads/main",
+		},
+		{
+			name:        "glob does not match nested paths",
+			scopes:      map[string]string{"contents": "write"},
+			claims:      map[string]interface{}{"job_workflow_ref": "platform/workflows/.github/workflows/release-x/y.yml@refs/tags/v1"},
+			wantErr:     true,
+			errContains: "approved reusable workflows",
+		},
+		{
+			name:        "privileged read scope from repository workflow",
+			scopes:      map[string]string{"administration": "read"},
+			claims:      map[string]interface{}{"job_workflow_ref": "owner/repo/.github/workflows/ci.yml@refs/heads/main"},
+			wantErr:     true,
+			errContains: "scopes (administration:read)",
+		},
+		{
+			name:        "other CI provider",
+			provider:    "gitlab",
+			scopes:      map[string]string{"contents": "write"},
+			claims:      map[string]interface{}{"job_workflow_ref": "platform/workflows/.github/workflows/release-npm.yml@refs/tags/v1.2.0"},
+			wantErr:     true,
+			errContains: "approved reusable workflows",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := tt.provider
+			if provider == "" {
+				provider = "github-actions"
+			}
+
+			// Step 2: Apply policy
+			err := ApplyWorkflowPolicy(config, &OIDCClaims{Repository: "owner/repo", Provider: provider, Claims: tt.claims}, tt.scopes)
+
+			// Step 3: Verify
+			if tt.wantErr {
+				var policyErr *PolicyError
+				if !errors.As(err, &policyErr) || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("ApplyWorkflowPolicy() error = %v, want *PolicyError containing %q", err, tt.errContains)
+				}
+				if policyErr.Details["job_workflow_ref"] != tt.claims["job_workflow_ref"] {
+					t.Errorf("Details = %v, want job_workflow_ref", policyErr.Details)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("ApplyWorkflowPolicy() unexpected error = %v", err)
+			}
+		})
+	}
+}
+
+// TestApplyWorkflowPolicy_NotConfigured tests that no restriction applies without approved workflows.
+//
+// Test steps:
+//  1. Apply the policy with the default configuration to write scopes of a repository workflow
+//  2. Verify no error is returned
+func TestApplyWorkflowPolicy_NotConfigured(t *testing.T) {
+	// Step 1: Apply policy
+	claims := &OIDCClaims{Repository: "owner/repo", Provider: "github-actions", Claims: map[string]interface{}{}}
+	err := ApplyWorkflowPolicy(testConfig(), claims, map[string]string{"contents": "write"})
+
+	// Step 2: Verify
+	if err != nil {
+		t.Errorf("ApplyWorkflowPolicy() unexpected error = %v", err)
+	}
+}
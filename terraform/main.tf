@@ -33,6 +33,13 @@ resource "google_secret_manager_secret_iam_member" "secret_accessor" {
   member    = "serviceAccount:${google_service_account.cloud_run_sa.email}"
 }
 
+# Grant listing of secret versions, to use previous key versions during key rotation
+resource "google_secret_manager_secret_iam_member" "secret_viewer" {
+  secret_id = "github-app-private-key"
+  role      = "roles/secretmanager.viewer"
+  member    = "serviceAccount:${google_service_account.cloud_run_sa.email}"
+}
+
 # Cloud Run service
 resource "google_cloud_run_v2_service" "github_token_issuer" {
   name     = "github-repository-token-issuer"